@@ -0,0 +1,122 @@
+package gmicro
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+const (
+	// WeightedRoundRobin is the balancer name which picks the instances by the smooth
+	// weighted round-robin algorithm, the weight is set by the instance metadata.
+	WeightedRoundRobin = "gmicro_weighted_round_robin"
+
+	// LeastRequest is the balancer name which picks the instance with the least
+	// in-flight requests.
+	LeastRequest = "gmicro_least_request"
+)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(WeightedRoundRobin, &wrrPickerBuilder{}, base.Config{HealthCheck: true}))
+	balancer.Register(base.NewBalancerBuilder(LeastRequest, &lrPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+type wrrPickerBuilder struct{}
+
+// Build implements base.PickerBuilder interface.
+func (*wrrPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &wrrPicker{}
+	for sc, sci := range info.ReadySCs {
+		weight, ok := sci.Address.Attributes.Value(weightKey{}).(int)
+		if !ok || weight < 1 {
+			weight = 1
+		}
+
+		p.items = append(p.items, &wrrItem{sc: sc, weight: weight})
+	}
+
+	return p
+}
+
+type wrrItem struct {
+	sc      balancer.SubConn
+	weight  int
+	current int
+}
+
+type wrrPicker struct {
+	mu    sync.Mutex
+	items []*wrrItem
+}
+
+// Pick implements balancer.Picker interface.
+// refer: https://github.com/phusion/nginx/commit/27e94984486058d73157038f7950a0a36ecc6e35
+func (p *wrrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *wrrItem
+	total := 0
+	for _, item := range p.items {
+		item.current += item.weight
+		total += item.weight
+		if best == nil || item.current > best.current {
+			best = item
+		}
+	}
+
+	best.current -= total
+	return balancer.PickResult{SubConn: best.sc}, nil
+}
+
+type lrPickerBuilder struct{}
+
+// Build implements base.PickerBuilder interface.
+func (*lrPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &lrPicker{}
+	for sc := range info.ReadySCs {
+		p.items = append(p.items, &lrItem{sc: sc})
+	}
+
+	return p
+}
+
+type lrItem struct {
+	sc       balancer.SubConn
+	inflight int64
+}
+
+type lrPicker struct {
+	next  uint32 // the start index of the next pick, so that the ties are picked in turn
+	items []*lrItem
+}
+
+// Pick implements balancer.Picker interface.
+func (p *lrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	start := int(atomic.AddUint32(&p.next, 1))
+	var best *lrItem
+	for i := range p.items {
+		item := p.items[(start+i)%len(p.items)]
+		if best == nil || atomic.LoadInt64(&item.inflight) < atomic.LoadInt64(&best.inflight) {
+			best = item
+		}
+	}
+
+	atomic.AddInt64(&best.inflight, 1)
+	return balancer.PickResult{
+		SubConn: best.sc,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(&best.inflight, -1)
+		},
+	}, nil
+}
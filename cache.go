@@ -0,0 +1,450 @@
+package gmicro
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	// CacheStatusHeader is the gRPC header metadata of the cache status of the reply, HIT or MISS.
+	CacheStatusHeader = "x-cache"
+
+	// the gRPC header metadata of the cached replies, the gateway returns them as
+	// the ETag and Cache-Control http headers.
+	cacheETagMDKey         = "etag"
+	cacheCacheControlMDKey = "cache-control"
+
+	// the default limits of the response cache
+	defaultCacheTTL          = time.Minute
+	defaultCacheMaxEntries   = 1000
+	defaultCacheMaxBytes     = 32 << 20
+	defaultCacheMaxEntrySize = 1 << 20
+)
+
+// cacheRequests counts the lookups of the response cache.
+var cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gmicro",
+	Name:      "response_cache_requests_total",
+	Help:      "Total number of the lookups of the response cache by the result, hit or miss.",
+}, []string{"method", "result"})
+
+func init() {
+	prometheus.MustRegister(cacheRequests)
+}
+
+// ResponseCache is the config of caching the replies of the read-only unary RPCs, the key
+// of the reply is the full method, the deterministic proto encoding of the request, the tenant,
+// the authorization metadata of the caller and the VaryMetadata. The concurrent misses of the same key are collapsed into one call.
+//
+// The cache is the innermost interceptor, so the user interceptors such as the authorization
+// are applied to the cached replies too. The requests with the no-cache Cache-Control header
+// or metadata bypass the cached reply. The gateway returns the ETag and Cache-Control headers
+// of the cached replies, and 304 Not Modified for the requests with the matched If-None-Match.
+type ResponseCache struct {
+	// Methods the TTL of the cached methods, the key is the gRPC full method,
+	// eg: /App.Grpc.Hello.GreeterService/SayHello, the key ending with * matches the
+	// methods having its prefix, the longest one is used. 0 TTL means the default 1 minute.
+	Methods map[string]time.Duration
+
+	// VaryMetadata the metadata keys included in the cache key, eg: accept-language.
+	VaryMetadata []string
+
+	// Shared excludes the authorization metadata from the cache key, so the cached replies are
+	// shared by all the callers. It is unsafe unless the replies do not depend on the caller.
+	Shared bool
+
+	// MaxEntries the max number of the cached replies, default: 1000
+	MaxEntries int
+
+	// MaxBytes the max total size of the cached replies, default: 32MB
+	MaxBytes int64
+
+	// MaxEntrySize the replies larger than it are not cached, default: 1MB
+	MaxEntrySize int64
+}
+
+// responseCache is the LRU cache of the marshalled replies.
+type responseCache struct {
+	ResponseCache
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List // the front is the most recently used
+	calls   map[string]*cacheCall
+}
+
+type cacheEntry struct {
+	key       string
+	replyType string
+	reply     []byte
+	etag      string
+	expireAt  time.Time
+}
+
+// cacheCall is the in-flight call of a cache miss, the waiting calls share its entry.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry // nil if the call fails
+}
+
+func newResponseCache(c ResponseCache) *responseCache {
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = defaultCacheMaxEntries
+	}
+
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = defaultCacheMaxBytes
+	}
+
+	if c.MaxEntrySize <= 0 {
+		c.MaxEntrySize = defaultCacheMaxEntrySize
+	}
+
+	return &responseCache{
+		ResponseCache: c,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+		calls:         make(map[string]*cacheCall),
+	}
+}
+
+// ttl returns the TTL of the method, false is returned if the method is not cached.
+func (c *responseCache) ttl(method string) (time.Duration, bool) {
+	ttl, ok := c.Methods[method]
+	if !ok {
+		prefix := ""
+		for m, t := range c.Methods {
+			p := strings.TrimSuffix(m, "*")
+			if strings.HasSuffix(m, "*") && strings.HasPrefix(method, p) && len(p) >= len(prefix) {
+				prefix, ttl, ok = p, t, true
+			}
+		}
+	}
+
+	if ok && ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	return ttl, ok
+}
+
+// key returns the cache key of the request.
+func (c *responseCache) key(ctx context.Context, method string, req proto.Message) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	tenant, _ := TenantID.From(ctx)
+	md := GetIncomingMD(ctx)
+	h.Write([]byte(method + "\x00" + tenant + "\x00"))
+	if !c.Shared {
+		h.Write([]byte(callerHash(ctx) + "\x00"))
+	}
+
+	for _, k := range c.VaryMetadata {
+		h.Write([]byte(strings.Join(md.Get(k), ",") + "\x00"))
+	}
+
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// get returns the unexpired entry of the key.
+func (c *responseCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.remove(el)
+		return nil
+	}
+
+	c.lru.MoveToFront(el)
+	return entry
+}
+
+// set adds the entry and evicts the least recently used ones, c.mu must be held.
+func (c *responseCache) set(entry *cacheEntry) {
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += int64(len(entry.reply))
+	for c.lru.Len() > c.MaxEntries || c.size > c.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the entry, c.mu must be held.
+func (c *responseCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	c.size -= int64(len(entry.reply))
+	delete(c.entries, entry.key)
+}
+
+// do calls the handler for the cache miss, the concurrent misses of the key wait for
+// the first call and share its entry, they call the handler by themselves if it fails.
+// The shared entry is returned with shared true, otherwise the reply of the handler is
+// returned with the entry cached from it, the entry is nil if the reply is not cached.
+func (c *responseCache) do(ctx context.Context, key string, ttl time.Duration, req interface{},
+	handler grpc.UnaryHandler) (reply interface{}, entry *cacheEntry, shared bool, err error) {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, nil, false, ctx.Err()
+		}
+
+		if call.entry != nil {
+			return nil, call.entry, true, nil
+		}
+
+		reply, err = handler(ctx, req)
+		return reply, nil, false, err
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		if call.entry != nil {
+			c.set(call.entry)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	reply, err = handler(ctx, req)
+	if err != nil {
+		return reply, nil, false, err
+	}
+
+	if msg, ok := reply.(proto.Message); ok {
+		if b, e := proto.Marshal(msg); e == nil && int64(len(b)) <= c.MaxEntrySize {
+			sum := sha256.Sum256(b)
+			call.entry = &cacheEntry{
+				key:       key,
+				replyType: string(proto.MessageName(msg)),
+				reply:     b,
+				etag:      `"` + hex.EncodeToString(sum[:16]) + `"`,
+				expireAt:  time.Now().Add(ttl),
+			}
+		}
+	}
+
+	return reply, call.entry, false, nil
+}
+
+// noCache reports whether the request has the no-cache Cache-Control,
+// the gateway forwards the Cache-Control header as grpcgateway-cache-control.
+func noCache(md metadata.MD) bool {
+	for _, k := range []string{"cache-control", gRuntime.MetadataPrefix + "cache-control"} {
+		for _, v := range md.Get(k) {
+			if strings.Contains(strings.ToLower(v), "no-cache") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// cacheUnaryInterceptor returns the cached replies of the configured methods.
+func (s *Service) cacheUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	ttl, ok := s.responseCache.ttl(info.FullMethod)
+	reqMsg, isProto := req.(proto.Message)
+	if !ok || !isProto {
+		return handler(ctx, req)
+	}
+
+	key, err := s.responseCache.key(ctx, info.FullMethod, reqMsg)
+	if err != nil {
+		return handler(ctx, req)
+	}
+
+	if !noCache(GetIncomingMD(ctx)) {
+		if entry := s.responseCache.get(key); entry != nil {
+			cacheRequests.WithLabelValues(info.FullMethod, "hit").Inc()
+			return s.responseCache.replyFromCache(ctx, entry, "HIT")
+		}
+	}
+
+	cacheRequests.WithLabelValues(info.FullMethod, "miss").Inc()
+	reply, entry, shared, err := s.responseCache.do(ctx, key, ttl, req, handler)
+	if err != nil {
+		return reply, err
+	}
+
+	// the reply of the collapsed call
+	if shared {
+		return s.responseCache.replyFromCache(ctx, entry, "HIT")
+	}
+
+	if entry != nil {
+		s.responseCache.setCacheHeader(ctx, entry, "MISS")
+	}
+
+	return reply, nil
+}
+
+// replyFromCache unmarshals the cached reply, so the callers never share the reply message.
+func (c *responseCache) replyFromCache(ctx context.Context, entry *cacheEntry, cacheStatus string) (interface{}, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(entry.replyType))
+	if err != nil {
+		return nil, err
+	}
+
+	reply := mt.New().Interface()
+	if err = proto.Unmarshal(entry.reply, reply); err != nil {
+		return nil, err
+	}
+
+	c.setCacheHeader(ctx, entry, cacheStatus)
+	return reply, nil
+}
+
+// setCacheHeader sets the cache status, ETag and Cache-Control of the entry to the gRPC header,
+// the replies shared by all the callers are public, the others are private to the caller.
+func (c *responseCache) setCacheHeader(ctx context.Context, entry *cacheEntry, cacheStatus string) {
+	maxAge := int64(time.Until(entry.expireAt).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	visibility := "private"
+	if c.Shared {
+		visibility = "public"
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(
+		CacheStatusHeader, cacheStatus,
+		cacheETagMDKey, entry.etag,
+		cacheCacheControlMDKey, visibility+", max-age="+strconv.FormatInt(maxAge, 10),
+	))
+}
+
+// cacheForwardResponse sets the ETag and Cache-Control http headers from the gRPC header
+// of the cached replies, it is the ForwardResponseOption of the gateway.
+func cacheForwardResponse(ctx context.Context, w http.ResponseWriter, _ proto.Message) error {
+	md, ok := gRuntime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	for header, key := range map[string]string{"ETag": cacheETagMDKey, "Cache-Control": cacheCacheControlMDKey} {
+		if values := md.HeaderMD.Get(key); len(values) > 0 {
+			w.Header().Del(gRuntime.MetadataHeaderPrefix + key)
+			w.Header().Set(header, values[0])
+		}
+	}
+
+	return nil
+}
+
+// cacheHandler responds 304 Not Modified to the GET and HEAD requests
+// whose If-None-Match matches the ETag of the response.
+func cacheHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch := r.Header.Get("If-None-Match")
+		if ifNoneMatch == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		h.ServeHTTP(&notModifiedResponseWriter{ResponseWriter: w, ifNoneMatch: ifNoneMatch}, r)
+	})
+}
+
+// notModifiedResponseWriter replaces the 200 response whose ETag matches ifNoneMatch with 304.
+type notModifiedResponseWriter struct {
+	http.ResponseWriter
+	ifNoneMatch string
+	written     bool
+	notModified bool
+}
+
+func (w *notModifiedResponseWriter) WriteHeader(status int) {
+	if w.written {
+		return
+	}
+
+	w.written = true
+	etag := w.Header().Get("ETag")
+	if status == http.StatusOK && etag != "" && etagMatch(w.ifNoneMatch, etag) {
+		w.notModified = true
+		w.Header().Del("Content-Length")
+		w.Header().Del("Content-Type")
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *notModifiedResponseWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.notModified {
+		return len(b), nil
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher interface.
+func (w *notModifiedResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.notModified {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped http.ResponseWriter.
+func (w *notModifiedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// etagMatch reports whether the If-None-Match header matches the etag by the weak comparison.
+func etagMatch(ifNoneMatch string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
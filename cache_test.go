@@ -0,0 +1,230 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestResponseCacheLimits(t *testing.T) {
+	var should = require.New(t)
+	c := newResponseCache(ResponseCache{
+		Methods: map[string]time.Duration{
+			"/App.Grpc.Hello.GreeterService/*":        time.Second,
+			"/App.Grpc.Hello.GreeterService/SayHello": 0,
+			"/App.Grpc.Hello.*":                       time.Hour,
+		},
+		MaxEntries: 2,
+		MaxBytes:   10,
+	})
+
+	ttl, ok := c.ttl("/App.Grpc.Hello.GreeterService/SayHello")
+	should.True(ok)
+	should.Equal(defaultCacheTTL, ttl)
+	ttl, ok = c.ttl("/App.Grpc.Hello.GreeterService/Other")
+	should.True(ok)
+	should.Equal(time.Second, ttl)
+	_, ok = c.ttl("/Other/Method")
+	should.False(ok)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("accept-language", "en"))
+	k1, err := c.key(ctx, "/a", &pb.HelloReq{Name: "a"})
+	should.NoError(err)
+	k2, err := c.key(TenantID.With(ctx, "acme"), "/a", &pb.HelloReq{Name: "a"})
+	should.NoError(err)
+	should.NotEqual(k1, k2)
+
+	c.VaryMetadata = []string{"accept-language"}
+	k3, err := c.key(metadata.NewIncomingContext(context.Background(), metadata.Pairs("accept-language", "zh")),
+		"/a", &pb.HelloReq{Name: "a"})
+	should.NoError(err)
+	k4, err := c.key(ctx, "/a", &pb.HelloReq{Name: "a"})
+	should.NoError(err)
+	should.NotEqual(k3, k4)
+
+	// the callers do not share the cached replies unless Shared is set
+	alice := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer alice"))
+	bob := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer bob"))
+	k5, err := c.key(alice, "/a", &pb.HelloReq{Name: "a"})
+	should.NoError(err)
+	k6, err := c.key(bob, "/a", &pb.HelloReq{Name: "a"})
+	should.NoError(err)
+	should.NotEqual(k5, k6)
+	c.Shared = true
+	k5, err = c.key(alice, "/a", &pb.HelloReq{Name: "a"})
+	should.NoError(err)
+	k6, err = c.key(bob, "/a", &pb.HelloReq{Name: "a"})
+	should.NoError(err)
+	should.Equal(k5, k6)
+
+	set := func(key string, size int) {
+		c.mu.Lock()
+		c.set(&cacheEntry{key: key, reply: make([]byte, size), expireAt: time.Now().Add(time.Hour)})
+		c.mu.Unlock()
+	}
+
+	// c evicts the least recently used b
+	set("a", 1)
+	set("b", 1)
+	should.NotNil(c.get("a"))
+	set("c", 1)
+	should.Nil(c.get("b"))
+	should.NotNil(c.get("a"))
+
+	// the total size exceeds MaxBytes, the least recently used c is evicted
+	set("d", 8)
+	should.Nil(c.get("c"))
+	should.NotNil(c.get("a"))
+	should.NotNil(c.get("d"))
+	should.Equal(int64(9), c.size)
+
+	c.mu.Lock()
+	c.set(&cacheEntry{key: "e", expireAt: time.Now().Add(-time.Second)})
+	c.mu.Unlock()
+	should.Nil(c.get("e"))
+
+	should.True(etagMatch(`W/"x", "y"`, `"x"`))
+	should.True(etagMatch("*", `"x"`))
+	should.False(etagMatch(`"y"`, `"x"`))
+}
+
+func TestResponseCache(t *testing.T) {
+	var should = require.New(t)
+	greeter := &countingGreeterService{started: make(chan struct{}), release: make(chan struct{})}
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithResponseCache(ResponseCache{Methods: map[string]time.Duration{"/App.Grpc.Hello.GreeterService/*": time.Hour}}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, greeter)
+
+	addr := startTestServer(t, s)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	client := pb.NewGreeterServiceClient(conn)
+	call := func(ctx context.Context, name string) (*pb.HelloReply, metadata.MD, error) {
+		var header metadata.MD
+		reply, err := client.SayHello(ctx, &pb.HelloReq{Name: name}, grpc.Header(&header))
+		return reply, header, err
+	}
+
+	reply, header, err := call(context.Background(), "daheige")
+	should.NoError(err)
+	should.Equal("1", reply.Message)
+	should.Equal([]string{"MISS"}, header.Get(CacheStatusHeader))
+
+	reply, header, err = call(context.Background(), "daheige")
+	should.NoError(err)
+	should.Equal("1", reply.Message)
+	should.Equal([]string{"HIT"}, header.Get(CacheStatusHeader))
+	should.NotEmpty(header.Get(cacheETagMDKey))
+
+	// no-cache bypasses and refreshes the cached reply
+	reply, _, err = call(metadata.AppendToOutgoingContext(context.Background(), "cache-control", "no-cache"), "daheige")
+	should.NoError(err)
+	should.Equal("2", reply.Message)
+	reply, _, err = call(context.Background(), "daheige")
+	should.NoError(err)
+	should.Equal("2", reply.Message)
+
+	// the failed reply is not cached
+	_, _, err = call(context.Background(), "fail")
+	should.Equal(codes.Unavailable, status.Code(err))
+	_, _, err = call(context.Background(), "fail")
+	should.Equal(codes.Unavailable, status.Code(err))
+	should.Equal(int64(4), atomic.LoadInt64(&greeter.calls))
+
+	// the concurrent misses are collapsed into one call
+	replies := make(chan *pb.HelloReply, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			reply, _, err := call(context.Background(), "slow")
+			assert.NoError(t, err)
+			replies <- reply
+		}()
+	}
+
+	<-greeter.started
+	time.Sleep(50 * time.Millisecond)
+	close(greeter.release)
+	should.Equal("5", (<-replies).GetMessage())
+	should.Equal("5", (<-replies).GetMessage())
+	should.Equal(int64(5), atomic.LoadInt64(&greeter.calls))
+
+	// the gateway returns the ETag, and 304 for the matched If-None-Match
+	get := func(ifNoneMatch string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/v1/say/gateway", nil)
+		should.NoError(err)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+		return res
+	}
+
+	res := get("")
+	var body map[string]interface{}
+	should.NoError(json.NewDecoder(res.Body).Decode(&body))
+	res.Body.Close()
+	should.Equal(http.StatusOK, res.StatusCode)
+	should.Equal("6", body["message"])
+	etag := res.Header.Get("ETag")
+	should.NotEmpty(etag)
+	should.Contains(res.Header.Get("Cache-Control"), "private, max-age=")
+
+	res = get(etag)
+	res.Body.Close()
+	should.Equal(http.StatusNotModified, res.StatusCode)
+	should.Equal(etag, res.Header.Get("ETag"))
+
+	res = get(`"other"`)
+	res.Body.Close()
+	should.Equal(http.StatusOK, res.StatusCode)
+	should.Equal(int64(6), atomic.LoadInt64(&greeter.calls))
+}
+
+func TestResponseCacheShared(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithResponseCache(ResponseCache{
+			Methods: map[string]time.Duration{"/App.Grpc.Hello.GreeterService/*": time.Hour},
+			Shared:  true,
+		}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &countingGreeterService{})
+
+	addr := startTestServer(t, s)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	// the shared reply is the same for every caller, so it can be stored by the public caches
+	client := pb.NewGreeterServiceClient(conn)
+	for _, cacheStatus := range []string{"MISS", "HIT"} {
+		var header metadata.MD
+		_, err = client.SayHello(context.Background(), &pb.HelloReq{Name: "daheige"}, grpc.Header(&header))
+		should.NoError(err)
+		should.Equal([]string{cacheStatus}, header.Get(CacheStatusHeader))
+		should.Len(header.Get(cacheCacheControlMDKey), 1)
+		should.Regexp(`^public, max-age=\d+$`, header.Get(cacheCacheControlMDKey)[0])
+	}
+}
@@ -0,0 +1,422 @@
+package gmicro
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // register the gzip compressor of gRPC
+)
+
+const (
+	// EncodingGzip the gzip content encoding and gRPC compressor name.
+	EncodingGzip = "gzip"
+
+	// EncodingBrotli the brotli content encoding.
+	EncodingBrotli = "br"
+
+	// EncodingZstd the zstd content encoding and gRPC compressor name.
+	EncodingZstd = "zstd"
+
+	// the default min size of the response to be compressed
+	defaultCompressionMinSize = 1024
+)
+
+// defaultCompressionTypes the default content types of the responses to be compressed.
+var defaultCompressionTypes = []string{
+	"application/json", "application/javascript", "application/xml",
+	"image/svg+xml", "text/html", "text/css", "text/plain", "text/xml", "text/javascript",
+}
+
+// registerZstdCompressorOnce registers zstdCompressor when it is the gRPC compressor of the service.
+var registerZstdCompressorOnce sync.Once
+
+// registerZstdCompressor registers zstdCompressor as the gRPC compressor EncodingZstd.
+// It is called before the server starts since encoding.RegisterCompressor is not thread safe.
+func registerZstdCompressor() {
+	registerZstdCompressorOnce.Do(func() {
+		encoding.RegisterCompressor(newZstdCompressor())
+	})
+}
+
+// Compression is the http gateway response compression config,
+// the encoding is negotiated by the Accept-Encoding request header.
+type Compression struct {
+	// Encodings the supported encodings in preferred order, default: br, zstd, gzip
+	Encodings []string
+
+	// MinSize the response smaller than MinSize bytes is not compressed, default: 1024
+	MinSize int
+
+	// ContentTypes the media types of the responses to be compressed,
+	// the type ending with "/" matches the prefix, eg: text/
+	// default: application/json, application/javascript, application/xml, image/svg+xml,
+	// text/html, text/css, text/plain, text/xml, text/javascript
+	ContentTypes []string
+}
+
+type compression struct {
+	Compression
+	pools map[string]*sync.Pool
+}
+
+func newCompression(c Compression) *compression {
+	if len(c.Encodings) == 0 {
+		c.Encodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+	}
+
+	if c.MinSize <= 0 {
+		c.MinSize = defaultCompressionMinSize
+	}
+
+	if len(c.ContentTypes) == 0 {
+		c.ContentTypes = defaultCompressionTypes
+	}
+
+	pools := make(map[string]*sync.Pool, len(c.Encodings))
+	for _, name := range c.Encodings {
+		var newEncoder func() interface{}
+		switch name {
+		case EncodingGzip:
+			newEncoder = func() interface{} { return gzip.NewWriter(nil) }
+		case EncodingBrotli:
+			newEncoder = func() interface{} { return brotli.NewWriter(nil) }
+		case EncodingZstd:
+			newEncoder = func() interface{} {
+				w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+				return w
+			}
+		default:
+			continue
+		}
+
+		pools[name] = &sync.Pool{New: newEncoder}
+	}
+
+	return &compression{Compression: c, pools: pools}
+}
+
+// negotiate returns the supported encoding with the highest quality in the Accept-Encoding header,
+// the preferred order breaks the tie.
+func (c *compression) negotiate(acceptEncoding string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = part[:i]
+			params := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(params, "q=") {
+				if f, err := strconv.ParseFloat(params[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		qualities[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range c.Encodings {
+		if _, ok := c.pools[name]; !ok {
+			continue
+		}
+
+		q, ok := qualities[name]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+
+		if ok && q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+
+	return best
+}
+
+func (c *compression) compressible(contentType string) bool {
+	mediaType := contentType
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, t := range c.ContentTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handler compresses the responses of next, the WebSocket, SSE and gRPC-Web requests
+// are passed through since they are streamed or encoded by their own protocols.
+func (c *compression) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		name := c.negotiate(r.Header.Get("Accept-Encoding"))
+		if name == "" || r.Method == http.MethodHead || websocket.IsWebSocketUpgrade(r) || isGRPCWebRequest(r) ||
+			strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, c: c, encoding: name}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// encoder is implemented by the gzip, brotli and zstd writers.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressResponseWriter buffers the response until MinSize bytes are written,
+// and then decides whether to compress it by its content type.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	c        *compression
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	enc      encoder
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.c.MinSize {
+			return len(b), nil
+		}
+
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+
+		return len(b), nil
+	}
+
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the header and the buffered response, the encoder is used if
+// the response is large enough and its content type is compressible.
+// The partial content is not compressed, and the strong ETag is weakened
+// since the compressed representation is not byte-for-byte identical.
+func (w *compressResponseWriter) decide() error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if len(w.buf) >= w.c.MinSize && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		w.status != http.StatusNoContent && w.status != http.StatusNotModified &&
+		w.status != http.StatusPartialContent && w.c.compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		w.enc = w.c.pools[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+
+	w.buf = nil
+	return err
+}
+
+// Flush implements http.Flusher, the buffered response is written even if it is smaller than MinSize.
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+
+		_ = w.decide()
+	}
+
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped http.ResponseWriter.
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressResponseWriter) close() {
+	if !w.decided && w.status != 0 {
+		_ = w.decide()
+	}
+
+	if w.enc != nil {
+		_ = w.enc.Close()
+		w.enc.Reset(nil)
+		w.c.pools[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
+
+// zstdCompressor is the zstd gRPC compressor, the encoders and decoders are reused.
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func newZstdCompressor() *zstdCompressor {
+	c := &zstdCompressor{}
+	c.encoders.New = func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}
+	c.decoders.New = func() interface{} {
+		r, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return r
+	}
+
+	return c
+}
+
+// Compress implements encoding.Compressor interface.
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	enc := c.encoders.Get().(*zstd.Encoder)
+	enc.Reset(w)
+	return &zstdWriter{Encoder: enc, pool: &c.encoders}, nil
+}
+
+// Decompress implements encoding.Compressor interface.
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec := c.decoders.Get().(*zstd.Decoder)
+	if err := dec.Reset(r); err != nil {
+		c.decoders.Put(dec)
+		return nil, err
+	}
+
+	return &zstdReader{Decoder: dec, pool: &c.decoders}, nil
+}
+
+// Name implements encoding.Compressor interface.
+func (c *zstdCompressor) Name() string {
+	return EncodingZstd
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	w.pool.Put(w.Encoder)
+	return err
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+// Read returns the decoder to the pool when the message is fully read.
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.Decoder == nil {
+		return 0, io.EOF
+	}
+
+	n, err := r.Decoder.Read(p)
+	if err == io.EOF {
+		_ = r.Decoder.Reset(nil)
+		r.pool.Put(r.Decoder)
+		r.Decoder = nil
+	}
+
+	return n, err
+}
+
+// compressorUnaryInterceptor compresses the unary responses by the default compressor
+// if the client supports it, otherwise the compressor of the request is used.
+func (s *Service) compressorUnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	s.setSendCompressor(ctx)
+	return handler(ctx, req)
+}
+
+// compressorStreamInterceptor compresses the stream messages just like compressorUnaryInterceptor.
+func (s *Service) compressorStreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	s.setSendCompressor(ss.Context())
+	return handler(srv, ss)
+}
+
+func (s *Service) setSendCompressor(ctx context.Context) {
+	supported, err := grpc.ClientSupportedCompressors(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, name := range supported {
+		if name == s.grpcCompressor {
+			_ = grpc.SetSendCompressor(ctx, name)
+			return
+		}
+	}
+}
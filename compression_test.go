@@ -0,0 +1,170 @@
+package gmicro
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestCompressionNegotiate(t *testing.T) {
+	c := newCompression(Compression{})
+	assert.Equal(t, EncodingBrotli, c.negotiate("gzip, deflate, br, zstd"))
+	assert.Equal(t, EncodingGzip, c.negotiate("gzip;q=1.0, br;q=0.5"))
+	assert.Equal(t, EncodingZstd, c.negotiate("br;q=0, zstd"))
+	assert.Equal(t, EncodingBrotli, c.negotiate("*"))
+	assert.Equal(t, "", c.negotiate("identity"))
+	assert.Equal(t, "", c.negotiate(""))
+
+	c = newCompression(Compression{Encodings: []string{EncodingGzip}})
+	assert.Equal(t, EncodingGzip, c.negotiate("br, gzip"))
+	assert.Equal(t, "", c.negotiate("br"))
+}
+
+func TestCompressionHandler(t *testing.T) {
+	var should = require.New(t)
+	large := `{"message":"` + strings.Repeat("hello ", 500) + `"}`
+	c := newCompression(Compression{ContentTypes: []string{"application/json", "text/"}})
+	h := c.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = io.WriteString(w, large[:100])
+			_, _ = io.WriteString(w, large[100:])
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"message":"hello"}`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, large)
+		case "/text":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, strings.Repeat("hello ", 500))
+		case "/range":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", "bytes 0-2999/6000")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = io.WriteString(w, strings.Repeat("hello ", 500))
+		}
+	}))
+
+	decoders := map[string]func(r io.Reader) io.Reader{
+		EncodingGzip: func(r io.Reader) io.Reader {
+			zr, err := gzip.NewReader(r)
+			should.NoError(err)
+			return zr
+		},
+		EncodingBrotli: func(r io.Reader) io.Reader {
+			return brotli.NewReader(r)
+		},
+		EncodingZstd: func(r io.Reader) io.Reader {
+			zr, err := zstd.NewReader(r)
+			should.NoError(err)
+			return zr
+		},
+	}
+
+	for name, decode := range decoders {
+		req := httptest.NewRequest(http.MethodGet, "/large", nil)
+		req.Header.Set("Accept-Encoding", name)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		should.Equal(name, w.Header().Get("Content-Encoding"))
+		should.Equal("Accept-Encoding", w.Header().Get("Vary"))
+		should.Equal(`W/"v1"`, w.Header().Get("ETag"))
+		should.Less(w.Body.Len(), len(large))
+
+		b, err := io.ReadAll(decode(w.Body))
+		should.NoError(err, name)
+		should.Equal(large, string(b), name)
+	}
+
+	for path, encoding := range map[string]string{"/small": "", "/image": "", "/text": EncodingGzip, "/range": ""} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		should.Equal(encoding, w.Header().Get("Content-Encoding"), path)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/text", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	should.Equal(http.StatusCreated, w.Code)
+	should.Contains(w.Header().Get("Content-Type"), "text/plain")
+}
+
+func TestZstdCompressor(t *testing.T) {
+	var should = require.New(t)
+	registerZstdCompressor()
+	c := encoding.GetCompressor(EncodingZstd)
+	should.NotNil(c)
+
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		w, err := c.Compress(&buf)
+		should.NoError(err)
+		_, err = io.WriteString(w, strings.Repeat("gmicro", 100))
+		should.NoError(err)
+		should.NoError(w.Close())
+
+		r, err := c.Decompress(&buf)
+		should.NoError(err)
+		b, err := io.ReadAll(r)
+		should.NoError(err)
+		should.Equal(strings.Repeat("gmicro", 100), string(b))
+	}
+}
+
+func TestGRPCCompressor(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithGRPCCompressor(EncodingZstd),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithCompression(Compression{MinSize: 10}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &errorGreeterService{})
+
+	addr := startTestServer(t, s)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	client := pb.NewGreeterServiceClient(conn)
+	for _, name := range []string{EncodingGzip, EncodingZstd} {
+		reply, err := client.SayHello(context.Background(), &pb.HelloReq{Name: "daheige"}, grpc.UseCompressor(name))
+		should.NoError(err, name)
+		should.Equal("hello,daheige", reply.Name)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/v1/say/daheige", nil)
+	should.NoError(err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	should.NoError(err)
+	defer res.Body.Close()
+	should.Equal(EncodingGzip, res.Header.Get("Content-Encoding"))
+
+	zr, err := gzip.NewReader(res.Body)
+	should.NoError(err)
+	b, err := io.ReadAll(zr)
+	should.NoError(err)
+	should.JSONEq(`{"name":"hello,daheige","message":"call ok"}`, string(b))
+}
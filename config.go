@@ -0,0 +1,599 @@
+package gmicro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultEnvPrefix is the default prefix of the environment variables used by LoadConfig.
+// Every config field can be overridden by the environment variable named with the prefix
+// and the upper case of its env tag, the nested struct fields are joined with "_", eg:
+//
+//	GMICRO_HTTP_PORT=8080
+//	GMICRO_SHUTDOWN_TIMEOUT=10s
+//	GMICRO_HTTP_SERVER_READ_TIMEOUT=5s
+//	GMICRO_TLS_CERT_FILE=/etc/gmicro/server.crt
+//	GMICRO_RATE_LIMIT_RATE=100
+var DefaultEnvPrefix = "GMICRO_"
+
+var (
+	// ErrUnsupportedConfigFormat the config file extension is not supported.
+	ErrUnsupportedConfigFormat = errors.New("unsupported config format")
+
+	// ErrInvalidConfig the config does not pass the validation.
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Duration is a time.Duration which can be decoded from string such as "5s" in the config.
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler interface.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler interface.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Duration returns the time.Duration value.
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// Config is the service config which can be loaded from file and environment.
+// Only the fields with the env tag can be set by environment variable, the others
+// such as MethodTimeouts, Tenancy and ResponseCache are loaded from file only.
+type Config struct {
+	HTTPPort            int            `json:"http_port" yaml:"http_port" toml:"http_port" env:"HTTP_PORT"`
+	GRPCPort            int            `json:"grpc_port" yaml:"grpc_port" toml:"grpc_port" env:"GRPC_PORT"`
+	SharePort           int            `json:"share_port" yaml:"share_port" toml:"share_port" env:"SHARE_PORT"`
+	GRPCNetwork         string         `json:"grpc_network" yaml:"grpc_network" toml:"grpc_network" env:"GRPC_NETWORK"`
+	ShutdownTimeout     Duration       `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	PreShutdownDelay    Duration       `json:"pre_shutdown_delay" yaml:"pre_shutdown_delay" toml:"pre_shutdown_delay" env:"PRE_SHUTDOWN_DELAY"`
+	StartTimeout        Duration       `json:"start_timeout" yaml:"start_timeout" toml:"start_timeout" env:"START_TIMEOUT"`
+	HTTPServer          HTTPServerConf `json:"http_server" yaml:"http_server" toml:"http_server" env:"HTTP_SERVER"`
+	StaticDir           string         `json:"static_dir" yaml:"static_dir" toml:"static_dir" env:"STATIC_DIR"`
+	EnableStaticAccess  bool           `json:"enable_static_access" yaml:"enable_static_access" toml:"enable_static_access" env:"ENABLE_STATIC_ACCESS"`
+	EnablePrometheus    bool           `json:"enable_prometheus" yaml:"enable_prometheus" toml:"enable_prometheus" env:"ENABLE_PROMETHEUS"`
+	EnableRequestAccess bool           `json:"enable_request_access" yaml:"enable_request_access" toml:"enable_request_access" env:"ENABLE_REQUEST_ACCESS"`
+	TLS                 TLSConf        `json:"tls" yaml:"tls" toml:"tls" env:"TLS"`
+	RateLimit           RateLimitConf  `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit" env:"RATE_LIMIT"`
+	Limits              LimitsConf     `json:"limits" yaml:"limits" toml:"limits" env:"LIMITS"`
+
+	// LogLevel info or debug, default: info, the debug level logs the access of every unary RPC
+	// like EnableRequestAccess, it can be changed by reloading config.
+	LogLevel string `json:"log_level" yaml:"log_level" toml:"log_level" env:"LOG_LEVEL"`
+
+	// CORS the cross-origin resource sharing of the http gateway, it is enabled when
+	// AllowedOrigins is not empty, the origins can be changed by reloading config.
+	CORS CORSConf `json:"cors" yaml:"cors" toml:"cors"`
+
+	// MethodTimeouts the handling timeout of gRPC unary method, the key is the full method name
+	// such as "/App.Grpc.Hello.GreeterService/SayHello".
+	MethodTimeouts map[string]Duration `json:"method_timeouts" yaml:"method_timeouts" toml:"method_timeouts"`
+
+	// PropagationKeys the metadata keys propagated from the incoming requests to the outgoing calls,
+	// the key ending with "*" matches the keys with its prefix, eg: "baggage-*".
+	// The propagation is disabled if it is empty and PropagateDefaultKeys is false.
+	PropagationKeys []string `json:"propagation_keys" yaml:"propagation_keys" toml:"propagation_keys"`
+
+	// PropagateDefaultKeys propagates DefaultPropagationKeys in addition to PropagationKeys.
+	PropagateDefaultKeys bool `json:"propagate_default_keys" yaml:"propagate_default_keys" toml:"propagate_default_keys"`
+
+	// Tenancy the tenant resolving and the policies of the tenants.
+	Tenancy TenancyConf `json:"tenancy" yaml:"tenancy" toml:"tenancy"`
+
+	// Idempotency the idempotent unary methods, the replies are stored in memory.
+	Idempotency IdempotencyConf `json:"idempotency" yaml:"idempotency" toml:"idempotency"`
+
+	// ResponseCache the cached read-only unary methods.
+	ResponseCache ResponseCacheConf `json:"response_cache" yaml:"response_cache" toml:"response_cache"`
+}
+
+// HTTPServerConf http server timeouts config.
+type HTTPServerConf struct {
+	ReadHeaderTimeout Duration `json:"read_header_timeout" yaml:"read_header_timeout" toml:"read_header_timeout" env:"READ_HEADER_TIMEOUT"`
+	ReadTimeout       Duration `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout" env:"READ_TIMEOUT"`
+	WriteTimeout      Duration `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout" env:"WRITE_TIMEOUT"`
+	IdleTimeout       Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout" env:"IDLE_TIMEOUT"`
+}
+
+// TLSConf gRPC server TLS config, the gateway dials the gRPC server with
+// the cert file and ServerName when TLS is enabled. It can not be used with
+// the share_port which serves the gRPC and http requests in plaintext h2c.
+type TLSConf struct {
+	CertFile   string `json:"cert_file" yaml:"cert_file" toml:"cert_file" env:"CERT_FILE"`
+	KeyFile    string `json:"key_file" yaml:"key_file" toml:"key_file" env:"KEY_FILE"`
+	ServerName string `json:"server_name" yaml:"server_name" toml:"server_name" env:"SERVER_NAME"`
+}
+
+// RateLimitConf gRPC unary request rate limit config, it is disabled when Rate is 0.
+type RateLimitConf struct {
+	Rate  float64 `json:"rate" yaml:"rate" toml:"rate" env:"RATE"`
+	Burst int     `json:"burst" yaml:"burst" toml:"burst" env:"BURST"`
+}
+
+// TenancyConf multi-tenancy config, it is disabled when no resolver is set. The resolvers are
+// tried in the order of header, subdomain and path prefix, the JWT claim resolver needs
+// the JWTParser, so it can only be set by WithTenancy.
+type TenancyConf struct {
+	Header     string                `json:"header" yaml:"header" toml:"header"`
+	Subdomain  string                `json:"subdomain" yaml:"subdomain" toml:"subdomain"`
+	PathPrefix string                `json:"path_prefix" yaml:"path_prefix" toml:"path_prefix"`
+	Required   bool                  `json:"required" yaml:"required" toml:"required"`
+	Tenants    map[string]TenantConf `json:"tenants" yaml:"tenants" toml:"tenants"`
+	Default    TenantConf            `json:"default" yaml:"default" toml:"default"`
+}
+
+// TenantConf the rate limit and allowed methods of the tenant.
+type TenantConf struct {
+	RateLimit    RateLimitConf `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+	AllowMethods []string      `json:"allow_methods" yaml:"allow_methods" toml:"allow_methods"`
+}
+
+// Enabled returns true when a tenant resolver is configured.
+func (c TenancyConf) Enabled() bool {
+	return c.Header != "" || c.Subdomain != "" || c.PathPrefix != ""
+}
+
+// tenancy returns the Tenancy of the config.
+func (c TenancyConf) tenancy() Tenancy {
+	t := Tenancy{
+		Required: c.Required,
+		Default:  TenantPolicy{RateLimit: c.Default.RateLimit, AllowMethods: c.Default.AllowMethods},
+	}
+
+	if c.Header != "" {
+		t.Resolvers = append(t.Resolvers, TenantFromHeader(c.Header))
+	}
+
+	if c.Subdomain != "" {
+		t.Resolvers = append(t.Resolvers, TenantFromSubdomain(c.Subdomain))
+	}
+
+	if c.PathPrefix != "" {
+		t.Resolvers = append(t.Resolvers, TenantFromPathPrefix(c.PathPrefix))
+	}
+
+	if len(c.Tenants) > 0 {
+		t.Tenants = make(map[string]TenantPolicy, len(c.Tenants))
+		for name, tc := range c.Tenants {
+			t.Tenants[name] = TenantPolicy{RateLimit: tc.RateLimit, AllowMethods: tc.AllowMethods}
+		}
+	}
+
+	return t
+}
+
+// IdempotencyConf idempotent unary methods config, it is disabled when Methods is empty,
+// 0 capacity or ttl means the default value.
+type IdempotencyConf struct {
+	Methods  []string `json:"methods" yaml:"methods" toml:"methods"`
+	Required bool     `json:"required" yaml:"required" toml:"required"`
+	Capacity int      `json:"capacity" yaml:"capacity" toml:"capacity"`
+	TTL      Duration `json:"ttl" yaml:"ttl" toml:"ttl"`
+}
+
+// ResponseCacheConf response cache config, it is disabled when Methods is empty,
+// the key of Methods is the gRPC full method and the value is its TTL.
+// Shared shares the cached replies between the callers, see ResponseCache.Shared.
+type ResponseCacheConf struct {
+	Methods      map[string]Duration `json:"methods" yaml:"methods" toml:"methods"`
+	VaryMetadata []string            `json:"vary_metadata" yaml:"vary_metadata" toml:"vary_metadata"`
+	Shared       bool                `json:"shared" yaml:"shared" toml:"shared"`
+	MaxEntries   int                 `json:"max_entries" yaml:"max_entries" toml:"max_entries"`
+	MaxBytes     int                 `json:"max_bytes" yaml:"max_bytes" toml:"max_bytes"`
+	MaxEntrySize int                 `json:"max_entry_size" yaml:"max_entry_size" toml:"max_entry_size"`
+}
+
+// CORSConf the CORS config of the http gateway, see CORSConfig.
+type CORSConf struct {
+	AllowedOrigins   []string `json:"allowed_origins" yaml:"allowed_origins" toml:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods" yaml:"allowed_methods" toml:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers" yaml:"allowed_headers" toml:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers" yaml:"exposed_headers" toml:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials" yaml:"allow_credentials" toml:"allow_credentials"`
+	MaxAge           Duration `json:"max_age" yaml:"max_age" toml:"max_age"`
+}
+
+// LimitsConf request size limits config, 0 means the default value.
+type LimitsConf struct {
+	MaxBodySize        int `json:"max_body_size" yaml:"max_body_size" toml:"max_body_size" env:"MAX_BODY_SIZE"`
+	MaxHeaderBytes     int `json:"max_header_bytes" yaml:"max_header_bytes" toml:"max_header_bytes" env:"MAX_HEADER_BYTES"`
+	GRPCMaxRecvMsgSize int `json:"grpc_max_recv_msg_size" yaml:"grpc_max_recv_msg_size" toml:"grpc_max_recv_msg_size" env:"GRPC_MAX_RECV_MSG_SIZE"`
+	GRPCMaxSendMsgSize int `json:"grpc_max_send_msg_size" yaml:"grpc_max_send_msg_size" toml:"grpc_max_send_msg_size" env:"GRPC_MAX_SEND_MSG_SIZE"`
+	MinTransferRate    int `json:"min_transfer_rate" yaml:"min_transfer_rate" toml:"min_transfer_rate" env:"MIN_TRANSFER_RATE"`
+}
+
+// Enabled returns true when TLS is configured.
+func (c TLSConf) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// DefaultConfig returns the config with the same default values as NewService.
+func DefaultConfig() *Config {
+	return &Config{
+		GRPCNetwork:      "tcp",
+		ShutdownTimeout:  Duration(defaultShutdownTimeout),
+		PreShutdownDelay: Duration(defaultPreShutdownDelay),
+		StartTimeout:     Duration(defaultStartTimeout),
+		HTTPServer: HTTPServerConf{
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(5 * time.Second),
+			WriteTimeout:      Duration(10 * time.Second),
+			IdleTimeout:       Duration(20 * time.Second),
+		},
+	}
+}
+
+// LoadConfig loads the config from file and the environment variables prefixed with
+// DefaultEnvPrefix, the environment variables take precedence over the file.
+// The file format is detected by its extension: .yaml, .yml, .json or .toml,
+// if file is empty, only the environment variables are loaded.
+func LoadConfig(file string) (*Config, error) {
+	return LoadConfigWithEnvPrefix(file, DefaultEnvPrefix)
+}
+
+// LoadConfigWithEnvPrefix loads the config just like LoadConfig but with the given env prefix.
+func LoadConfigWithEnvPrefix(file string, prefix string) (*Config, error) {
+	c := DefaultConfig()
+	if file != "" {
+		if err := c.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadEnv(reflect.ValueOf(c).Elem(), prefix); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) loadFile(file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, c)
+	case ".json":
+		err = json.Unmarshal(b, c)
+	case ".toml":
+		err = toml.Unmarshal(b, c)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedConfigFormat, file)
+	}
+
+	if err != nil {
+		return fmt.Errorf("decode config file %s error: %w", file, err)
+	}
+
+	return nil
+}
+
+// loadEnv sets the struct fields from the environment variables named by the env tags.
+func loadEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+
+		name := prefix + tag
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := loadEnv(field, name+"_"); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if err := setField(field, val); err != nil {
+			return fmt.Errorf("parse env %s=%q error: %w", name, val, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, val string) error {
+	if field.Type() == reflect.TypeOf(Duration(0)) {
+		return field.Addr().Interface().(*Duration).UnmarshalText([]byte(val))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+// Validate checks whether the config values are valid.
+func (c *Config) Validate() error {
+	var errs []string
+	for name, port := range map[string]int{
+		"http_port": c.HTTPPort, "grpc_port": c.GRPCPort, "share_port": c.SharePort,
+	} {
+		if port < 0 || port > 65535 {
+			errs = append(errs, fmt.Sprintf("%s %d out of range", name, port))
+		}
+	}
+
+	if c.HTTPPort != 0 && c.HTTPPort == c.GRPCPort {
+		errs = append(errs, "http_port and grpc_port must be different, use share_port instead")
+	}
+
+	switch c.GRPCNetwork {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		errs = append(errs, fmt.Sprintf("grpc_network %q must be tcp, tcp4 or tcp6", c.GRPCNetwork))
+	}
+
+	for name, d := range map[string]Duration{
+		"shutdown_timeout":                c.ShutdownTimeout,
+		"pre_shutdown_delay":              c.PreShutdownDelay,
+		"start_timeout":                   c.StartTimeout,
+		"http_server.read_header_timeout": c.HTTPServer.ReadHeaderTimeout,
+		"http_server.read_timeout":        c.HTTPServer.ReadTimeout,
+		"http_server.write_timeout":       c.HTTPServer.WriteTimeout,
+		"http_server.idle_timeout":        c.HTTPServer.IdleTimeout,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Sprintf("%s %v must not be negative", name, d.Duration()))
+		}
+	}
+
+	if c.EnableStaticAccess && c.StaticDir == "" {
+		errs = append(errs, "static_dir must be set when enable_static_access is true")
+	} else if c.EnableStaticAccess {
+		if fi, err := os.Stat(c.StaticDir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Sprintf("static_dir %s is not a directory", c.StaticDir))
+		}
+	}
+
+	for method, d := range c.MethodTimeouts {
+		if d < 0 {
+			errs = append(errs, fmt.Sprintf("method_timeouts.%s %v must not be negative", method, d.Duration()))
+		}
+	}
+
+	if c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		errs = append(errs, "tls.cert_file and tls.key_file must be set together")
+	}
+
+	if c.TLS.Enabled() && c.SharePort != 0 {
+		errs = append(errs, "tls can not be used with share_port which serves plaintext h2c")
+	}
+
+	if c.RateLimit.Rate < 0 || (c.RateLimit.Rate > 0 && c.RateLimit.Burst < 1) {
+		errs = append(errs, "rate_limit.rate must not be negative and rate_limit.burst must be positive")
+	}
+
+	tenantRateLimits := map[string]RateLimitConf{"tenancy.default": c.Tenancy.Default.RateLimit}
+	for name, tc := range c.Tenancy.Tenants {
+		tenantRateLimits["tenancy.tenants."+name] = tc.RateLimit
+	}
+
+	for name, rl := range tenantRateLimits {
+		if rl.Rate < 0 || (rl.Rate > 0 && rl.Burst < 1) {
+			errs = append(errs, name+".rate_limit.rate must not be negative and "+name+".rate_limit.burst must be positive")
+		}
+	}
+
+	for method, ttl := range c.ResponseCache.Methods {
+		if ttl < 0 {
+			errs = append(errs, fmt.Sprintf("response_cache.methods.%s %v must not be negative", method, ttl.Duration()))
+		}
+	}
+
+	for name, n := range map[string]int{
+		"response_cache.max_entries":    c.ResponseCache.MaxEntries,
+		"response_cache.max_bytes":      c.ResponseCache.MaxBytes,
+		"response_cache.max_entry_size": c.ResponseCache.MaxEntrySize,
+	} {
+		if n < 0 {
+			errs = append(errs, fmt.Sprintf("%s %d must not be negative", name, n))
+		}
+	}
+
+	if c.LogLevel != "" && c.LogLevel != logLevelInfo && c.LogLevel != logLevelDebug {
+		errs = append(errs, fmt.Sprintf("log_level %q must be info or debug", c.LogLevel))
+	}
+
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, fmt.Sprintf("cors.max_age %v must not be negative", c.CORS.MaxAge.Duration()))
+	}
+
+	if c.Idempotency.Capacity < 0 || c.Idempotency.TTL < 0 {
+		errs = append(errs, "idempotency.capacity and idempotency.ttl must not be negative")
+	}
+
+	if (len(c.Tenancy.Tenants) > 0 || c.Tenancy.Required) && !c.Tenancy.Enabled() {
+		errs = append(errs, "tenancy.header, tenancy.subdomain or tenancy.path_prefix must be set when tenancy is configured")
+	}
+
+	for name, n := range map[string]int{
+		"limits.max_body_size":          c.Limits.MaxBodySize,
+		"limits.max_header_bytes":       c.Limits.MaxHeaderBytes,
+		"limits.grpc_max_recv_msg_size": c.Limits.GRPCMaxRecvMsgSize,
+		"limits.grpc_max_send_msg_size": c.Limits.GRPCMaxSendMsgSize,
+		"limits.min_transfer_rate":      c.Limits.MinTransferRate,
+	} {
+		if n < 0 {
+			errs = append(errs, fmt.Sprintf("%s %d must not be negative", name, n))
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Options converts the config into the service options.
+// The ports are not options, please pass them to the Start methods.
+func (c *Config) Options() ([]Option, error) {
+	opts := []Option{
+		WithShutdownTimeout(c.ShutdownTimeout.Duration()),
+		WithPreShutdownDelay(c.PreShutdownDelay.Duration()),
+		WithStartTimeout(c.StartTimeout.Duration()),
+		WithStaticDir(c.StaticDir),
+		WithStaticAccess(c.EnableStaticAccess),
+		WithPrometheus(c.EnablePrometheus),
+		WithRequestAccess(c.EnableRequestAccess),
+		WithMaxBodySize(int64(c.Limits.MaxBodySize)),
+		WithMaxHeaderBytes(c.Limits.MaxHeaderBytes),
+		WithMaxRecvMsgSize(c.Limits.GRPCMaxRecvMsgSize),
+		WithMaxSendMsgSize(c.Limits.GRPCMaxSendMsgSize),
+		WithMinTransferRate(int64(c.Limits.MinTransferRate)),
+		WithHTTPServer(&http.Server{
+			ReadHeaderTimeout: c.HTTPServer.ReadHeaderTimeout.Duration(),
+			ReadTimeout:       c.HTTPServer.ReadTimeout.Duration(),
+			WriteTimeout:      c.HTTPServer.WriteTimeout.Duration(),
+			IdleTimeout:       c.HTTPServer.IdleTimeout.Duration(),
+		}),
+	}
+
+	if c.GRPCNetwork != "" {
+		opts = append(opts, WithGRPCNetwork(c.GRPCNetwork))
+	}
+
+	if len(c.CORS.AllowedOrigins) > 0 {
+		opts = append(opts, WithCORS(CORSConfig{
+			AllowedOrigins:   c.CORS.AllowedOrigins,
+			AllowedMethods:   c.CORS.AllowedMethods,
+			AllowedHeaders:   c.CORS.AllowedHeaders,
+			ExposedHeaders:   c.CORS.ExposedHeaders,
+			AllowCredentials: c.CORS.AllowCredentials,
+			MaxAge:           c.CORS.MaxAge.Duration(),
+		}))
+	}
+
+	if c.Tenancy.Enabled() {
+		opts = append(opts, WithTenancy(c.Tenancy.tenancy()))
+	}
+
+	if len(c.Idempotency.Methods) > 0 {
+		capacity, ttl := c.Idempotency.Capacity, c.Idempotency.TTL.Duration()
+		if capacity == 0 {
+			capacity = defaultIdempotencyCapacity
+		}
+
+		if ttl == 0 {
+			ttl = defaultIdempotencyTTL
+		}
+
+		opts = append(opts, WithIdempotency(Idempotency{
+			Methods:  c.Idempotency.Methods,
+			Required: c.Idempotency.Required,
+			Store:    NewMemoryIdempotencyStore(capacity, ttl),
+		}))
+	}
+
+	if len(c.ResponseCache.Methods) > 0 {
+		methods := make(map[string]time.Duration, len(c.ResponseCache.Methods))
+		for method, ttl := range c.ResponseCache.Methods {
+			methods[method] = ttl.Duration()
+		}
+
+		opts = append(opts, WithResponseCache(ResponseCache{
+			Methods:      methods,
+			VaryMetadata: c.ResponseCache.VaryMetadata,
+			Shared:       c.ResponseCache.Shared,
+			MaxEntries:   c.ResponseCache.MaxEntries,
+			MaxBytes:     int64(c.ResponseCache.MaxBytes),
+			MaxEntrySize: int64(c.ResponseCache.MaxEntrySize),
+		}))
+	}
+
+	if c.PropagateDefaultKeys {
+		keys := append(append([]string(nil), DefaultPropagationKeys...), c.PropagationKeys...)
+		opts = append(opts, WithMetadataPropagation(keys...))
+	} else if len(c.PropagationKeys) > 0 {
+		opts = append(opts, WithMetadataPropagation(c.PropagationKeys...))
+	}
+
+	if c.TLS.Enabled() {
+		serverCreds, err := credentials.NewServerTLSFromFile(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		clientCreds, err := credentials.NewClientTLSFromFile(c.TLS.CertFile, c.TLS.ServerName)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts,
+			WithGRPCServerOption(grpc.Creds(serverCreds)),
+			WithGRPCDialOption(grpc.WithTransportCredentials(clientCreds)),
+		)
+	}
+
+	// the log level, rate limit and method timeouts can be changed by reloading config
+	opts = append(opts, withReloadableConfig(c))
+
+	return opts, nil
+}
@@ -0,0 +1,182 @@
+package gmicro
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"app.yaml": `
+http_port: 8080
+grpc_port: 9090
+shutdown_timeout: 10s
+http_server:
+  read_timeout: 3s
+enable_prometheus: true
+rate_limit:
+  rate: 100
+  burst: 200
+`,
+		"app.json": `{
+  "http_port": 8080,
+  "grpc_port": 9090,
+  "shutdown_timeout": "10s",
+  "http_server": {"read_timeout": "3s"},
+  "enable_prometheus": true,
+  "rate_limit": {"rate": 100, "burst": 200}
+}`,
+		"app.toml": `
+http_port = 8080
+grpc_port = 9090
+shutdown_timeout = "10s"
+enable_prometheus = true
+
+[http_server]
+read_timeout = "3s"
+
+[rate_limit]
+rate = 100
+burst = 200
+`,
+	}
+
+	for name, content := range files {
+		c, err := LoadConfigWithEnvPrefix(writeConfigFile(t, name, content), "GMICRO_TEST_")
+		require.NoError(t, err, name)
+		assert.Equal(t, 8080, c.HTTPPort, name)
+		assert.Equal(t, 9090, c.GRPCPort, name)
+		assert.Equal(t, 10*time.Second, c.ShutdownTimeout.Duration(), name)
+		assert.Equal(t, 3*time.Second, c.HTTPServer.ReadTimeout.Duration(), name)
+		assert.Equal(t, 10*time.Second, c.HTTPServer.WriteTimeout.Duration(), name)
+		assert.Equal(t, defaultPreShutdownDelay, c.PreShutdownDelay.Duration(), name)
+		assert.True(t, c.EnablePrometheus, name)
+		assert.Equal(t, 100.0, c.RateLimit.Rate, name)
+		assert.Equal(t, 200, c.RateLimit.Burst, name)
+	}
+}
+
+func setEnv(t *testing.T, key, value string) {
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		os.Unsetenv(key)
+	})
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	setEnv(t, "GMICRO_HTTP_PORT", "8088")
+	setEnv(t, "GMICRO_PRE_SHUTDOWN_DELAY", "1s")
+	setEnv(t, "GMICRO_HTTP_SERVER_IDLE_TIMEOUT", "1m")
+	setEnv(t, "GMICRO_ENABLE_REQUEST_ACCESS", "true")
+
+	file := writeConfigFile(t, "app.yml", "http_port: 8080\ngrpc_port: 9090\n")
+	c, err := LoadConfig(file)
+	require.NoError(t, err)
+	assert.Equal(t, 8088, c.HTTPPort)
+	assert.Equal(t, 9090, c.GRPCPort)
+	assert.Equal(t, time.Second, c.PreShutdownDelay.Duration())
+	assert.Equal(t, time.Minute, c.HTTPServer.IdleTimeout.Duration())
+	assert.True(t, c.EnableRequestAccess)
+
+	setEnv(t, "GMICRO_GRPC_PORT", "abc")
+	_, err = LoadConfig(file)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigError(t *testing.T) {
+	_, err := LoadConfig(writeConfigFile(t, "app.ini", "http_port=8080"))
+	assert.ErrorIs(t, err, ErrUnsupportedConfigFormat)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "http_port: 80800\nshutdown_timeout: -1s\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "tls:\n  cert_file: server.crt\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml",
+		"share_port: 8081\ntls:\n  cert_file: server.crt\n  key_file: server.key\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "rate_limit:\n  rate: 10\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "limits:\n  max_body_size: -1\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "enable_static_access: true\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "response_cache:\n  max_bytes: -1\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "idempotency:\n  ttl: -1s\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "log_level: trace\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml", "tenancy:\n  required: true\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = LoadConfig(writeConfigFile(t, "app.yaml",
+		"tenancy:\n  header: X-Tenant-Id\n  tenants:\n    acme:\n      rate_limit:\n        rate: 10\n"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestConfigOptions(t *testing.T) {
+	c := DefaultConfig()
+	c.ShutdownTimeout = Duration(8 * time.Second)
+	c.HTTPServer.WriteTimeout = Duration(30 * time.Second)
+	c.EnableRequestAccess = true
+	c.RateLimit = RateLimitConf{Rate: 10, Burst: 10}
+	c.Limits = LimitsConf{MaxBodySize: 1 << 20, MaxHeaderBytes: 8 << 10}
+	c.PropagationKeys = []string{"X-Tenant-Id"}
+	c.CORS = CORSConf{AllowedOrigins: []string{"https://example.com"}, MaxAge: Duration(time.Minute)}
+	c.ResponseCache = ResponseCacheConf{Methods: map[string]Duration{"/App.Grpc.Hello.GreeterService/*": Duration(time.Second)}}
+	c.Idempotency = IdempotencyConf{Methods: []string{"/App.Grpc.Hello.GreeterService/SayHello"}}
+	c.Tenancy = TenancyConf{Header: "X-Tenant-Id", Tenants: map[string]TenantConf{
+		"acme": {RateLimit: RateLimitConf{Rate: 10, Burst: 10}, AllowMethods: []string{"/App.Grpc.Hello.GreeterService/*"}},
+	}}
+
+	opts, err := c.Options()
+	require.NoError(t, err)
+
+	s := NewService(opts...)
+	assert.Equal(t, 8*time.Second, s.shutdownTimeout)
+	assert.Equal(t, 30*time.Second, s.HTTPServer.WriteTimeout)
+	assert.Equal(t, "tcp", s.gRPCNetwork)
+	assert.Equal(t, int64(1<<20), s.maxBodySize)
+	assert.Equal(t, 8<<10, s.HTTPServer.MaxHeaderBytes)
+	assert.True(t, s.propagator.propagated("x-tenant-id"))
+	assert.False(t, s.propagator.propagated("x-request-id"))
+
+	c.PropagateDefaultKeys = true
+	opts, err = c.Options()
+	require.NoError(t, err)
+	s = NewService(opts...)
+	assert.True(t, s.propagator.propagated("x-tenant-id"))
+	assert.True(t, s.propagator.propagated("x-request-id"))
+	assert.False(t, s.propagator.propagated("baggage-user-id"))
+	assert.Len(t, s.tenancy.Resolvers, 1)
+	assert.True(t, s.cors.allowedOrigins().allowed("https://example.com"))
+	assert.Equal(t, "60", s.cors.maxAge)
+	assert.True(t, s.idempotency.match("/App.Grpc.Hello.GreeterService/SayHello"))
+	ttl, ok := s.responseCache.ttl("/App.Grpc.Hello.GreeterService/SayHello")
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, ttl)
+	assert.Equal(t, []string{"/App.Grpc.Hello.GreeterService/*"}, s.tenancy.Tenants["acme"].AllowMethods)
+
+	// recovery, validator, rate limit and request interceptor
+	assert.Len(t, s.unaryInterceptors, 4)
+}
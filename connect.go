@@ -0,0 +1,399 @@
+package gmicro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	connectStreamContentType = "application/connect+"
+
+	// the flag of the envelope which ends the Connect stream
+	connectEndStreamFlag byte = 0x02
+
+	// the max value of grpc-timeout is 8 digits
+	maxGRPCTimeoutValue = 99999999
+
+	// jsonCodecName the content subtype of the JSON messages sent to the gRPC server,
+	// it does not replace the "json" codec registered by the other packages.
+	jsonCodecName = "gmicro-json"
+)
+
+// registerJSONCodecOnce registers jsonCodec when Connect or the stream routes are enabled.
+var registerJSONCodecOnce sync.Once
+
+// connectRequestHeaders the request headers sent by the Connect clients,
+// they are allowed by CORS when Connect is enabled.
+var connectRequestHeaders = []string{"Connect-Protocol-Version", "Connect-Timeout-Ms"}
+
+// connectCodes the Connect error codes of the gRPC codes.
+var connectCodes = map[codes.Code]string{
+	codes.Canceled:           "canceled",
+	codes.Unknown:            "unknown",
+	codes.InvalidArgument:    "invalid_argument",
+	codes.DeadlineExceeded:   "deadline_exceeded",
+	codes.NotFound:           "not_found",
+	codes.AlreadyExists:      "already_exists",
+	codes.PermissionDenied:   "permission_denied",
+	codes.ResourceExhausted:  "resource_exhausted",
+	codes.FailedPrecondition: "failed_precondition",
+	codes.Aborted:            "aborted",
+	codes.OutOfRange:         "out_of_range",
+	codes.Unimplemented:      "unimplemented",
+	codes.Internal:           "internal",
+	codes.Unavailable:        "unavailable",
+	codes.DataLoss:           "data_loss",
+	codes.Unauthenticated:    "unauthenticated",
+}
+
+// connectHTTPStatus the http status of the Connect unary error response.
+var connectHTTPStatus = map[codes.Code]int{
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusPreconditionFailed,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// registerJSONCodec registers jsonCodec, so the gRPC server decodes the JSON requests
+// of Connect and the stream routes by the content subtype jsonCodecName.
+// It is called before the server starts since encoding.RegisterCodec is not thread safe.
+func registerJSONCodec() {
+	registerJSONCodecOnce.Do(func() {
+		encoding.RegisterCodec(jsonCodec{})
+	})
+}
+
+// jsonCodec is the gRPC codec which marshals the proto messages as JSON.
+type jsonCodec struct{}
+
+// Marshal implements encoding.Codec interface.
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+
+	return protojson.Marshal(msg)
+}
+
+// Unmarshal implements encoding.Codec interface.
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
+	}
+
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, msg)
+}
+
+// Name implements encoding.Codec interface.
+func (jsonCodec) Name() string {
+	return jsonCodecName
+}
+
+// connectError is the error of Connect protocol.
+type connectError struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// ConnectHandlerFunc returns a http.Handler which translates the Connect protocol requests
+// to the methods registered on grpcServer, and the other requests are handled by otherHandler.
+// The unary requests are POST with application/json or application/proto body,
+// and the streaming requests use application/connect+json or application/connect+proto envelopes.
+// The services must be registered on grpcServer before calling it.
+// refer: https://connectrpc.com/docs/protocol
+func ConnectHandlerFunc(grpcServer *grpc.Server, otherHandler http.Handler) http.Handler {
+	registerJSONCodec()
+	streams := make(map[string]bool) // full method name -> whether it is a streaming method
+	for name, info := range grpcServer.GetServiceInfo() {
+		for _, method := range info.Methods {
+			streams["/"+name+"/"+method.Name] = method.IsClientStream || method.IsServerStream
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, ok := streams[r.URL.Path]
+		if !ok || r.Method != http.MethodPost {
+			otherHandler.ServeHTTP(w, r)
+			return
+		}
+
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if strings.HasPrefix(contentType, connectStreamContentType) {
+			serveConnect(grpcServer, w, r, strings.TrimPrefix(contentType, connectStreamContentType), true)
+			return
+		}
+
+		if !stream && strings.HasPrefix(contentType, "application/") {
+			serveConnect(grpcServer, w, r, strings.TrimPrefix(contentType, "application/"), false)
+			return
+		}
+
+		otherHandler.ServeHTTP(w, r)
+	})
+}
+
+func serveConnect(grpcServer *grpc.Server, w http.ResponseWriter, r *http.Request, codec string, stream bool) {
+	if codec != "json" && codec != "proto" {
+		http.Error(w, "unsupported codec "+codec, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	cw := &connectResponseWriter{w: w, header: make(http.Header), codec: codec, stream: stream}
+	encodingHeader := "Content-Encoding"
+	if stream {
+		encodingHeader = "Connect-Content-Encoding"
+	}
+
+	if e := r.Header.Get(encodingHeader); e != "" && e != "identity" {
+		cw.writeError(codes.Unimplemented, "unsupported compression "+e, nil)
+		return
+	}
+
+	// the gRPC server only accepts the HTTP/2 requests with application/grpc content type
+	req := r.Clone(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	if codec == "json" {
+		req.Header.Set("Content-Type", "application/grpc+"+jsonCodecName)
+	} else {
+		req.Header.Set("Content-Type", "application/grpc+"+codec)
+	}
+	req.Header.Del("Content-Length")
+	req.Header.Del("Connect-Protocol-Version")
+	req.ContentLength = -1
+
+	if timeout := r.Header.Get("Connect-Timeout-Ms"); timeout != "" {
+		req.Header.Del("Connect-Timeout-Ms")
+		ms, err := strconv.ParseInt(timeout, 10, 64)
+		if err != nil || ms < 0 {
+			cw.writeError(codes.InvalidArgument, "invalid Connect-Timeout-Ms "+timeout, nil)
+			return
+		}
+
+		if ms > maxGRPCTimeoutValue {
+			req.Header.Set("Grpc-Timeout", strconv.FormatInt(ms/1000, 10)+"S")
+		} else {
+			req.Header.Set("Grpc-Timeout", strconv.FormatInt(ms, 10)+"m")
+		}
+	}
+
+	// the unary message is sent as the body, it is converted to the gRPC message frame
+	if !stream {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			cw.writeError(codes.InvalidArgument, err.Error(), nil)
+			return
+		}
+
+		frame := make([]byte, 5, 5+len(body))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+		req.Body = io.NopCloser(bytes.NewReader(append(frame, body...)))
+	}
+
+	grpcServer.ServeHTTP(cw, req)
+	cw.finish()
+}
+
+// connectResponseWriter converts the gRPC response to Connect response.
+type connectResponseWriter struct {
+	w             http.ResponseWriter
+	header        http.Header // the header written by the gRPC server
+	codec         string
+	stream        bool
+	wroteHeader   bool
+	headerWritten map[string]bool // the header keys which are sent
+	body          bytes.Buffer    // the unary response body
+}
+
+// Header implements http.ResponseWriter interface.
+func (cw *connectResponseWriter) Header() http.Header {
+	return cw.header
+}
+
+// WriteHeader implements http.ResponseWriter interface, the stream response header is sent
+// immediately, and the unary response header is sent by finish with the status.
+func (cw *connectResponseWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+
+	cw.wroteHeader = true
+	cw.headerWritten = make(map[string]bool, len(cw.header))
+	for k := range cw.header {
+		cw.headerWritten[k] = true
+	}
+
+	if cw.stream {
+		cw.copyHeader()
+		cw.w.Header().Set("Content-Type", connectStreamContentType+cw.codec)
+		cw.w.WriteHeader(code)
+	}
+}
+
+// Write implements http.ResponseWriter interface.
+func (cw *connectResponseWriter) Write(b []byte) (int, error) {
+	cw.WriteHeader(http.StatusOK)
+	if cw.stream {
+		return cw.w.Write(b)
+	}
+
+	return cw.body.Write(b)
+}
+
+// Flush implements http.Flusher interface.
+func (cw *connectResponseWriter) Flush() {
+	cw.WriteHeader(http.StatusOK)
+	if f, ok := cw.w.(http.Flusher); ok && cw.stream {
+		f.Flush()
+	}
+}
+
+// copyHeader copies the metadata header sent by the gRPC server.
+func (cw *connectResponseWriter) copyHeader() {
+	header := cw.w.Header()
+	for k := range cw.headerWritten {
+		if k != "Trailer" && k != "Content-Type" && k != "Grpc-Encoding" {
+			header[k] = cw.header[k]
+		}
+	}
+}
+
+// finish writes the status and trailers of the gRPC response.
+func (cw *connectResponseWriter) finish() {
+	cw.WriteHeader(http.StatusOK)
+	trailers := grpcTrailers(cw.header, cw.headerWritten)
+	code, message := grpcStatus(trailers)
+
+	if cw.stream {
+		cw.writeEndStream(code, message, trailers)
+		return
+	}
+
+	if code != codes.OK {
+		cw.writeError(code, message, trailers)
+		return
+	}
+
+	// the unary response body is the message of the gRPC frame
+	body := cw.body.Bytes()
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		cw.writeError(codes.Internal, "invalid gRPC response message", trailers)
+		return
+	}
+
+	cw.copyHeader()
+	header := cw.w.Header()
+	for k, vv := range trailers {
+		header["Trailer-"+k] = vv
+	}
+
+	header.Set("Content-Type", "application/"+cw.codec)
+	header.Set("Content-Length", strconv.Itoa(len(body)-5))
+	cw.w.WriteHeader(http.StatusOK)
+	_, _ = cw.w.Write(body[5:])
+}
+
+// writeError writes the Connect unary error response.
+func (cw *connectResponseWriter) writeError(code codes.Code, message string, trailers http.Header) {
+	if cw.stream {
+		cw.WriteHeader(http.StatusOK)
+		cw.writeEndStream(code, message, trailers)
+		return
+	}
+
+	cw.copyHeader()
+	header := cw.w.Header()
+	for k, vv := range trailers {
+		header["Trailer-"+k] = vv
+	}
+
+	status, ok := connectHTTPStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	header.Set("Content-Type", "application/json")
+	cw.w.WriteHeader(status)
+	_ = json.NewEncoder(cw.w).Encode(&connectError{Code: connectCode(code), Message: message})
+}
+
+// writeEndStream writes the end stream envelope of the Connect stream response.
+func (cw *connectResponseWriter) writeEndStream(code codes.Code, message string, trailers http.Header) {
+	end := struct {
+		Error    *connectError       `json:"error,omitempty"`
+		Metadata map[string][]string `json:"metadata,omitempty"`
+	}{}
+
+	if code != codes.OK {
+		end.Error = &connectError{Code: connectCode(code), Message: message}
+	}
+
+	if len(trailers) > 0 {
+		end.Metadata = trailers
+	}
+
+	b, _ := json.Marshal(&end)
+	frame := make([]byte, 5, 5+len(b))
+	frame[0] = connectEndStreamFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	_, _ = cw.w.Write(append(frame, b...))
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// grpcStatus returns the status code and message in the gRPC trailers,
+// and removes the status trailers.
+func grpcStatus(trailers http.Header) (codes.Code, string) {
+	code := codes.Unknown
+	if v, err := strconv.Atoi(trailers.Get("Grpc-Status")); err == nil {
+		code = codes.Code(v)
+	}
+
+	message, err := url.PathUnescape(trailers.Get("Grpc-Message"))
+	if err != nil {
+		message = trailers.Get("Grpc-Message")
+	}
+
+	for _, k := range []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"} {
+		trailers.Del(k)
+	}
+
+	return code, message
+}
+
+func connectCode(code codes.Code) string {
+	if c, ok := connectCodes[code]; ok {
+		return c
+	}
+
+	return connectCodes[codes.Unknown]
+}
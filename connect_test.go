@@ -0,0 +1,224 @@
+package gmicro
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// testStreamCanceled receives the error of the Wait stream when it is canceled.
+var testStreamCanceled = make(chan error, 1)
+
+// testServiceDesc is a service with the failed unary method and the streaming methods.
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gmicro.test.TestService",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Fail",
+			Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error,
+				_ grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &pb.HelloReq{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+
+				_ = grpc.SetTrailer(ctx, metadata.Pairs("x-trace", "abc"))
+				return nil, status.Error(codes.NotFound, "user "+in.Name+" not found")
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Repeat",
+			ServerStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				in := &pb.HelloReq{}
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+
+				for i := 0; i < 3; i++ {
+					if err := stream.SendMsg(&pb.HelloReply{Name: in.Name}); err != nil {
+						return err
+					}
+				}
+
+				return status.Error(codes.Aborted, "stream aborted")
+			},
+		},
+		{
+			StreamName:    "Wait",
+			ServerStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				in := &pb.HelloReq{}
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+
+				if err := stream.SendMsg(&pb.HelloReply{Name: in.Name}); err != nil {
+					return err
+				}
+
+				<-stream.Context().Done()
+				testStreamCanceled <- stream.Context().Err()
+				return stream.Context().Err()
+			},
+		},
+		{
+			StreamName:    "Echo",
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				for {
+					in := &pb.HelloReq{}
+					err := stream.RecvMsg(in)
+					if err == io.EOF {
+						return nil
+					}
+
+					if err != nil {
+						return err
+					}
+
+					if err = stream.SendMsg(&pb.HelloReply{Name: in.Name}); err != nil {
+						return err
+					}
+				}
+			},
+		},
+	},
+}
+
+func connectPost(t *testing.T, url string, contentType string, body []byte, header map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, b
+}
+
+func TestConnect(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithConnect(true),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &greeterService{})
+	s.GRPCServer.RegisterService(&testServiceDesc, struct{}{})
+
+	addr := startTestServer(t, s)
+
+	baseURL := "http://" + addr
+
+	// unary json
+	res, b := connectPost(t, baseURL+pb.GreeterService_SayHello_FullMethodName, "application/json",
+		[]byte(`{"name":"daheige"}`), map[string]string{"Connect-Protocol-Version": "1", "Connect-Timeout-Ms": "3000"})
+	should.Equal(http.StatusOK, res.StatusCode)
+	should.Equal("application/json", res.Header.Get("Content-Type"))
+	should.JSONEq(`{"name":"hello,daheige","message":"call ok"}`, string(b))
+
+	// unary proto
+	in, err := proto.Marshal(&pb.HelloReq{Name: "daheige"})
+	should.NoError(err)
+	res, b = connectPost(t, baseURL+pb.GreeterService_SayHello_FullMethodName, "application/proto", in, nil)
+	should.Equal(http.StatusOK, res.StatusCode)
+	reply := &pb.HelloReply{}
+	should.NoError(proto.Unmarshal(b, reply))
+	should.Equal("hello,daheige", reply.Name)
+
+	// unary error
+	res, b = connectPost(t, baseURL+"/gmicro.test.TestService/Fail", "application/json",
+		[]byte(`{"name":"daheige"}`), nil)
+	should.Equal(http.StatusNotFound, res.StatusCode)
+	should.Equal("abc", res.Header.Get("Trailer-X-Trace"))
+	should.JSONEq(`{"code":"not_found","message":"user daheige not found"}`, string(b))
+
+	res, b = connectPost(t, baseURL+pb.GreeterService_SayHello_FullMethodName, "application/json",
+		[]byte(`{}`), map[string]string{"Connect-Timeout-Ms": "abc"})
+	should.Equal(http.StatusBadRequest, res.StatusCode)
+	should.Contains(string(b), "invalid_argument")
+
+	res, _ = connectPost(t, baseURL+pb.GreeterService_SayHello_FullMethodName, "application/xml", []byte(`{}`), nil)
+	should.Equal(http.StatusUnsupportedMediaType, res.StatusCode)
+
+	// server streaming
+	msg := []byte(`{"name":"daheige"}`)
+	envelope := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(envelope[1:], uint32(len(msg)))
+	res, b = connectPost(t, baseURL+"/gmicro.test.TestService/Repeat", "application/connect+json",
+		append(envelope, msg...), nil)
+	should.Equal(http.StatusOK, res.StatusCode)
+	should.Equal("application/connect+json", res.Header.Get("Content-Type"))
+
+	var messages []string
+	var end map[string]interface{}
+	for len(b) >= 5 {
+		flag, size := b[0], binary.BigEndian.Uint32(b[1:5])
+		data := b[5 : 5+size]
+		b = b[5+size:]
+		if flag&connectEndStreamFlag != 0 {
+			should.NoError(json.Unmarshal(data, &end))
+			continue
+		}
+
+		messages = append(messages, string(data))
+	}
+
+	should.Equal([]string{`{"name":"daheige"}`, `{"name":"daheige"}`, `{"name":"daheige"}`}, messages)
+	should.Equal(map[string]interface{}{"code": "aborted", "message": "stream aborted"}, end["error"])
+
+	// the gateway routes are not affected
+	res, err = http.Get(baseURL + "/v1/say/daheige")
+	should.NoError(err)
+	res.Body.Close()
+	should.Equal(http.StatusOK, res.StatusCode)
+}
+
+func TestConnectHTTPStatus(t *testing.T) {
+	assert.Equal(t, http.StatusPreconditionFailed, connectHTTPStatus[codes.FailedPrecondition])
+	assert.Equal(t, http.StatusBadRequest, connectHTTPStatus[codes.InvalidArgument])
+	assert.Equal(t, http.StatusBadRequest, connectHTTPStatus[codes.OutOfRange])
+}
+
+func TestJSONCodec(t *testing.T) {
+	b, err := jsonCodec{}.Marshal(&pb.HelloReq{Name: "daheige"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"daheige"}`, string(b))
+
+	in := &pb.HelloReq{}
+	require.NoError(t, jsonCodec{}.Unmarshal([]byte(`{"name":"daheige","unknown":1}`), in))
+	assert.Equal(t, "daheige", in.Name)
+
+	_, err = jsonCodec{}.Marshal("daheige")
+	assert.Error(t, err)
+
+	// the json codec of the process is not replaced
+	ConnectHandlerFunc(grpc.NewServer(), http.NotFoundHandler())
+	assert.NotNil(t, encoding.GetCodec(jsonCodecName))
+	assert.Nil(t, encoding.GetCodec("json"))
+}
@@ -0,0 +1,257 @@
+package gmicro
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// defaultCORSMethods the methods allowed when CORSConfig.AllowedMethods is empty.
+	defaultCORSMethods = []string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead,
+	}
+
+	// defaultCORSHeaders the request headers allowed when CORSConfig.AllowedHeaders is empty.
+	defaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", "X-Request-Id", "X-Requested-With"}
+)
+
+// CORSConfig is the cross-origin resource sharing config of the http gateway.
+type CORSConfig struct {
+	// AllowedOrigins the origins allowed to access the gateway, eg: https://example.com,
+	// "*" allows all origins and one wildcard can be used in the origin, eg: https://*.example.com
+	AllowedOrigins []string
+
+	// AllowedMethods the methods allowed in the preflight request,
+	// default: GET, POST, PUT, PATCH, DELETE, HEAD
+	AllowedMethods []string
+
+	// AllowedHeaders the request headers allowed in the preflight request, "*" allows all headers,
+	// default: Accept, Authorization, Content-Type, X-Request-Id, X-Requested-With
+	AllowedHeaders []string
+
+	// ExposedHeaders the response headers which can be read by the browser.
+	ExposedHeaders []string
+
+	// AllowCredentials whether the request can include the user credentials such as cookies,
+	// the request origin is returned instead of "*" when it is true.
+	AllowCredentials bool
+
+	// MaxAge how long the result of the preflight request can be cached,
+	// the header is not sent when it is 0.
+	MaxAge time.Duration
+}
+
+// cors handles the CORS requests by CORSConfig.
+type cors struct {
+	mu               sync.RWMutex
+	origins          corsOrigins // the allowed origins can be changed by reloading config
+	methods          map[string]bool
+	allowedMethods   string
+	allowAllHeaders  bool
+	headers          map[string]bool
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newCORS(c CORSConfig) *cors {
+	h := &cors{
+		origins:          newCORSOrigins(c.AllowedOrigins),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		exposedHeaders:   strings.Join(c.ExposedHeaders, ", "),
+		allowCredentials: c.AllowCredentials,
+	}
+
+	methods := c.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+
+	names := make([]string, 0, len(methods))
+	for _, method := range methods {
+		method = strings.ToUpper(method)
+		h.methods[method] = true
+		names = append(names, method)
+	}
+	h.allowedMethods = strings.Join(names, ", ")
+
+	headers := c.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	for _, header := range headers {
+		if header == "*" {
+			h.allowAllHeaders = true
+		}
+
+		h.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	if c.MaxAge > 0 {
+		h.maxAge = strconv.Itoa(int(c.MaxAge / time.Second))
+	}
+
+	return h
+}
+
+// corsOrigins the allowed origins of CORSConfig.
+type corsOrigins struct {
+	all      bool
+	exact    []string
+	wildcard [][2]string // prefix and suffix of the origins contain wildcard
+}
+
+func newCORSOrigins(origins []string) corsOrigins {
+	var o corsOrigins
+	for _, origin := range origins {
+		origin = strings.ToLower(origin)
+		if origin == "*" {
+			o.all = true
+		} else if i := strings.IndexByte(origin, '*'); i >= 0 {
+			o.wildcard = append(o.wildcard, [2]string{origin[:i], origin[i+1:]})
+		} else {
+			o.exact = append(o.exact, origin)
+		}
+	}
+
+	return o
+}
+
+// allowed reports whether the origin is allowed.
+func (o corsOrigins) allowed(origin string) bool {
+	if o.all {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	for _, e := range o.exact {
+		if e == origin {
+			return true
+		}
+	}
+
+	for _, w := range o.wildcard {
+		if len(origin) >= len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setOrigins replaces the allowed origins, it is called when the service reloads.
+func (h *cors) setOrigins(origins []string) {
+	o := newCORSOrigins(origins)
+	h.mu.Lock()
+	h.origins = o
+	h.mu.Unlock()
+}
+
+// allowedOrigins returns the current allowed origins.
+func (h *cors) allowedOrigins() corsOrigins {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.origins
+}
+
+// allowHeaders allows the request headers and exposes the response headers in addition to the config.
+func (h *cors) allowHeaders(requestHeaders []string, exposedHeaders []string) {
+	for _, header := range requestHeaders {
+		h.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	for _, header := range exposedHeaders {
+		if h.exposedHeaders != "" {
+			h.exposedHeaders += ", "
+		}
+
+		h.exposedHeaders += header
+	}
+}
+
+// handler returns the http.Handler which handles the preflight requests
+// and adds the CORS headers to the responses of next.
+func (h *cors) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.handlePreflight(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origins := h.allowedOrigins(); origin != "" && origins.allowed(origin) {
+			h.setAllowOrigin(header, origin, origins.all)
+			if h.exposedHeaders != "" {
+				header.Set("Access-Control-Expose-Headers", h.exposedHeaders)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePreflight responds the preflight request without calling the gateway handlers,
+// so it works for all the paths, the CORS headers are not sent if the request is not allowed.
+func (h *cors) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	origins := h.allowedOrigins()
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+	if origin == "" || !origins.allowed(origin) || !h.methods[method] || !h.areHeadersAllowed(requestHeaders) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.setAllowOrigin(header, origin, origins.all)
+	header.Set("Access-Control-Allow-Methods", h.allowedMethods)
+	if requestHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+
+	if h.maxAge != "" {
+		header.Set("Access-Control-Max-Age", h.maxAge)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *cors) setAllowOrigin(header http.Header, origin string, allowAll bool) {
+	if allowAll && !h.allowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if h.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// areHeadersAllowed reports whether all the headers in the comma separated list are allowed.
+func (h *cors) areHeadersAllowed(headers string) bool {
+	if h.allowAllHeaders || headers == "" {
+		return true
+	}
+
+	for _, header := range strings.Split(headers, ",") {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header != "" && !h.headers[header] {
+			return false
+		}
+	}
+
+	return true
+}
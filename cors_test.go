@@ -0,0 +1,134 @@
+package gmicro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func corsRequest(h http.Handler, method, origin string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/v1/say/daheige", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORS(t *testing.T) {
+	var should = assert.New(t)
+	called := 0
+	h := newCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://example.com", "https://*.example.org"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}).handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+
+	w := corsRequest(h, http.MethodGet, "https://example.com", nil)
+	should.Equal("https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	should.Equal("true", w.Header().Get("Access-Control-Allow-Credentials"))
+	should.Equal("X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+	should.Equal([]string{"Origin"}, w.Header().Values("Vary"))
+	should.Equal(1, called)
+
+	// wildcard origin
+	w = corsRequest(h, http.MethodGet, "https://api.example.org", nil)
+	should.Equal("https://api.example.org", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// the request is handled without CORS headers if the origin is not allowed
+	w = corsRequest(h, http.MethodGet, "https://example.net", nil)
+	should.Empty(w.Header().Get("Access-Control-Allow-Origin"))
+	should.Equal(3, called)
+
+	// preflight
+	w = corsRequest(h, http.MethodOptions, "https://example.com", map[string]string{
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type, authorization",
+	})
+	should.Equal(http.StatusNoContent, w.Code)
+	should.Equal("https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	should.Equal("GET, POST, PUT, PATCH, DELETE, HEAD", w.Header().Get("Access-Control-Allow-Methods"))
+	should.Equal("content-type, authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	should.Equal("600", w.Header().Get("Access-Control-Max-Age"))
+	should.Equal([]string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		w.Header().Values("Vary"))
+	should.Equal(3, called)
+
+	// preflight is rejected if the method or headers are not allowed
+	for _, header := range []map[string]string{
+		{"Access-Control-Request-Method": "TRACE"},
+		{"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Custom"},
+	} {
+		w = corsRequest(h, http.MethodOptions, "https://example.com", header)
+		should.Equal(http.StatusNoContent, w.Code)
+		should.Empty(w.Header().Get("Access-Control-Allow-Origin"))
+		should.Empty(w.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestCORSAllowAll(t *testing.T) {
+	var should = assert.New(t)
+	h := newCORS(CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"get"},
+		AllowedHeaders: []string{"*"},
+	}).handler(http.NotFoundHandler())
+
+	w := corsRequest(h, http.MethodGet, "https://example.com", nil)
+	should.Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
+	should.Empty(w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = corsRequest(h, http.MethodOptions, "https://example.com", map[string]string{
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "X-Custom",
+	})
+	should.Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
+	should.Equal("GET", w.Header().Get("Access-Control-Allow-Methods"))
+	should.Equal("X-Custom", w.Header().Get("Access-Control-Allow-Headers"))
+	should.Empty(w.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestServiceCORS(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithCORS(CORSConfig{AllowedOrigins: []string{"https://example.com"}}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &greeterService{})
+
+	addr := startTestServer(t, s)
+
+	req, err := http.NewRequest(http.MethodOptions, "http://"+addr+"/v1/say/daheige", nil)
+	should.NoError(err)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	res, err := http.DefaultClient.Do(req)
+	should.NoError(err)
+	res.Body.Close()
+	should.Equal(http.StatusNoContent, res.StatusCode)
+	should.Equal("https://example.com", res.Header.Get("Access-Control-Allow-Origin"))
+
+	req, err = http.NewRequest(http.MethodGet, "http://"+addr+"/v1/say/daheige", nil)
+	should.NoError(err)
+	req.Header.Set("Origin", "https://example.com")
+	res, err = http.DefaultClient.Do(req)
+	should.NoError(err)
+	res.Body.Close()
+	should.Equal(http.StatusOK, res.StatusCode)
+	should.Equal("https://example.com", res.Header.Get("Access-Control-Allow-Origin"))
+}
@@ -2,24 +2,42 @@ package gmicro
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc/metadata"
 )
 
+// ErrMetadataNotFound the key is not in the metadata.
+var ErrMetadataNotFound = errors.New("metadata not found")
+
 // CtxKey ctx key type.
+//
+// Deprecated: use Key which carries the type of the value.
 type CtxKey string
 
 const (
 	// XRequestID request_id
+	//
+	// Deprecated: use RequestIDKey.
 	XRequestID CtxKey = "x-request-id"
 
 	// GRPCClientIP grpc client_ip
+	//
+	// Deprecated: use ClientIPKey.
 	GRPCClientIP CtxKey = "client-ip"
 
 	// RequestMethod request method
+	//
+	// Deprecated: use RequestMethodKey.
 	RequestMethod CtxKey = "request_method"
 
 	// RequestURI request uri
+	//
+	// Deprecated: use RequestURIKey.
 	RequestURI CtxKey = "request_uri"
 )
 
@@ -28,6 +46,58 @@ func (c CtxKey) String() string {
 	return string(c)
 }
 
+// Key is the type-safe context key of the value T, the keys are compared by identity,
+// so two keys created by NewKey never collide even if they have the same name.
+// The name of the key is also used as the metadata key by the metadata helpers.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns a new Key with the name.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// String returns the name of the key.
+func (k *Key[T]) String() string {
+	return k.name
+}
+
+// With returns a copy of ctx with the value of the key.
+func (k *Key[T]) With(ctx context.Context, val T) context.Context {
+	return context.WithValue(ctx, k, val)
+}
+
+// From returns the value of the key in ctx.
+func (k *Key[T]) From(ctx context.Context) (T, bool) {
+	val, ok := ctx.Value(k).(T)
+	return val, ok
+}
+
+// MustFrom returns the value of the key in ctx, it panics if the value is not set.
+func (k *Key[T]) MustFrom(ctx context.Context) T {
+	val, ok := k.From(ctx)
+	if !ok {
+		panic("gmicro: context value of key " + k.name + " is not set")
+	}
+
+	return val
+}
+
+var (
+	// RequestIDKey request_id, its name is the same as XRequestID
+	RequestIDKey = NewKey[string](XRequestID.String())
+
+	// ClientIPKey client_ip, its name is the same as GRPCClientIP
+	ClientIPKey = NewKey[string](GRPCClientIP.String())
+
+	// RequestMethodKey request method, its name is the same as RequestMethod
+	RequestMethodKey = NewKey[string](RequestMethod.String())
+
+	// RequestURIKey request uri, its name is the same as RequestURI
+	RequestURIKey = NewKey[string](RequestURI.String())
+)
+
 // GetIncomingMD returns metadata.MD from incoming ctx
 // get request metadata
 // this method is mainly used at the server end to get the relevant metadata data
@@ -52,12 +122,12 @@ func GetOutgoingMD(ctx context.Context) metadata.MD {
 }
 
 // GetSliceFromMD returns []string from md
-func GetSliceFromMD(md metadata.MD, key CtxKey) []string {
+func GetSliceFromMD(md metadata.MD, key fmt.Stringer) []string {
 	return md.Get(key.String())
 }
 
-// GetSliceFromMD returns string from md
-func GetStringFromMD(md metadata.MD, key CtxKey) string {
+// GetStringFromMD returns string from md
+func GetStringFromMD(md metadata.MD, key fmt.Stringer) string {
 	values := md.Get(key.String())
 	if len(values) > 0 {
 		return values[0]
@@ -66,12 +136,58 @@ func GetStringFromMD(md metadata.MD, key CtxKey) string {
 	return ""
 }
 
+// GetInt64FromMD returns the int64 value of key from md,
+// ErrMetadataNotFound is returned if the key is not in md.
+func GetInt64FromMD(md metadata.MD, key fmt.Stringer) (int64, error) {
+	return parseMD(md, key, func(s string) (int64, error) {
+		return strconv.ParseInt(s, 10, 64)
+	})
+}
+
+// GetIntFromMD returns the int value of key from md,
+// ErrMetadataNotFound is returned if the key is not in md.
+func GetIntFromMD(md metadata.MD, key fmt.Stringer) (int, error) {
+	return parseMD(md, key, strconv.Atoi)
+}
+
+// GetDurationFromMD returns the time.Duration value of key from md such as 1.5s or 300ms,
+// ErrMetadataNotFound is returned if the key is not in md.
+func GetDurationFromMD(md metadata.MD, key fmt.Stringer) (time.Duration, error) {
+	return parseMD(md, key, time.ParseDuration)
+}
+
+// GetBoolFromMD returns the bool value of key from md, the value is parsed by strconv.ParseBool,
+// ErrMetadataNotFound is returned if the key is not in md.
+func GetBoolFromMD(md metadata.MD, key fmt.Stringer) (bool, error) {
+	return parseMD(md, key, strconv.ParseBool)
+}
+
+// parseMD parses the first value of key from md.
+func parseMD[T any](md metadata.MD, key fmt.Stringer, parse func(string) (T, error)) (T, error) {
+	values := md.Get(key.String())
+	if len(values) == 0 {
+		var zero T
+		return zero, fmt.Errorf("%w: %s", ErrMetadataNotFound, key.String())
+	}
+
+	val, err := parse(strings.TrimSpace(values[0]))
+	if err != nil {
+		return val, fmt.Errorf("parse metadata %s error: %w", key.String(), err)
+	}
+
+	return val, nil
+}
+
 // SetCtxValue returns ctx when you set key/value into ctx
+//
+// Deprecated: use Key.With.
 func SetCtxValue(ctx context.Context, key CtxKey, val interface{}) context.Context {
-	return context.WithValue(ctx, key.String(), val)
+	return context.WithValue(ctx, key, val)
 }
 
-// GetCtxValue returns ctx when you set key/value into ctx
+// GetCtxValue returns the value of key in ctx which is set by SetCtxValue
+//
+// Deprecated: use Key.From.
 func GetCtxValue(ctx context.Context, key CtxKey) interface{} {
 	return ctx.Value(key)
 }
@@ -0,0 +1,82 @@
+package gmicro
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestKey(t *testing.T) {
+	var should = require.New(t)
+	userID := NewKey[int64]("user-id")
+	other := NewKey[int64]("user-id")
+
+	ctx := userID.With(context.Background(), 123)
+	val, ok := userID.From(ctx)
+	should.True(ok)
+	should.Equal(int64(123), val)
+	should.Equal(int64(123), userID.MustFrom(ctx))
+
+	// the keys with the same name do not collide
+	_, ok = other.From(ctx)
+	should.False(ok)
+	should.Panics(func() { other.MustFrom(ctx) })
+	should.Equal("user-id", other.String())
+
+	ctx = ContextWithRequestInfo(context.Background(), &RequestInfo{RequestID: "abc", Method: "GET", URI: "/a"})
+	should.Equal("abc", RequestIDKey.MustFrom(ctx))
+	should.Equal("GET", RequestMethodKey.MustFrom(ctx))
+	should.Equal("/a", RequestURIKey.MustFrom(ctx))
+}
+
+func TestCtxValue(t *testing.T) {
+	ctx := SetCtxValue(context.Background(), CtxKey("name"), "daheige")
+	assert.Equal(t, "daheige", GetCtxValue(ctx, CtxKey("name")))
+	assert.Nil(t, GetCtxValue(ctx, CtxKey("other")))
+
+	// the deprecated keys still work with SetCtxValue and GetCtxValue
+	ctx = SetCtxValue(ctx, XRequestID, "abc")
+	assert.Equal(t, "abc", GetCtxValue(ctx, XRequestID))
+	assert.Equal(t, XRequestID.String(), RequestIDKey.String())
+}
+
+func TestTypedMD(t *testing.T) {
+	var should = require.New(t)
+	md := metadata.Pairs(
+		"page", "3",
+		"offset", "-9000000000",
+		"timeout", "1.5s",
+		"debug", "true",
+		"bad", "x",
+	)
+
+	page, err := GetIntFromMD(md, NewKey[int]("page"))
+	should.NoError(err)
+	should.Equal(3, page)
+
+	offset, err := GetInt64FromMD(md, CtxKey("offset"))
+	should.NoError(err)
+	should.Equal(int64(-9000000000), offset)
+
+	timeout, err := GetDurationFromMD(md, CtxKey("timeout"))
+	should.NoError(err)
+	should.Equal(1500*time.Millisecond, timeout)
+
+	debug, err := GetBoolFromMD(md, CtxKey("debug"))
+	should.NoError(err)
+	should.True(debug)
+
+	_, err = GetBoolFromMD(md, CtxKey("bad"))
+	should.Error(err)
+	should.NotErrorIs(err, ErrMetadataNotFound)
+
+	_, err = GetIntFromMD(md, CtxKey("missing"))
+	should.ErrorIs(err, ErrMetadataNotFound)
+
+	should.Equal("3", GetStringFromMD(md, CtxKey("page")))
+	should.Equal([]string{"true"}, GetSliceFromMD(md, CtxKey("debug")))
+}
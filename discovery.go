@@ -0,0 +1,75 @@
+package gmicro
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// the default interval of looking up the instances when watching a service
+const defaultWatchInterval = time.Second
+
+// ErrNoHealthyInstance there is no healthy instance of the service.
+var ErrNoHealthyInstance = errors.New("no healthy service instance")
+
+// Discovery looks up and watches the service instances, it is implemented
+// by the registries, so the clients can discover the services registered by Service.
+type Discovery interface {
+	// GetService returns the alive instances of the service.
+	GetService(ctx context.Context, name string) ([]*ServiceInstance, error)
+
+	// Watch sends the instances of the service to the returned channel when they change,
+	// the current instances are sent first, the channel is closed when ctx is done.
+	Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error)
+}
+
+// lookupFunc looks up the instances of the service.
+type lookupFunc func(ctx context.Context, name string) ([]*ServiceInstance, error)
+
+// watchService looks up the instances when notify fires or every interval,
+// and sends them sorted by id to the returned channel if they change.
+func watchService(ctx context.Context, name string, lookup lookupFunc,
+	notify <-chan struct{}, interval time.Duration) <-chan []*ServiceInstance {
+	ch := make(chan []*ServiceInstance, 1)
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last []*ServiceInstance
+		sent := false
+		for {
+			list, err := lookup(ctx, name)
+			if err == nil {
+				sortInstances(list)
+			}
+
+			if err == nil && (!sent || !reflect.DeepEqual(last, list)) {
+				select {
+				case ch <- list:
+					last, sent = list, true
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-notify:
+			}
+		}
+	}()
+
+	return ch
+}
+
+func sortInstances(list []*ServiceInstance) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+}
@@ -0,0 +1,136 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// Envelope is the shape of the gateway responses selected by WithEnvelope, eg:
+//
+//	{"code":0,"msg":"ok","data":{"name":"daheige"}}
+//	{"code":5,"msg":"user not found","data":{"code":5,"status":"NOT_FOUND","reason":"USER_NOT_FOUND"},"request_id":"..."}
+//
+// The data of the error is the ErrorDetail if the error has details, otherwise it is null.
+// The routing errors of the mux such as 404 and 405 are rendered in the same shape.
+type Envelope struct {
+	CodeField      string // default: code
+	MessageField   string // default: msg
+	DataField      string // default: data
+	RequestIDField string // the request id of the error, it is omitted if empty
+
+	SuccessCode    int    // the code of the successful reply, default: 0
+	SuccessMessage string // the message of the successful reply, default: ok
+
+	// ErrorCode maps the error to the code in the envelope, default: the gRPC code
+	ErrorCode func(e *Error) int
+
+	// HTTPStatus maps the gRPC code to the http status,
+	// the codes not in it are mapped by runtime.HTTPStatusFromCode.
+	HTTPStatus map[codes.Code]int
+
+	// ReplyMarshaler marshals the reply which is wrapped in the envelope,
+	// it must marshal to JSON, default: runtime.JSONPb
+	ReplyMarshaler gRuntime.Marshaler
+}
+
+func (e Envelope) withDefaults() Envelope {
+	if e.CodeField == "" {
+		e.CodeField = "code"
+	}
+
+	if e.MessageField == "" {
+		e.MessageField = "msg"
+	}
+
+	if e.DataField == "" {
+		e.DataField = "data"
+	}
+
+	if e.SuccessMessage == "" {
+		e.SuccessMessage = "ok"
+	}
+
+	if e.ErrorCode == nil {
+		e.ErrorCode = func(e *Error) int {
+			return int(e.Code)
+		}
+	}
+
+	if e.ReplyMarshaler == nil {
+		e.ReplyMarshaler = &gRuntime.JSONPb{}
+	}
+
+	return e
+}
+
+// ErrorHandler returns the gateway error handler which renders the errors in the envelope.
+func (e Envelope) ErrorHandler() gRuntime.ErrorHandlerFunc {
+	e = e.withDefaults()
+	return func(ctx context.Context, _ *gRuntime.ServeMux, _ gRuntime.Marshaler,
+		w http.ResponseWriter, r *http.Request, err error) {
+		ge, httpStatus, requestID := gatewayError(ctx, w, r, err)
+		if httpStatus == 0 {
+			var ok bool
+			if httpStatus, ok = e.HTTPStatus[ge.Code]; !ok {
+				httpStatus = gRuntime.HTTPStatusFromCode(ge.Code)
+			}
+		}
+
+		body := map[string]interface{}{
+			e.CodeField:    e.ErrorCode(ge),
+			e.MessageField: ge.Message,
+			e.DataField:    nil,
+		}
+
+		if ge.Reason != "" || len(ge.Metadata) > 0 || len(ge.FieldViolations) > 0 || ge.LocalizedMessage != "" {
+			body[e.DataField] = newErrorDetail(ge)
+		}
+
+		if e.RequestIDField != "" {
+			body[e.RequestIDField] = requestID
+		}
+
+		writeJSON(w, httpStatus, body)
+	}
+}
+
+// Marshaler returns the gateway marshaler which wraps the replies in the envelope,
+// the chunks of the streaming replies are not wrapped.
+func (e Envelope) Marshaler() gRuntime.Marshaler {
+	e = e.withDefaults()
+	return &envelopeMarshaler{Marshaler: e.ReplyMarshaler, envelope: e}
+}
+
+type envelopeMarshaler struct {
+	gRuntime.Marshaler
+	envelope Envelope
+}
+
+// Marshal implements runtime.Marshaler interface.
+func (m *envelopeMarshaler) Marshal(v interface{}) ([]byte, error) {
+	// the chunk of the streaming reply, eg: {"result": {...}}
+	if _, ok := v.(map[string]proto.Message); ok {
+		return m.Marshaler.Marshal(v)
+	}
+
+	data, err := m.Marshaler.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]interface{}{
+		m.envelope.CodeField:    m.envelope.SuccessCode,
+		m.envelope.MessageField: m.envelope.SuccessMessage,
+		m.envelope.DataField:    json.RawMessage(data),
+	})
+}
+
+// ContentType implements runtime.Marshaler interface.
+func (m *envelopeMarshaler) ContentType(_ interface{}) string {
+	return "application/json"
+}
@@ -0,0 +1,64 @@
+package gmicro
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestEnvelope(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithEnvelope(Envelope{
+			MessageField:   "message",
+			RequestIDField: "request_id",
+			ErrorCode: func(e *Error) int {
+				if e.Reason == "USER_NOT_FOUND" {
+					return 1001
+				}
+
+				return int(e.Code)
+			},
+			HTTPStatus: map[codes.Code]int{codes.NotFound: http.StatusOK},
+		}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &errorGreeterService{})
+
+	addr := startTestServer(t, s)
+
+	for _, c := range []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/v1/say/daheige", http.StatusOK,
+			`{"code":0,"message":"ok","data":{"name":"hello,daheige","message":"call ok"}}`},
+		{http.MethodGet, "/v1/say/missing", http.StatusOK,
+			`{"code":1001,"message":"user not found","request_id":"abc","data":{"code":5,"status":"NOT_FOUND",
+			"message":"user not found","reason":"USER_NOT_FOUND","metadata":{"name":"missing"}}}`},
+		{http.MethodGet, "/v1/unknown", http.StatusNotFound,
+			`{"code":5,"message":"Not Found","request_id":"abc","data":null}`},
+		{http.MethodPost, "/v1/say/daheige", http.StatusMethodNotAllowed,
+			`{"code":12,"message":"Method Not Allowed","request_id":"abc","data":null}`},
+	} {
+		req, err := http.NewRequest(c.method, "http://"+addr+c.path, nil)
+		should.NoError(err)
+		req.Header.Set("X-Request-Id", "abc")
+		res, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+
+		var body json.RawMessage
+		should.NoError(json.NewDecoder(res.Body).Decode(&body))
+		res.Body.Close()
+		should.Equal(c.status, res.StatusCode, c.path)
+		should.Equal("application/json", res.Header.Get("Content-Type"), c.path)
+		should.JSONEq(c.body, string(body), c.path)
+	}
+}
@@ -0,0 +1,406 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ReasonInternal the reason of the internal error which hides its cause.
+	ReasonInternal = "INTERNAL"
+
+	// the message of the internal error returned to the clients
+	internalErrorMessage = "internal server error"
+)
+
+// Error is the error with gRPC code and google.rpc error details, the details are sent to
+// the clients in the gRPC status and rendered in the gateway error response.
+// The With methods return a copy, so the errors can be declared as package variables, eg:
+//
+//	var ErrUserNotFound = gmicro.NotFound("USER_NOT_FOUND", "user not found")
+//
+//	return nil, ErrUserNotFound.WithMetadata(map[string]string{"user_id": id})
+type Error struct {
+	Code     codes.Code        // gRPC code
+	Reason   string            // ErrorInfo reason in UPPER_SNAKE_CASE, eg: USER_NOT_FOUND
+	Message  string            // the developer-facing message
+	Domain   string            // ErrorInfo domain, the service name is used if it is empty
+	Metadata map[string]string // ErrorInfo metadata
+
+	// FieldViolations BadRequest field violations of InvalidArgument error.
+	FieldViolations []FieldViolation
+
+	// Locale and LocalizedMessage the LocalizedMessage detail which is safe to show to the user.
+	Locale           string
+	LocalizedMessage string
+
+	cause error // the cause is logged but not sent to the clients
+}
+
+// FieldViolation describes a single bad request field.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// NewError returns the Error with code, reason and message.
+func NewError(c codes.Code, reason string, message string) *Error {
+	return &Error{Code: c, Reason: reason, Message: message}
+}
+
+// InvalidArgument returns the InvalidArgument Error with the field violations.
+func InvalidArgument(reason string, message string, violations ...FieldViolation) *Error {
+	e := NewError(codes.InvalidArgument, reason, message)
+	e.FieldViolations = violations
+	return e
+}
+
+// NotFound returns the NotFound Error.
+func NotFound(reason string, message string) *Error {
+	return NewError(codes.NotFound, reason, message)
+}
+
+// AlreadyExists returns the AlreadyExists Error.
+func AlreadyExists(reason string, message string) *Error {
+	return NewError(codes.AlreadyExists, reason, message)
+}
+
+// Unauthenticated returns the Unauthenticated Error.
+func Unauthenticated(reason string, message string) *Error {
+	return NewError(codes.Unauthenticated, reason, message)
+}
+
+// PermissionDenied returns the PermissionDenied Error.
+func PermissionDenied(reason string, message string) *Error {
+	return NewError(codes.PermissionDenied, reason, message)
+}
+
+// FailedPrecondition returns the FailedPrecondition Error.
+func FailedPrecondition(reason string, message string) *Error {
+	return NewError(codes.FailedPrecondition, reason, message)
+}
+
+// ResourceExhausted returns the ResourceExhausted Error.
+func ResourceExhausted(reason string, message string) *Error {
+	return NewError(codes.ResourceExhausted, reason, message)
+}
+
+// Aborted returns the Aborted Error.
+func Aborted(reason string, message string) *Error {
+	return NewError(codes.Aborted, reason, message)
+}
+
+// Unimplemented returns the Unimplemented Error.
+func Unimplemented(reason string, message string) *Error {
+	return NewError(codes.Unimplemented, reason, message)
+}
+
+// Unavailable returns the Unavailable Error.
+func Unavailable(reason string, message string) *Error {
+	return NewError(codes.Unavailable, reason, message)
+}
+
+// Internal returns the Internal Error.
+func Internal(reason string, message string) *Error {
+	return NewError(codes.Internal, reason, message)
+}
+
+// Error implements error interface.
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("code = %s reason = %s desc = %s", e.Code, e.Reason, e.Message)
+	if e.cause != nil {
+		msg += ": " + e.cause.Error()
+	}
+
+	return msg
+}
+
+// Unwrap returns the cause of the error.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is an Error with the same code and reason.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && t.Reason == e.Reason
+}
+
+// WithCause returns a copy of the error with the cause.
+func (e *Error) WithCause(err error) *Error {
+	cp := *e
+	cp.cause = err
+	return &cp
+}
+
+// WithMetadata returns a copy of the error with the metadata added.
+func (e *Error) WithMetadata(md map[string]string) *Error {
+	cp := *e
+	cp.Metadata = make(map[string]string, len(e.Metadata)+len(md))
+	for k, v := range e.Metadata {
+		cp.Metadata[k] = v
+	}
+
+	for k, v := range md {
+		cp.Metadata[k] = v
+	}
+
+	return &cp
+}
+
+// WithDomain returns a copy of the error with the domain.
+func (e *Error) WithDomain(domain string) *Error {
+	cp := *e
+	cp.Domain = domain
+	return &cp
+}
+
+// WithLocalizedMessage returns a copy of the error with the localized message, eg: en-US
+func (e *Error) WithLocalizedMessage(locale string, message string) *Error {
+	cp := *e
+	cp.Locale = locale
+	cp.LocalizedMessage = message
+	return &cp
+}
+
+// GRPCStatus returns the gRPC status with the error details,
+// it is used by the gRPC server to send the error.
+func (e *Error) GRPCStatus() *status.Status {
+	// WithDetails fails only if the code is OK
+	st := status.New(e.Code, e.Message)
+	if e.Reason != "" || e.Domain != "" || len(e.Metadata) > 0 {
+		if s, err := st.WithDetails(&errdetails.ErrorInfo{Reason: e.Reason, Domain: e.Domain, Metadata: e.Metadata}); err == nil {
+			st = s
+		}
+	}
+
+	if len(e.FieldViolations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range e.FieldViolations {
+			br.FieldViolations = append(br.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{Field: v.Field, Description: v.Description})
+		}
+
+		if s, err := st.WithDetails(br); err == nil {
+			st = s
+		}
+	}
+
+	if e.LocalizedMessage != "" {
+		if s, err := st.WithDetails(&errdetails.LocalizedMessage{Locale: e.Locale, Message: e.LocalizedMessage}); err == nil {
+			st = s
+		}
+	}
+
+	return st
+}
+
+// FromError converts err to Error, the details of the gRPC status error are parsed,
+// so it can be used by the clients to read the error returned by the server.
+// It returns nil if err is nil.
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return NewError(codes.Canceled, "", err.Error()).WithCause(err)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewError(codes.DeadlineExceeded, "", err.Error()).WithCause(err)
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return NewError(codes.Unknown, "", err.Error()).WithCause(err)
+	}
+
+	e = NewError(st.Code(), "", st.Message())
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			e.Reason, e.Domain, e.Metadata = d.Reason, d.Domain, d.Metadata
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				e.FieldViolations = append(e.FieldViolations, FieldViolation{Field: v.Field, Description: v.Description})
+			}
+		case *errdetails.LocalizedMessage:
+			e.Locale, e.LocalizedMessage = d.Locale, d.Message
+		}
+	}
+
+	return e
+}
+
+// toStatusError maps the error returned by the handler to the error sent to the clients,
+// the unknown errors are mapped to Internal and their causes are logged.
+func (s *Service) toStatusError(ctx context.Context, method string, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Domain == "" && s.serviceName != "" {
+			return e.WithDomain(s.serviceName)
+		}
+
+		return e
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return err
+	}
+
+	requestID := GetStringFromMD(GetIncomingMD(ctx), XRequestID)
+	s.logger.Printf("x-request-id:%s method:%s internal error:%s\n", requestID, method, err.Error())
+
+	e = Internal(ReasonInternal, internalErrorMessage).WithCause(err)
+	if requestID != "" {
+		e = e.WithMetadata(map[string]string{"request_id": requestID})
+	}
+
+	return e.WithDomain(s.serviceName)
+}
+
+func (s *Service) errorUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	reply, err := handler(ctx, req)
+	if err != nil {
+		return nil, s.toStatusError(ctx, info.FullMethod, err)
+	}
+
+	return reply, nil
+}
+
+func (s *Service) errorStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	if err != nil {
+		return s.toStatusError(ss.Context(), info.FullMethod, err)
+	}
+
+	return nil
+}
+
+// ErrorBody is the JSON envelope of the gateway error response.
+type ErrorBody struct {
+	Error     *ErrorDetail `json:"error"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// ErrorDetail is the error in the gateway error response.
+type ErrorDetail struct {
+	Code             int32             `json:"code"`   // gRPC code
+	Status           string            `json:"status"` // gRPC code name, eg: NOT_FOUND
+	Message          string            `json:"message"`
+	Reason           string            `json:"reason,omitempty"`
+	Domain           string            `json:"domain,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	FieldViolations  []FieldViolation  `json:"field_violations,omitempty"`
+	Locale           string            `json:"locale,omitempty"`
+	LocalizedMessage string            `json:"localized_message,omitempty"`
+}
+
+// HTTPErrorHandler is the gateway error handler which renders the error as ErrorBody,
+// the http status is mapped from the gRPC code, and the request id is read from
+// the X-Request-Id header or generated.
+func HTTPErrorHandler(ctx context.Context, _ *gRuntime.ServeMux, _ gRuntime.Marshaler,
+	w http.ResponseWriter, r *http.Request, err error) {
+	e, httpStatus, requestID := gatewayError(ctx, w, r, err)
+	if httpStatus == 0 {
+		httpStatus = gRuntime.HTTPStatusFromCode(e.Code)
+	}
+
+	writeJSON(w, httpStatus, &ErrorBody{Error: newErrorDetail(e), RequestID: requestID})
+}
+
+// routingErrorHandler passes the routing error of the mux to the errorHandler with its http status,
+// so the 404 and 405 errors are rendered in the same way as the other errors.
+func (s *Service) routingErrorHandler(ctx context.Context, mux *gRuntime.ServeMux, marshaler gRuntime.Marshaler,
+	w http.ResponseWriter, r *http.Request, httpStatus int) {
+	err := &gRuntime.HTTPStatusError{HTTPStatus: httpStatus, Err: status.Error(codes.Internal, "Unexpected routing error")}
+	switch httpStatus {
+	case http.StatusBadRequest:
+		err.Err = status.Error(codes.InvalidArgument, http.StatusText(httpStatus))
+	case http.StatusMethodNotAllowed:
+		err.Err = status.Error(codes.Unimplemented, http.StatusText(httpStatus))
+	case http.StatusNotFound:
+		err.Err = status.Error(codes.NotFound, http.StatusText(httpStatus))
+	}
+
+	errorHandler := s.errorHandler
+	if errorHandler == nil {
+		errorHandler = gRuntime.DefaultHTTPErrorHandler
+	}
+
+	errorHandler(ctx, mux, marshaler, w, r, err)
+}
+
+// gatewayError converts the gateway error to Error and returns the request id,
+// the http status is returned if it is specified by the routing error.
+// The X-Request-Id and the gRPC header metadata are set to the response header.
+func gatewayError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) (*Error, int, string) {
+	httpStatus := 0
+	var statusErr *gRuntime.HTTPStatusError
+	if errors.As(err, &statusErr) {
+		httpStatus = statusErr.HTTPStatus
+		err = statusErr.Err
+	}
+
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID == "" {
+		requestID = Uuid()
+	}
+
+	if md, ok := gRuntime.ServerMetadataFromContext(ctx); ok {
+		for k, vv := range md.HeaderMD {
+			for _, v := range vv {
+				w.Header().Add(gRuntime.MetadataHeaderPrefix+k, v)
+			}
+		}
+	}
+
+	w.Header().Set("X-Request-Id", requestID)
+	return FromError(err), httpStatus, requestID
+}
+
+func newErrorDetail(e *Error) *ErrorDetail {
+	return &ErrorDetail{
+		Code:             int32(e.Code),
+		Status:           code.Code_name[int32(e.Code)],
+		Message:          e.Message,
+		Reason:           e.Reason,
+		Domain:           e.Domain,
+		Metadata:         e.Metadata,
+		FieldViolations:  e.FieldViolations,
+		Locale:           e.Locale,
+		LocalizedMessage: e.LocalizedMessage,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, httpStatus int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,173 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+var errTestUserNotFound = NotFound("USER_NOT_FOUND", "user not found")
+
+// errorGreeterService returns the error by the request name.
+type errorGreeterService struct {
+	pb.UnimplementedGreeterServiceServer
+}
+
+func (s *errorGreeterService) SayHello(ctx context.Context, in *pb.HelloReq) (*pb.HelloReply, error) {
+	switch in.Name {
+	case "daheige":
+		return &pb.HelloReply{Name: "hello," + in.Name, Message: "call ok"}, nil
+	case "invalid":
+		return nil, InvalidArgument("INVALID_NAME", "invalid name",
+			FieldViolation{Field: "name", Description: "name is invalid"}).
+			WithLocalizedMessage("en-US", "Please input a valid name")
+	case "missing":
+		return nil, errTestUserNotFound.WithMetadata(map[string]string{"name": in.Name})
+	case "panic":
+		panic("something wrong")
+	default:
+		return nil, errors.New("database is down")
+	}
+}
+
+func TestError(t *testing.T) {
+	var should = require.New(t)
+
+	e := errTestUserNotFound.WithMetadata(map[string]string{"user_id": "1"}).WithCause(errors.New("no rows"))
+	should.Nil(errTestUserNotFound.Metadata)
+	should.True(errors.Is(e, errTestUserNotFound))
+	should.False(errors.Is(e, NotFound("ORDER_NOT_FOUND", "order not found")))
+	should.Equal("code = NotFound reason = USER_NOT_FOUND desc = user not found: no rows", e.Error())
+	should.EqualError(errors.Unwrap(e), "no rows")
+
+	// the details are sent in the gRPC status
+	e = InvalidArgument("INVALID_NAME", "invalid name", FieldViolation{Field: "name", Description: "too long"}).
+		WithDomain("hello").WithLocalizedMessage("en-US", "The name is too long")
+	st, ok := status.FromError(e)
+	should.True(ok)
+	should.Equal(codes.InvalidArgument, st.Code())
+	should.Len(st.Details(), 3)
+
+	got := FromError(st.Err())
+	should.Equal(codes.InvalidArgument, got.Code)
+	should.Equal("INVALID_NAME", got.Reason)
+	should.Equal("hello", got.Domain)
+	should.Equal([]FieldViolation{{Field: "name", Description: "too long"}}, got.FieldViolations)
+	should.Equal("The name is too long", got.LocalizedMessage)
+
+	should.Nil(FromError(nil))
+	should.Equal(codes.Unknown, FromError(errors.New("abc")).Code)
+	should.Equal(codes.DeadlineExceeded, FromError(context.DeadlineExceeded).Code)
+}
+
+func TestToStatusError(t *testing.T) {
+	var should = assert.New(t)
+	s := NewService(WithServiceName("hello"))
+	ctx := context.Background()
+
+	err := s.toStatusError(ctx, "/hello/Say", errors.New("database is down"))
+	e := FromError(err)
+	should.Equal(codes.Internal, e.Code)
+	should.Equal(ReasonInternal, e.Reason)
+	should.Equal("hello", e.Domain)
+	should.EqualError(errors.Unwrap(e), "database is down")
+
+	err = s.toStatusError(ctx, "/hello/Say", errTestUserNotFound)
+	should.Equal("hello", FromError(err).Domain)
+	should.Empty(errTestUserNotFound.Domain)
+
+	err = status.Error(codes.NotFound, "not found")
+	should.Equal(err, s.toStatusError(ctx, "/hello/Say", err))
+	should.Equal(codes.Canceled, status.Code(s.toStatusError(ctx, "/hello/Say", context.Canceled)))
+}
+
+func TestServiceError(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithRequestAccess(true),
+		WithErrorHandler(HTTPErrorHandler),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &errorGreeterService{})
+
+	addr := startTestServer(t, s)
+
+	// gRPC client reads the details
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	_, err = pb.NewGreeterServiceClient(conn).SayHello(context.Background(), &pb.HelloReq{Name: "missing"})
+	e := FromError(err)
+	should.True(errors.Is(e, errTestUserNotFound))
+	should.Equal(map[string]string{"name": "missing"}, e.Metadata)
+
+	_, err = pb.NewGreeterServiceClient(conn).SayHello(context.Background(), &pb.HelloReq{Name: "plain"})
+	should.Equal(codes.Internal, status.Code(err))
+	should.Equal(internalErrorMessage, FromError(err).Message)
+
+	// gateway renders the error envelope
+	for _, c := range []struct {
+		name   string
+		status int
+		detail ErrorDetail
+	}{
+		{"invalid", http.StatusBadRequest, ErrorDetail{
+			Code: 3, Status: "INVALID_ARGUMENT", Message: "invalid name", Reason: "INVALID_NAME",
+			FieldViolations: []FieldViolation{{Field: "name", Description: "name is invalid"}},
+			Locale:          "en-US", LocalizedMessage: "Please input a valid name",
+		}},
+		{"missing", http.StatusNotFound, ErrorDetail{
+			Code: 5, Status: "NOT_FOUND", Message: "user not found", Reason: "USER_NOT_FOUND",
+			Metadata: map[string]string{"name": "missing"},
+		}},
+		{"plain", http.StatusInternalServerError, ErrorDetail{
+			Code: 13, Status: "INTERNAL", Message: internalErrorMessage, Reason: ReasonInternal,
+		}},
+		{"panic", http.StatusInternalServerError, ErrorDetail{
+			Code: 13, Status: "INTERNAL", Message: internalErrorMessage, Reason: ReasonInternal,
+		}},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/v1/say/"+c.name, nil)
+		should.NoError(err)
+		req.Header.Set("X-Request-Id", "req-"+c.name)
+		res, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+
+		body := &ErrorBody{}
+		should.NoError(json.NewDecoder(res.Body).Decode(body))
+		res.Body.Close()
+		should.Equal(c.status, res.StatusCode, c.name)
+		should.Equal("req-"+c.name, body.RequestID)
+		should.Equal("req-"+c.name, res.Header.Get("X-Request-Id"))
+
+		// the request id generated by the gRPC server
+		delete(body.Error.Metadata, "request_id")
+		if len(body.Error.Metadata) == 0 {
+			body.Error.Metadata = nil
+		}
+
+		should.Equal(c.detail, *body.Error, c.name)
+	}
+
+	// the routing error
+	res, err := http.Get("http://" + addr + "/v1/unknown")
+	should.NoError(err)
+	body := &ErrorBody{}
+	should.NoError(json.NewDecoder(res.Body).Decode(body))
+	res.Body.Close()
+	should.Equal(http.StatusNotFound, res.StatusCode)
+	should.Equal("NOT_FOUND", body.Error.Status)
+	should.NotEmpty(body.RequestID)
+}
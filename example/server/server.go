@@ -114,11 +114,14 @@ func (s *greeterService) SayHello(ctx context.Context, in *pb.HelloReq) (*pb.Hel
 	// panic(111)
 	// The panic simulated here can be automatically captured in the request
 	// interceptor to record the operation log
-	log.Println("req data: ", in)
+	// the logger writes the request id, method and client ip of the request
+	logger := gmicro.LoggerFromContext(ctx)
+	logger.Printf("req data: %v", in)
 	time.Sleep(12 * time.Millisecond)
 
-	md := gmicro.GetIncomingMD(ctx)
-	log.Println("request md: ", md)
+	if info, ok := gmicro.RequestInfoFromContext(ctx); ok {
+		logger.Printf("request received at: %s", info.StartTime.Format(time.RFC3339Nano))
+	}
 	return &pb.HelloReply{
 		Name:    "hello," + in.Name,
 		Message: "call ok",
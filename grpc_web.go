@@ -0,0 +1,238 @@
+package gmicro
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// the flag of the frame which contains the trailers in gRPC-Web response body
+	grpcWebTrailerFlag byte = 0x80
+)
+
+var (
+	// grpcWebRequestHeaders the request headers sent by the gRPC-Web clients,
+	// they are allowed by CORS when gRPC-Web is enabled.
+	grpcWebRequestHeaders = []string{"X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"}
+
+	// grpcWebExposedHeaders the response headers read by the gRPC-Web clients.
+	grpcWebExposedHeaders = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+)
+
+// isGRPCWebRequest reports whether r is a gRPC-Web request,
+// the content type is application/grpc-web or application/grpc-web-text with optional +proto.
+func isGRPCWebRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// GRPCWebHandlerFunc returns a http.Handler which translates the gRPC-Web requests to
+// grpcServer, and the other requests are handled by otherHandler.
+// Both the binary and the base64 text mode are supported, and the trailers are sent
+// in the response body as gRPC-Web specified, so it works over HTTP/1.1.
+// refer: https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md
+func GRPCWebHandlerFunc(grpcServer *grpc.Server, otherHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isGRPCWebRequest(r) {
+			otherHandler.ServeHTTP(w, r)
+			return
+		}
+
+		serveGRPCWeb(grpcServer, w, r)
+	})
+}
+
+func serveGRPCWeb(grpcServer *grpc.Server, w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, grpcWebTextContentType)
+
+	// the gRPC server only accepts the HTTP/2 requests with application/grpc content type
+	req := r.Clone(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	req.Header.Set("Content-Type", grpcContentType(contentType))
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	if text {
+		req.Body = &grpcWebTextReader{body: r.Body}
+	}
+
+	gw := &grpcWebResponseWriter{w: w, header: make(http.Header), text: text}
+	grpcServer.ServeHTTP(gw, req)
+	gw.writeTrailers()
+}
+
+// grpcContentType converts the gRPC-Web content type to the gRPC content type,
+// eg: application/grpc-web-text+proto to application/grpc+proto
+func grpcContentType(contentType string) string {
+	subtype := strings.TrimPrefix(contentType, grpcWebTextContentType)
+	if subtype == contentType {
+		subtype = strings.TrimPrefix(contentType, grpcWebContentType)
+	}
+
+	return "application/grpc" + subtype
+}
+
+// grpcWebResponseWriter converts the gRPC response to gRPC-Web response.
+type grpcWebResponseWriter struct {
+	w             http.ResponseWriter
+	header        http.Header // the header written by the gRPC server
+	text          bool        // base64 text mode
+	wroteHeader   bool
+	headerWritten map[string]bool // the header keys which are sent
+}
+
+// Header implements http.ResponseWriter interface.
+func (gw *grpcWebResponseWriter) Header() http.Header {
+	return gw.header
+}
+
+// WriteHeader implements http.ResponseWriter interface, the header declared as
+// the trailers are not sent, they are sent in the body by writeTrailers.
+func (gw *grpcWebResponseWriter) WriteHeader(code int) {
+	if gw.wroteHeader {
+		return
+	}
+
+	gw.wroteHeader = true
+	gw.headerWritten = make(map[string]bool, len(gw.header))
+	header := gw.w.Header()
+	for k, vv := range gw.header {
+		if k == "Trailer" || k == "Content-Type" {
+			continue
+		}
+
+		gw.headerWritten[k] = true
+		header[k] = vv
+	}
+
+	contentType := gw.header.Get("Content-Type")
+	if gw.text {
+		header.Set("Content-Type", strings.Replace(contentType, "application/grpc", grpcWebTextContentType, 1))
+	} else {
+		header.Set("Content-Type", strings.Replace(contentType, "application/grpc", grpcWebContentType, 1))
+	}
+
+	gw.w.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter interface.
+func (gw *grpcWebResponseWriter) Write(b []byte) (int, error) {
+	gw.WriteHeader(http.StatusOK)
+	if !gw.text {
+		return gw.w.Write(b)
+	}
+
+	_, err := gw.w.Write([]byte(base64.StdEncoding.EncodeToString(b)))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Flush implements http.Flusher interface.
+func (gw *grpcWebResponseWriter) Flush() {
+	gw.WriteHeader(http.StatusOK)
+	if f, ok := gw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeTrailers writes the trailers set after the header is written as the trailer frame.
+func (gw *grpcWebResponseWriter) writeTrailers() {
+	gw.WriteHeader(http.StatusOK)
+
+	var buf bytes.Buffer
+	for k, vv := range grpcTrailers(gw.header, gw.headerWritten) {
+		for _, v := range vv {
+			buf.WriteString(strings.ToLower(k) + ": " + v + "\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+buf.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(buf.Len()))
+	frame = append(frame, buf.Bytes()...)
+
+	_, _ = gw.Write(frame)
+	gw.Flush()
+}
+
+// grpcTrailers returns the trailers written by the gRPC server in header, they are either
+// prefixed with http.TrailerPrefix or set after the header keys in written are sent.
+func grpcTrailers(header http.Header, written map[string]bool) http.Header {
+	trailers := make(http.Header)
+	for k, vv := range header {
+		name := strings.TrimPrefix(k, http.TrailerPrefix)
+		if k == "Trailer" || (name == k && written[k]) {
+			continue
+		}
+
+		trailers[http.CanonicalHeaderKey(name)] = append(trailers[http.CanonicalHeaderKey(name)], vv...)
+	}
+
+	return trailers
+}
+
+// grpcWebTextReader decodes the base64 encoded request body, the body may be
+// made up of several base64 encoded chunks with padding.
+type grpcWebTextReader struct {
+	body    io.ReadCloser
+	pending []byte // the encoded bytes which are not decoded
+	decoded []byte // the decoded bytes which are not read
+	err     error
+}
+
+// Read implements io.Reader interface.
+func (t *grpcWebTextReader) Read(p []byte) (int, error) {
+	for len(t.decoded) == 0 {
+		if t.err != nil {
+			if t.err == io.EOF && len(t.pending) > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+
+			return 0, t.err
+		}
+
+		buf := make([]byte, 4096)
+		n, err := t.body.Read(buf)
+		t.err = err
+		for _, c := range buf[:n] {
+			if c != '\r' && c != '\n' {
+				t.pending = append(t.pending, c)
+			}
+		}
+
+		// decode the complete quantums, each one may be padded
+		size := len(t.pending) / 4 * 4
+		var dst [3]byte
+		for i := 0; i < size; i += 4 {
+			m, err := base64.StdEncoding.Decode(dst[:], t.pending[i:i+4])
+			if err != nil {
+				t.err = err
+				break
+			}
+
+			t.decoded = append(t.decoded, dst[:m]...)
+		}
+
+		t.pending = t.pending[size:]
+	}
+
+	n := copy(p, t.decoded)
+	t.decoded = t.decoded[n:]
+	return n, nil
+}
+
+// Close implements io.Closer interface.
+func (t *grpcWebTextReader) Close() error {
+	return t.body.Close()
+}
@@ -0,0 +1,146 @@
+package gmicro
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcWebCall sends the gRPC-Web request and returns the response message and trailers.
+func grpcWebCall(t *testing.T, url string, text bool, in proto.Message, out proto.Message) (http.Header, map[string]string) {
+	b, err := proto.Marshal(in)
+	require.NoError(t, err)
+
+	body := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(b)))
+	body = append(body, b...)
+
+	contentType := grpcWebContentType + "+proto"
+	if text {
+		contentType = grpcWebTextContentType + "+proto"
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, contentType, res.Header.Get("Content-Type"))
+
+	var reader io.Reader = res.Body
+	if text {
+		reader = &grpcWebTextReader{body: res.Body}
+	}
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	trailers := make(map[string]string)
+	for len(data) >= 5 {
+		flag, size := data[0], binary.BigEndian.Uint32(data[1:5])
+		frame := data[5 : 5+size]
+		data = data[5+size:]
+		if flag&grpcWebTrailerFlag == 0 {
+			require.NoError(t, proto.Unmarshal(frame, out))
+			continue
+		}
+
+		for _, line := range strings.Split(strings.TrimSpace(string(frame)), "\r\n") {
+			kv := strings.SplitN(line, ": ", 2)
+			trailers[kv[0]] = kv[1]
+		}
+	}
+
+	require.Len(t, data, 0)
+	return res.Header, trailers
+}
+
+func testGRPCWeb(t *testing.T, baseURL string) {
+	for _, text := range []bool{false, true} {
+		reply := &pb.HelloReply{}
+		header, trailers := grpcWebCall(t, baseURL+pb.GreeterService_SayHello_FullMethodName, text,
+			&pb.HelloReq{Name: "daheige"}, reply)
+		assert.Equal(t, "hello,daheige", reply.Name)
+		assert.Equal(t, "0", trailers["grpc-status"])
+		assert.Empty(t, header.Get("Grpc-Status"))
+
+		// the trailers-only response
+		_, trailers = grpcWebCall(t, baseURL+"/App.Grpc.Hello.GreeterService/Unknown", text,
+			&pb.HelloReq{Name: "daheige"}, reply)
+		assert.Equal(t, "12", trailers["grpc-status"])
+		assert.NotEmpty(t, trailers["grpc-message"])
+	}
+}
+
+func TestGRPCWebSharePort(t *testing.T) {
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithGRPCWeb(true),
+		WithCORS(CORSConfig{AllowedOrigins: []string{"*"}}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &greeterService{})
+
+	addr := startTestServer(t, s)
+
+	testGRPCWeb(t, "http://"+addr)
+
+	// preflight of gRPC-Web request
+	req, err := http.NewRequest(http.MethodOptions, "http://"+addr+pb.GreeterService_SayHello_FullMethodName, nil)
+	require.NoError(t, err)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,x-user-agent")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "content-type,x-grpc-web,x-user-agent", res.Header.Get("Access-Control-Allow-Headers"))
+}
+
+func TestGRPCWebHTTPPort(t *testing.T) {
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithGRPCWeb(true),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &greeterService{})
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Start(28082, 29991)
+	}()
+	<-s.Started()
+
+	testGRPCWeb(t, "http://127.0.0.1:28082")
+
+	s.Stop()
+	require.NoError(t, <-errChan)
+}
+
+func TestGRPCWebTextReader(t *testing.T) {
+	// two padded chunks
+	body := base64.StdEncoding.EncodeToString([]byte("hello")) + base64.StdEncoding.EncodeToString([]byte(",world"))
+	b, err := io.ReadAll(&grpcWebTextReader{body: io.NopCloser(strings.NewReader(body))})
+	require.NoError(t, err)
+	assert.Equal(t, "hello,world", string(b))
+
+	_, err = io.ReadAll(&grpcWebTextReader{body: io.NopCloser(strings.NewReader("aGVsbG8"))})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = io.ReadAll(&grpcWebTextReader{body: io.NopCloser(strings.NewReader("!!!!"))})
+	assert.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"math/rand"
 	"net"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -91,3 +92,14 @@ func Uuid() string {
 
 	return strings.Replace(u.String(), "-", "", -1)
 }
+
+// GetHTTPClientIP get client ip address from http request, it is the ClientIP of the RequestInfo
+// set by the Service, which trusts the X-Forwarded-For and X-Real-Ip headers only if they are
+// sent by the proxies of WithTrustedProxies, or the remote address of the request.
+func GetHTTPClientIP(r *http.Request) string {
+	if info, ok := RequestInfoFromContext(r.Context()); ok && info.ClientIP != "" {
+		return info.ClientIP
+	}
+
+	return remoteIP(r)
+}
@@ -0,0 +1,30 @@
+package gmicro
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestServer starts the gRPC and http server on a random shared port,
+// it returns the listening address, and stops the server when the test finishes.
+func startTestServer(t *testing.T, s *Service) string {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.StartGRPCAndHTTPServer(0)
+	}()
+	select {
+	case <-s.Started():
+	case err := <-errChan:
+		t.Fatalf("start server error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		s.stopGRPCAndHTTPServer()
+		assert.NoError(t, <-errChan)
+	})
+
+	_, port, _ := net.SplitHostPort(s.httpServerAddress)
+	return net.JoinHostPort("127.0.0.1", port)
+}
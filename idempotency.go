@@ -0,0 +1,358 @@
+package gmicro
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	// IdempotencyKeyHeader is the http header and the gRPC metadata key of the idempotency key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set to the gRPC header of the replayed reply,
+	// the gateway returns it as the Grpc-Metadata-Idempotent-Replayed http header.
+	IdempotentReplayedHeader = "idempotent-replayed"
+
+	// ReasonIdempotencyKeyRequired the idempotency key is required by the method.
+	ReasonIdempotencyKeyRequired = "IDEMPOTENCY_KEY_REQUIRED"
+
+	// ReasonIdempotencyConflict the request of the same idempotency key is being handled.
+	ReasonIdempotencyConflict = "IDEMPOTENCY_CONFLICT"
+
+	// ReasonIdempotencyKeyReused the idempotency key is used by a different request.
+	ReasonIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+
+	// the default capacity and ttl of the memory idempotency store
+	defaultIdempotencyCapacity = 10000
+	defaultIdempotencyTTL      = 24 * time.Hour
+
+	// the timeout of storing and releasing the key after the handler returns
+	idempotencyStoreTimeout = 5 * time.Second
+)
+
+// ErrIdempotencyInProgress the request of the idempotency key is being handled.
+var ErrIdempotencyInProgress = errors.New("idempotent request in progress")
+
+// IdempotencyRecord is the reply of the request stored by the idempotency key.
+type IdempotencyRecord struct {
+	RequestHash string // sha256 of the deterministic marshalled request
+	ReplyType   string // full name of the reply message, eg: App.Grpc.Hello.HelloReply
+	Reply       []byte // marshalled reply
+}
+
+// IdempotencyStore stores the replies of the idempotent requests, it must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Begin reserves the key, the stored record is returned if the request is completed,
+	// ErrIdempotencyInProgress is returned if the key is reserved by another request.
+	// nil record and nil error are returned when the key is reserved.
+	Begin(ctx context.Context, key string) (*IdempotencyRecord, error)
+
+	// Complete stores the record of the key reserved by Begin.
+	Complete(ctx context.Context, key string, record *IdempotencyRecord) error
+
+	// Release releases the key reserved by Begin when the request fails, so it can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// Idempotency is the config of the idempotent unary RPCs, the replies of the requests with
+// the Idempotency-Key header or metadata are stored, and the stored reply is returned for the
+// repeated requests, the concurrent duplicates are rejected with codes.Aborted.
+// The failed requests are not stored, so they can be retried with the same key.
+// The replies are stored by the tenant, the authorization metadata of the caller, the method and the key,
+// and the interceptor runs after the interceptors of WithUnaryInterceptor, so the replays are authenticated.
+type Idempotency struct {
+	// Methods the gRPC full methods, eg: /App.Grpc.Hello.GreeterService/SayHello,
+	// the method ending with * matches the methods having its prefix.
+	Methods []string
+
+	// Store the store of the replies, default: NewMemoryIdempotencyStore(10000, 24h)
+	Store IdempotencyStore
+
+	// Required rejects the requests of Methods without the idempotency key.
+	Required bool
+}
+
+// match reports whether the method is configured.
+func (c *Idempotency) match(method string) bool {
+	for _, m := range c.Methods {
+		if m == method || (strings.HasSuffix(m, "*") && strings.HasPrefix(method, strings.TrimSuffix(m, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newIdempotency returns the config with the default store.
+func newIdempotency(c Idempotency) *Idempotency {
+	if c.Store == nil {
+		c.Store = NewMemoryIdempotencyStore(defaultIdempotencyCapacity, defaultIdempotencyTTL)
+	}
+
+	return &c
+}
+
+// annotator forwards the Idempotency-Key header of the gateway requests.
+func (c *Idempotency) annotator(_ context.Context, r *http.Request) metadata.MD {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return nil
+	}
+
+	return metadata.Pairs(IdempotencyKeyHeader, key)
+}
+
+// idempotencyUnaryInterceptor replays the stored reply of the idempotency key.
+func (s *Service) idempotencyUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if !s.idempotency.match(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	keys := GetIncomingMD(ctx).Get(IdempotencyKeyHeader)
+	if len(keys) == 0 || keys[0] == "" {
+		if s.idempotency.Required {
+			return nil, InvalidArgument(ReasonIdempotencyKeyRequired, "idempotency key is required")
+		}
+
+		return handler(ctx, req)
+	}
+
+	reqMsg, ok := req.(proto.Message)
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	hash, err := requestHash(reqMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the keys of the tenants, the callers and the methods never collide
+	tenant, _ := TenantID.From(ctx)
+	key := tenant + "\x00" + callerHash(ctx) + "\x00" + info.FullMethod + "\x00" + keys[0]
+	store := s.idempotency.Store
+	record, err := store.Begin(ctx, key)
+	if errors.Is(err, ErrIdempotencyInProgress) {
+		return nil, Aborted(ReasonIdempotencyConflict, "the request of the idempotency key is in progress")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if record != nil {
+		return replayRecord(ctx, record, hash)
+	}
+
+	// the key is stored or released even if the client cancels the request,
+	// otherwise it stays in progress and the retries are rejected until it expires
+	storeCtx, cancel := context.WithTimeout(detachedContext{ctx}, idempotencyStoreTimeout)
+	defer cancel()
+
+	completed := false
+	defer func() {
+		// the key is released if the handler fails or panics
+		if !completed {
+			if e := store.Release(storeCtx, key); e != nil {
+				LoggerFromContext(ctx).Printf("release idempotency key error: %s\n", e.Error())
+			}
+		}
+	}()
+
+	reply, err := handler(ctx, req)
+	if err != nil {
+		return reply, err
+	}
+
+	replyMsg, ok := reply.(proto.Message)
+	if !ok {
+		return reply, nil
+	}
+
+	b, err := proto.Marshal(replyMsg)
+	if err != nil {
+		return reply, nil
+	}
+
+	record = &IdempotencyRecord{RequestHash: hash, ReplyType: string(proto.MessageName(replyMsg)), Reply: b}
+	if err = store.Complete(storeCtx, key, record); err != nil {
+		LoggerFromContext(ctx).Printf("store idempotent reply error: %s\n", err.Error())
+		return reply, nil
+	}
+
+	completed = true
+	return reply, nil
+}
+
+// detachedContext keeps the values of the parent context without its deadline and cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+// Deadline implements context.Context interface.
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done implements context.Context interface.
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err implements context.Context interface.
+func (detachedContext) Err() error {
+	return nil
+}
+
+// replayRecord returns the stored reply if the request is the same as the stored one.
+func replayRecord(ctx context.Context, record *IdempotencyRecord, hash string) (interface{}, error) {
+	if record.RequestHash != hash {
+		return nil, InvalidArgument(ReasonIdempotencyKeyReused, "the idempotency key is used by a different request")
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(record.ReplyType))
+	if err != nil {
+		return nil, err
+	}
+
+	reply := mt.New().Interface()
+	if err = proto.Unmarshal(record.Reply, reply); err != nil {
+		return nil, err
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(IdempotentReplayedHeader, "true"))
+	return reply, nil
+}
+
+// callerHash returns the sha256 of the authorization metadata of the incoming context,
+// so the stored and the cached replies of a caller are not returned to the others.
+func callerHash(ctx context.Context) string {
+	values := GetIncomingMD(ctx).Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(values, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestHash returns the sha256 of the deterministic marshalled request.
+func requestHash(req proto.Message) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// memoryIdempotencyStore is the in-memory LRU IdempotencyStore.
+type memoryIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	lru      *list.List // the front is the most recently used
+}
+
+type idempotencyEntry struct {
+	key      string
+	record   *IdempotencyRecord // nil when the request is in progress
+	expireAt time.Time
+}
+
+// NewMemoryIdempotencyStore returns an in-memory IdempotencyStore, the least recently used
+// records are evicted when it holds capacity records, and the records expire after ttl.
+func NewMemoryIdempotencyStore(capacity int, ttl time.Duration) IdempotencyStore {
+	return &memoryIdempotencyStore{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Begin implements IdempotencyStore interface.
+func (m *memoryIdempotencyStore) Begin(_ context.Context, key string) (*IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		entry := el.Value.(*idempotencyEntry)
+		if time.Now().Before(entry.expireAt) {
+			if entry.record == nil {
+				return nil, ErrIdempotencyInProgress
+			}
+
+			m.lru.MoveToFront(el)
+			return entry.record, nil
+		}
+
+		m.remove(el)
+	}
+
+	m.set(key, nil)
+	return nil, nil
+}
+
+// Complete implements IdempotencyStore interface.
+func (m *memoryIdempotencyStore) Complete(_ context.Context, key string, record *IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+
+	m.set(key, record)
+	return nil
+}
+
+// Release implements IdempotencyStore interface.
+func (m *memoryIdempotencyStore) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok && el.Value.(*idempotencyEntry).record == nil {
+		m.remove(el)
+	}
+
+	return nil
+}
+
+// set adds the entry and evicts the least recently used ones, m.mu must be held.
+// The reservations in progress are not evicted, otherwise the concurrent duplicates
+// could reserve the key again.
+func (m *memoryIdempotencyStore) set(key string, record *IdempotencyRecord) {
+	m.entries[key] = m.lru.PushFront(&idempotencyEntry{key: key, record: record, expireAt: time.Now().Add(m.ttl)})
+	el := m.lru.Back()
+	for m.capacity > 0 && m.lru.Len() > m.capacity && el != nil {
+		prev := el.Prev()
+		if el.Value.(*idempotencyEntry).record != nil {
+			m.remove(el)
+		}
+
+		el = prev
+	}
+}
+
+// remove removes the entry, m.mu must be held.
+func (m *memoryIdempotencyStore) remove(el *list.Element) {
+	m.lru.Remove(el)
+	delete(m.entries, el.Value.(*idempotencyEntry).key)
+}
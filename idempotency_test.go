@@ -0,0 +1,219 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// countingGreeterService counts the calls, the call of name slow blocks until release is closed.
+type countingGreeterService struct {
+	pb.UnimplementedGreeterServiceServer
+	calls   int64
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *countingGreeterService) SayHello(_ context.Context, in *pb.HelloReq) (*pb.HelloReply, error) {
+	n := atomic.AddInt64(&s.calls, 1)
+	switch in.Name {
+	case "slow":
+		close(s.started)
+		<-s.release
+	case "fail":
+		return nil, Unavailable("RETRY", "please retry")
+	}
+
+	return &pb.HelloReply{Name: "hello," + in.Name, Message: strconv.FormatInt(n, 10)}, nil
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	var should = require.New(t)
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore(2, time.Hour)
+
+	record, err := store.Begin(ctx, "a")
+	should.NoError(err)
+	should.Nil(record)
+	_, err = store.Begin(ctx, "a")
+	should.ErrorIs(err, ErrIdempotencyInProgress)
+
+	should.NoError(store.Release(ctx, "a"))
+	_, err = store.Begin(ctx, "a")
+	should.NoError(err)
+	should.NoError(store.Complete(ctx, "a", &IdempotencyRecord{RequestHash: "h"}))
+
+	// the completed record is not released
+	should.NoError(store.Release(ctx, "a"))
+	record, err = store.Begin(ctx, "a")
+	should.NoError(err)
+	should.Equal("h", record.RequestHash)
+
+	// b and c evict the least recently used a
+	_, _ = store.Begin(ctx, "b")
+	_, _ = store.Begin(ctx, "c")
+	record, err = store.Begin(ctx, "a")
+	should.NoError(err)
+	should.Nil(record)
+
+	// the reservations in progress are not evicted
+	_, err = store.Begin(ctx, "b")
+	should.ErrorIs(err, ErrIdempotencyInProgress)
+
+	store = NewMemoryIdempotencyStore(0, time.Millisecond)
+	should.NoError(store.Complete(ctx, "a", &IdempotencyRecord{}))
+	time.Sleep(5 * time.Millisecond)
+	record, err = store.Begin(ctx, "a")
+	should.NoError(err)
+	should.Nil(record)
+}
+
+// contextIdempotencyStore fails like a remote store when the context is done.
+type contextIdempotencyStore struct {
+	IdempotencyStore
+}
+
+func (s contextIdempotencyStore) Release(ctx context.Context, key string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return s.IdempotencyStore.Release(ctx, key)
+}
+
+func TestIdempotencyCanceledRequest(t *testing.T) {
+	var should = require.New(t)
+	store := contextIdempotencyStore{NewMemoryIdempotencyStore(10, time.Hour)}
+	s := NewService(WithIdempotency(Idempotency{Methods: []string{"/a"}, Store: store}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/a"}
+
+	// the key is released though the client canceled the request
+	ctx, cancel := context.WithCancel(metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(IdempotencyKeyHeader, "k1")))
+	_, err := s.idempotencyUnaryInterceptor(ctx, &pb.HelloReq{Name: "a"}, info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			cancel()
+			return nil, ctx.Err()
+		})
+	should.ErrorIs(err, context.Canceled)
+
+	reply, err := s.idempotencyUnaryInterceptor(metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(IdempotencyKeyHeader, "k1")), &pb.HelloReq{Name: "a"}, info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &pb.HelloReply{Message: "ok"}, nil
+		})
+	should.NoError(err)
+	should.Equal("ok", reply.(*pb.HelloReply).Message)
+}
+
+func TestIdempotency(t *testing.T) {
+	var should = require.New(t)
+	greeter := &countingGreeterService{started: make(chan struct{}), release: make(chan struct{})}
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithIdempotency(Idempotency{Methods: []string{"/App.Grpc.Hello.GreeterService/*"}, Required: true}),
+		WithUnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			if values := GetIncomingMD(ctx).Get("authorization"); len(values) > 0 && values[0] == "denied" {
+				return nil, Unauthenticated(ReasonInvalidToken, "denied")
+			}
+
+			return handler(ctx, req)
+		}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, greeter)
+
+	addr := startTestServer(t, s)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	client := pb.NewGreeterServiceClient(conn)
+	call := func(key string, name string, pairs ...string) (*pb.HelloReply, metadata.MD, error) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), pairs...)
+		if key != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, IdempotencyKeyHeader, key)
+		}
+
+		var header metadata.MD
+		reply, err := client.SayHello(ctx, &pb.HelloReq{Name: name}, grpc.Header(&header))
+		return reply, header, err
+	}
+
+	_, _, err = call("", "daheige")
+	should.Equal(codes.InvalidArgument, status.Code(err))
+
+	reply, header, err := call("k1", "daheige")
+	should.NoError(err)
+	should.Equal("1", reply.Message)
+	should.Empty(header.Get(IdempotentReplayedHeader))
+
+	reply, header, err = call("k1", "daheige")
+	should.NoError(err)
+	should.Equal("1", reply.Message)
+	should.Equal("hello,daheige", reply.Name)
+	should.Equal([]string{"true"}, header.Get(IdempotentReplayedHeader))
+
+	_, _, err = call("k1", "other")
+	should.ErrorIs(FromError(err), InvalidArgument(ReasonIdempotencyKeyReused, ""))
+
+	// the replay is authenticated by the user interceptors, and is not shared by the callers
+	_, _, err = call("k1", "daheige", "authorization", "denied")
+	should.Equal(codes.Unauthenticated, status.Code(err))
+	reply, header, err = call("k1", "daheige", "authorization", "Bearer other")
+	should.NoError(err)
+	should.Equal("2", reply.Message)
+	should.Empty(header.Get(IdempotentReplayedHeader))
+
+	// the failed request is not stored
+	_, _, err = call("k2", "fail")
+	should.Equal(codes.Unavailable, status.Code(err))
+	_, _, err = call("k2", "fail")
+	should.Equal(codes.Unavailable, status.Code(err))
+	should.Equal(int64(4), atomic.LoadInt64(&greeter.calls))
+
+	// the concurrent duplicate is rejected
+	slowErr := make(chan error, 1)
+	go func() {
+		_, _, err := call("k3", "slow")
+		slowErr <- err
+	}()
+	<-greeter.started
+	_, _, err = call("k3", "slow")
+	should.ErrorIs(FromError(err), Aborted(ReasonIdempotencyConflict, ""))
+	close(greeter.release)
+	should.NoError(<-slowErr)
+
+	// the gateway forwards the Idempotency-Key header
+	var messages []interface{}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/v1/say/gateway", nil)
+		should.NoError(err)
+		req.Header.Set(IdempotencyKeyHeader, "k4")
+		res, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+		var body map[string]interface{}
+		should.NoError(json.NewDecoder(res.Body).Decode(&body))
+		res.Body.Close()
+		should.Equal(http.StatusOK, res.StatusCode)
+		messages = append(messages, body["message"])
+		assert.Equal(t, i == 1, res.Header.Get("Grpc-Metadata-Idempotent-Replayed") == "true")
+	}
+
+	should.Equal(messages[0], messages[1])
+}
@@ -0,0 +1,250 @@
+package gmicro
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// the html page of the introspection
+var introspectionTemplate = template.Must(template.New("introspection").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Name}} routes</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse;margin-bottom:24px}
+th,td{border:1px solid #ccc;padding:4px 8px;text-align:left}</style></head>
+<body>
+<h1>{{.Name}}</h1>
+<h2>Routes</h2>
+<table><tr><th>Method</th><th>Path</th><th>Name</th><th>Middlewares</th></tr>
+{{range .Routes}}<tr><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.Name}}</td><td>{{.Middlewares}}</td></tr>
+{{end}}</table>
+<h2>Gateway bindings</h2>
+<table><tr><th>Method</th><th>Path</th><th>Body</th><th>gRPC method</th></tr>
+{{range .Bindings}}<tr><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.Body}}</td><td>{{.FullMethod}}</td></tr>
+{{end}}</table>
+<h2>Stream routes</h2>
+<table><tr><th>Path</th><th>gRPC method</th></tr>
+{{range .StreamRoutes}}<tr><td>{{.Path}}</td><td>{{.FullMethod}}</td></tr>
+{{end}}</table>
+<h2>gRPC services</h2>
+<table><tr><th>Method</th><th>Client streaming</th><th>Server streaming</th></tr>
+{{range .Services}}{{range .Methods}}<tr><td>{{.FullMethod}}</td><td>{{.ClientStreaming}}</td><td>{{.ServerStreaming}}</td></tr>
+{{end}}{{end}}</table>
+<h2>Interceptors</h2>
+<table><tr><th>Unary</th><th>Stream</th></tr>
+<tr><td>{{range .UnaryInterceptors}}{{.}}<br>{{end}}</td><td>{{range .StreamInterceptors}}{{.}}<br>{{end}}</td></tr></table>
+<h2>Static</h2>
+<table><tr><th>Prefix</th><th>Files</th><th>SPA fallback</th><th>Directory listing</th></tr>
+{{with .Static}}<tr><td>{{.Prefix}}</td><td>{{.Files}}</td><td>{{.SPAFallback}}</td><td>{{.DirectoryListing}}</td></tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// Introspection describes what the Service exposes, the interceptors are listed in the chained order,
+// the first one is the outermost.
+type Introspection struct {
+	Name               string            `json:"name"`
+	Routes             []RouteInfo       `json:"routes"`
+	Bindings           []BindingInfo     `json:"bindings"`
+	StreamRoutes       []StreamRoute     `json:"stream_routes"`
+	Services           []GRPCServiceInfo `json:"services"`
+	UnaryInterceptors  []string          `json:"unary_interceptors"`
+	StreamInterceptors []string          `json:"stream_interceptors"`
+	Static             *StaticMountInfo  `json:"static,omitempty"`
+}
+
+// RouteInfo is the custom Route added by WithRouteOpt, WithRouteGroup or AddRoute.
+type RouteInfo struct {
+	Name        string `json:"name,omitempty"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Middlewares int    `json:"middlewares"`
+}
+
+// BindingInfo is the http binding of the gRPC method declared by the google.api.http option,
+// it is listed only if the gateway handler of the service is registered by WithServiceHandlerFromEndpoint
+// or AddServiceHandlerFromEndpoint with the service name.
+type BindingInfo struct {
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Body       string `json:"body,omitempty"`
+	FullMethod string `json:"full_method"`
+}
+
+// GRPCServiceInfo is the gRPC service registered to GRPCServer.
+type GRPCServiceInfo struct {
+	Name    string           `json:"name"`
+	Methods []GRPCMethodInfo `json:"methods"`
+}
+
+// GRPCMethodInfo is the method of the gRPC service.
+type GRPCMethodInfo struct {
+	FullMethod      string `json:"full_method"`
+	ClientStreaming bool   `json:"client_streaming"`
+	ServerStreaming bool   `json:"server_streaming"`
+}
+
+// StaticMountInfo is the static files mount of WithStatic or WithStaticAccess.
+type StaticMountInfo struct {
+	Prefix           string `json:"prefix"`
+	Files            string `json:"files"`
+	SPAFallback      bool   `json:"spa_fallback"`
+	DirectoryListing bool   `json:"directory_listing"`
+}
+
+// Introspect returns what the Service exposes, the gRPC services registered after it
+// is called are not included.
+func (s *Service) Introspect() *Introspection {
+	in := &Introspection{
+		Name:         s.serviceName,
+		Routes:       make([]RouteInfo, 0, len(s.routes)),
+		Bindings:     []BindingInfo{},
+		StreamRoutes: append([]StreamRoute{}, s.streamRoutes...),
+		Services:     []GRPCServiceInfo{},
+	}
+
+	for _, route := range s.routes {
+		in.Routes = append(in.Routes, RouteInfo{
+			Name:        route.Name,
+			Method:      route.Method,
+			Path:        "/" + strings.TrimPrefix(route.Path, "/"),
+			Middlewares: len(route.Middlewares),
+		})
+	}
+
+	unaryInterceptors, streamInterceptors := s.chainedInterceptors()
+	for _, i := range unaryInterceptors {
+		in.UnaryInterceptors = append(in.UnaryInterceptors, funcName(i))
+	}
+
+	for _, i := range streamInterceptors {
+		in.StreamInterceptors = append(in.StreamInterceptors, funcName(i))
+	}
+
+	if s.static != nil {
+		in.Static = &StaticMountInfo{
+			Prefix:           s.static.Prefix,
+			Files:            s.static.Root,
+			SPAFallback:      s.static.SPAFallback,
+			DirectoryListing: s.static.DirectoryListing,
+		}
+		if in.Static.Files == "" {
+			in.Static.Files = fmt.Sprintf("%T", s.static.FS)
+		}
+	}
+
+	if s.GRPCServer == nil {
+		return in
+	}
+
+	info := s.GRPCServer.GetServiceInfo()
+	names := make([]string, 0, len(info))
+	for name := range info {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		svc := GRPCServiceInfo{Name: name}
+		for _, m := range info[name].Methods {
+			svc.Methods = append(svc.Methods, GRPCMethodInfo{
+				FullMethod:      "/" + name + "/" + m.Name,
+				ClientStreaming: m.IsClientStream,
+				ServerStreaming: m.IsServerStream,
+			})
+		}
+
+		in.Services = append(in.Services, svc)
+		if s.gatewayServices[name] {
+			in.Bindings = append(in.Bindings, httpBindings(name)...)
+		}
+	}
+
+	return in
+}
+
+// httpBindings returns the http bindings declared by the google.api.http options of the service.
+func httpBindings(service string) []BindingInfo {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil
+	}
+
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+
+	var bindings []BindingInfo
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+
+		fullMethod := "/" + service + "/" + string(md.Name())
+		for _, r := range append([]*annotations.HttpRule{rule}, rule.AdditionalBindings...) {
+			if b, ok := httpBinding(r); ok {
+				b.FullMethod = fullMethod
+				bindings = append(bindings, b)
+			}
+		}
+	}
+
+	return bindings
+}
+
+func httpBinding(rule *annotations.HttpRule) (BindingInfo, bool) {
+	b := BindingInfo{Body: rule.Body}
+	switch p := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		b.Method, b.Path = http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		b.Method, b.Path = http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		b.Method, b.Path = http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		b.Method, b.Path = http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		b.Method, b.Path = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		b.Method, b.Path = p.Custom.Kind, p.Custom.Path
+	default:
+		return b, false
+	}
+
+	return b, true
+}
+
+// funcName returns the full name of the function,
+// eg: github.com/daheige/gmicro/v2.(*Service).errorUnaryInterceptor
+func funcName(f interface{}) string {
+	return strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name(), "-fm")
+}
+
+// introspectionHandler renders the Introspection as JSON if the request accepts
+// application/json or format=json is in the query, otherwise as HTML.
+func (s *Service) introspectionHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	in := s.Introspect()
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, in)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := introspectionTemplate.Execute(w, in); err != nil {
+		s.logger.Printf("render introspection error: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,74 @@
+package gmicro
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospection(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithServiceName("greeter"),
+		WithServiceHandlerFromEndpoint("App.Grpc.Hello.GreeterService", pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithStatic(Static{FS: fstest.MapFS{}, Prefix: "/web", SPAFallback: true}),
+		WithIntrospection("/debug/routes", traceMiddleware("admin")),
+		WithRouteGroup(RouteGroup{
+			Prefix: "/api",
+			Routes: []Route{{Method: http.MethodGet, Path: "/ping", Name: "ping",
+				Handler: func(http.ResponseWriter, *http.Request, map[string]string) {}}},
+		}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &errorGreeterService{})
+
+	in := s.Introspect()
+	should.Equal("greeter", in.Name)
+	should.Equal([]RouteInfo{
+		{Name: "introspection", Method: http.MethodGet, Path: "/debug/routes", Middlewares: 1},
+		{Name: "ping", Method: http.MethodGet, Path: "/api/ping"},
+	}, in.Routes)
+	should.Equal([]BindingInfo{
+		{Method: http.MethodGet, Path: "/v1/say/{name}", FullMethod: "/App.Grpc.Hello.GreeterService/SayHello"},
+	}, in.Bindings)
+	should.Equal([]GRPCServiceInfo{{Name: "App.Grpc.Hello.GreeterService", Methods: []GRPCMethodInfo{
+		{FullMethod: "/App.Grpc.Hello.GreeterService/SayHello"},
+	}}}, in.Services)
+	should.Equal([]string{
+		"github.com/daheige/gmicro/v2.(*Service).inflightUnaryInterceptor",
+		"github.com/daheige/gmicro/v2.(*Service).requestInfoUnaryInterceptor",
+		"github.com/daheige/gmicro/v2.(*Service).errorUnaryInterceptor",
+	}, in.UnaryInterceptors[:3])
+	should.Equal(&StaticMountInfo{Prefix: "/web/", Files: "fstest.MapFS", SPAFallback: true}, in.Static)
+
+	// the bindings are not listed without the named gateway handler
+	other := NewService(WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint))
+	pb.RegisterGreeterServiceServer(other.GRPCServer, &errorGreeterService{})
+	should.Empty(other.Introspect().Bindings)
+	should.Len(other.Introspect().Services, 1)
+
+	addr := startTestServer(t, s)
+
+	res, err := http.Get("http://" + addr + "/debug/routes?format=json")
+	should.NoError(err)
+	var body Introspection
+	should.NoError(json.NewDecoder(res.Body).Decode(&body))
+	res.Body.Close()
+	should.Equal("admin", res.Header.Get("X-Trace"))
+	should.Equal(in.Bindings, body.Bindings)
+	should.Equal(in.UnaryInterceptors, body.UnaryInterceptors)
+
+	res, err = http.Get("http://" + addr + "/debug/routes")
+	should.NoError(err)
+	b, err := io.ReadAll(res.Body)
+	should.NoError(err)
+	res.Body.Close()
+	should.Contains(res.Header.Get("Content-Type"), "text/html")
+	should.Contains(string(b), "<td>/v1/say/{name}</td>")
+	should.Contains(string(b), "github.com/daheige/gmicro/v2.(*Service).errorStreamInterceptor")
+}
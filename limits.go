@@ -0,0 +1,285 @@
+package gmicro
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// ReasonRequestTooLarge the reason of the error returned when the request body exceeds the limit.
+	ReasonRequestTooLarge = "REQUEST_TOO_LARGE"
+
+	// ReasonSlowRequest the reason of the error returned when the request body is sent
+	// slower than the minimum transfer rate.
+	ReasonSlowRequest = "SLOW_REQUEST"
+
+	// the transfer rate is not checked in the beginning of reading request body
+	minTransferRateGrace = time.Second
+
+	// the default max message sizes of grpc.Server
+	defaultMaxRecvMsgSize = 4 << 20
+	defaultMaxSendMsgSize = math.MaxInt32
+)
+
+// errSlowRequest the request body is sent slower than the minimum transfer rate.
+var errSlowRequest = errors.New("request body is sent too slowly")
+
+// BodyLimit limits the request body size of the http gateway requests matched by Method and Path.
+type BodyLimit struct {
+	Method   string // http method, empty matches all the methods
+	Path     string // request path, the path ending with "*" matches the prefix, eg: /v1/upload/*
+	MaxBytes int64  // the max body size in bytes, 0 means no limit
+}
+
+func (l BodyLimit) match(r *http.Request) bool {
+	if l.Method != "" && !strings.EqualFold(l.Method, r.Method) {
+		return false
+	}
+
+	if strings.HasSuffix(l.Path, "*") {
+		return strings.HasPrefix(r.URL.Path, strings.TrimSuffix(l.Path, "*"))
+	}
+
+	return l.Path == r.URL.Path
+}
+
+// bodyLimit returns the max body size of the request, the first matched BodyLimit
+// takes precedence over the max body size of the service.
+func (s *Service) bodyLimit(r *http.Request) int64 {
+	for _, l := range s.bodyLimits {
+		if l.match(r) {
+			return l.MaxBytes
+		}
+	}
+
+	return s.maxBodySize
+}
+
+// limitRequestBody rejects the request with 413 if its body exceeds the limit, and with 408
+// if its body is sent slower than the minimum transfer rate.
+// The body is streamed to h, the response of h is replaced with the rejection if the body
+// fails before the response is written, the client which stops sending at all is cut by
+// the ReadTimeout of http server.
+func (s *Service) limitRequestBody(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the unread body of the rejected request is dropped with the connection
+		reject := func(httpStatus int, reason string, e *Error) {
+			w.Header().Del("Content-Length")
+			w.Header().Set("Connection", "close")
+			s.rejectRequest(w, r, httpStatus, reason, e)
+		}
+
+		limit := s.bodyLimit(r)
+		if r.Body == nil || r.Body == http.NoBody || (limit <= 0 && s.minTransferRate <= 0) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if limit > 0 && r.ContentLength > limit {
+			reject(http.StatusRequestEntityTooLarge, "body_too_large",
+				ResourceExhausted(ReasonRequestTooLarge, "request body too large"))
+			return
+		}
+
+		body := &rateReader{ReadCloser: r.Body, rate: s.minTransferRate, start: time.Now()}
+		r.Body = body
+		if limit > 0 {
+			r.Body = http.MaxBytesReader(w, body, limit)
+		}
+
+		lw := &limitResponseWriter{ResponseWriter: w, reject: func() bool {
+			n, err := body.result()
+			switch {
+			case limit > 0 && n > limit:
+				reject(http.StatusRequestEntityTooLarge, "body_too_large",
+					ResourceExhausted(ReasonRequestTooLarge, "request body too large"))
+			case errors.Is(err, errSlowRequest):
+				reject(http.StatusRequestTimeout, "slow_request",
+					NewError(codes.DeadlineExceeded, ReasonSlowRequest, err.Error()))
+			default:
+				return false
+			}
+
+			return true
+		}}
+
+		h.ServeHTTP(lw, r)
+		if !lw.wroteHeader {
+			_ = lw.reject()
+		}
+	})
+}
+
+// limitResponseWriter replaces the response with the rejection when reject returns true
+// before the response header is written.
+type limitResponseWriter struct {
+	http.ResponseWriter
+	reject      func() bool
+	wroteHeader bool
+	rejected    bool
+}
+
+func (w *limitResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	if w.reject() {
+		w.rejected = true
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *limitResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.rejected {
+		return len(b), nil
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *limitResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.rejected {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped http.ResponseWriter.
+func (w *limitResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// rejectRequest renders the error by errorHandler with the http status and counts the rejection.
+func (s *Service) rejectRequest(w http.ResponseWriter, r *http.Request, httpStatus int, reason string, e *Error) {
+	rejectedRequests.WithLabelValues(reason, r.Method).Inc()
+
+	errorHandler := s.errorHandler
+	if errorHandler == nil {
+		errorHandler = gRuntime.DefaultHTTPErrorHandler
+	}
+
+	_, outbound := gRuntime.MarshalerForRequest(s.mux, r)
+	errorHandler(r.Context(), s.mux, outbound, w, r, &gRuntime.HTTPStatusError{HTTPStatus: httpStatus, Err: e})
+}
+
+// rateReader returns errSlowRequest if the average transfer rate is lower than rate
+// bytes per second after the grace period, it counts the bytes read from the body.
+type rateReader struct {
+	io.ReadCloser
+	rate  int64
+	start time.Time
+
+	mu  sync.Mutex
+	n   int64
+	err error
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	if _, err := r.result(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.n += int64(n)
+	if err != nil || r.rate <= 0 {
+		return n, err
+	}
+
+	elapsed := time.Since(r.start)
+	if elapsed > minTransferRateGrace && float64(r.n) < float64(r.rate)*elapsed.Seconds() {
+		r.err = errSlowRequest
+		return n, r.err
+	}
+
+	return n, nil
+}
+
+// result returns the number of bytes read and errSlowRequest if the body is sent too slowly.
+func (r *rateReader) result() (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.n, r.err
+}
+
+// messageSizeOptions applies the max message sizes to the gRPC server and the gateway client,
+// so the gateway can forward the messages accepted by the gRPC server.
+func (s *Service) messageSizeOptions() {
+	var callOptions []grpc.CallOption
+	if s.maxRecvMsgSize > 0 {
+		s.gRPCServerOptions = append(s.gRPCServerOptions, grpc.MaxRecvMsgSize(s.maxRecvMsgSize))
+		callOptions = append(callOptions, grpc.MaxCallSendMsgSize(s.maxRecvMsgSize))
+	}
+
+	if s.maxSendMsgSize > 0 {
+		s.gRPCServerOptions = append(s.gRPCServerOptions, grpc.MaxSendMsgSize(s.maxSendMsgSize))
+		callOptions = append(callOptions, grpc.MaxCallRecvMsgSize(s.maxSendMsgSize))
+	}
+
+	if s.maxHeaderBytes > 0 {
+		s.gRPCServerOptions = append(s.gRPCServerOptions, grpc.MaxHeaderListSize(uint32(s.maxHeaderBytes)))
+	}
+
+	if len(callOptions) > 0 {
+		s.gRPCDialOptions = append(s.gRPCDialOptions, grpc.WithDefaultCallOptions(callOptions...))
+	}
+}
+
+// limitsEnabled reports whether any request limit is specified.
+func (s *Service) limitsEnabled() bool {
+	return s.maxBodySize > 0 || len(s.bodyLimits) > 0 || s.minTransferRate > 0 ||
+		s.maxHeaderBytes > 0 || s.maxRecvMsgSize > 0 || s.maxSendMsgSize > 0
+}
+
+// exportLimits sets the effective request limits to the metrics labeled with the service name,
+// the defaults are exported for the limits which are not specified.
+func (s *Service) exportLimits() {
+	name := s.metricServiceName()
+	recvSize, sendSize := s.maxRecvMsgSize, s.maxSendMsgSize
+	if recvSize <= 0 {
+		recvSize = defaultMaxRecvMsgSize
+	}
+
+	if sendSize <= 0 {
+		sendSize = defaultMaxSendMsgSize
+	}
+
+	requestLimits.WithLabelValues(name, "grpc_max_recv_msg_size").Set(float64(recvSize))
+	requestLimits.WithLabelValues(name, "grpc_max_send_msg_size").Set(float64(sendSize))
+	if s.HTTPServer == nil {
+		return
+	}
+
+	headerBytes := s.HTTPServer.MaxHeaderBytes
+	if headerBytes <= 0 {
+		headerBytes = http.DefaultMaxHeaderBytes
+	}
+
+	requestLimits.WithLabelValues(name, "http_max_header_bytes").Set(float64(headerBytes))
+	requestLimits.WithLabelValues(name, "http_max_body_size").Set(float64(s.maxBodySize))
+	minTransferRate.WithLabelValues(name).Set(float64(s.minTransferRate))
+}
@@ -0,0 +1,170 @@
+package gmicro
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// chunkedReader hides the length of the body, so the http client sends it chunked.
+type chunkedReader struct {
+	io.Reader
+}
+
+func TestRequestLimits(t *testing.T) {
+	var should = require.New(t)
+	streamed := make(chan struct{}, 1)
+	echo := func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithRouteOpt(
+			Route{Method: http.MethodPost, Path: "/v1/echo", Handler: echo},
+			Route{Method: http.MethodPost, Path: "/v1/upload/file", Handler: echo},
+			Route{Method: http.MethodPost, Path: "/v1/upload/stream", Handler: func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+				_, _ = io.CopyN(io.Discard, r.Body, 1)
+				streamed <- struct{}{}
+				_, _ = io.Copy(w, r.Body)
+			}},
+		),
+		WithMaxBodySize(16),
+		WithBodyLimit(BodyLimit{Method: http.MethodPost, Path: "/v1/upload/*", MaxBytes: 64}),
+		WithMinTransferRate(1000),
+		WithMaxRecvMsgSize(64),
+		WithMaxHeaderBytes(4096),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &errorGreeterService{})
+	should.Equal(4096, s.HTTPServer.MaxHeaderBytes)
+	should.Equal(64.0, testutil.ToFloat64(requestLimits.WithLabelValues(s.metricServiceName(), "grpc_max_recv_msg_size")))
+
+	addr := startTestServer(t, s)
+
+	tooLarge := testutil.ToFloat64(rejectedRequests.WithLabelValues("body_too_large", http.MethodPost))
+	for _, c := range []struct {
+		path   string
+		body   io.Reader
+		status int
+	}{
+		{"/v1/echo", strings.NewReader("small body"), http.StatusOK},
+		{"/v1/echo", strings.NewReader(strings.Repeat("a", 17)), http.StatusRequestEntityTooLarge},
+		{"/v1/echo", chunkedReader{strings.NewReader(strings.Repeat("a", 17))}, http.StatusRequestEntityTooLarge},
+		{"/v1/upload/file", chunkedReader{strings.NewReader(strings.Repeat("a", 64))}, http.StatusOK},
+		{"/v1/upload/file", strings.NewReader(strings.Repeat("a", 65)), http.StatusRequestEntityTooLarge},
+	} {
+		res, err := http.Post("http://"+addr+c.path, "text/plain", c.body)
+		should.NoError(err)
+		b, err := io.ReadAll(res.Body)
+		should.NoError(err)
+		res.Body.Close()
+		should.Equal(c.status, res.StatusCode, string(b))
+		if c.status == http.StatusRequestEntityTooLarge {
+			should.Contains(string(b), ReasonRequestTooLarge)
+		}
+	}
+
+	should.Equal(tooLarge+3, testutil.ToFloat64(rejectedRequests.WithLabelValues("body_too_large", http.MethodPost)))
+
+	// the body is streamed to the handler, which reads the first byte before the rest is sent
+	streamConn, err := net.Dial("tcp", addr)
+	should.NoError(err)
+	defer streamConn.Close()
+
+	_, err = io.WriteString(streamConn, "POST /v1/upload/stream HTTP/1.1\r\nHost: 127.0.0.1\r\nContent-Length: 2\r\n\r\na")
+	should.NoError(err)
+	select {
+	case <-streamed:
+	case <-time.After(3 * time.Second):
+		should.FailNow("the body is not streamed to the handler")
+	}
+
+	_, err = io.WriteString(streamConn, "b")
+	should.NoError(err)
+	res, err := http.ReadResponse(bufio.NewReader(streamConn), nil)
+	should.NoError(err)
+	b, err := io.ReadAll(res.Body)
+	should.NoError(err)
+	res.Body.Close()
+	should.Equal(http.StatusOK, res.StatusCode)
+	should.Equal("b", string(b))
+	streamConn.Close()
+
+	// the client sends the body slower than the minimum transfer rate
+	conn, err := net.Dial("tcp", addr)
+	should.NoError(err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "POST /v1/echo HTTP/1.1\r\nHost: 127.0.0.1\r\nContent-Length: 8\r\n\r\n")
+	should.NoError(err)
+	go func() {
+		for i := 0; i < 8; i++ {
+			time.Sleep(300 * time.Millisecond)
+			if _, err := conn.Write([]byte("a")); err != nil {
+				return
+			}
+		}
+	}()
+
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	should.NoError(err)
+	res.Body.Close()
+	should.Equal(http.StatusRequestTimeout, res.StatusCode)
+	should.True(res.Close)
+
+	// the gRPC server rejects the message larger than the max recv size
+	grpcConn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer grpcConn.Close()
+
+	_, err = pb.NewGreeterServiceClient(grpcConn).SayHello(context.Background(),
+		&pb.HelloReq{Name: strings.Repeat("a", 100)})
+	should.Equal(codes.ResourceExhausted, status.Code(err))
+}
+
+func TestLimitMetrics(t *testing.T) {
+	var should = require.New(t)
+
+	// the limit metrics are not registered without the limits
+	prometheus.Unregister(requestLimits)
+	NewService()
+	should.NoError(prometheus.Register(requestLimits))
+
+	// the limits of the services are exported by their names
+	NewService(WithServiceName("a"), WithMaxRecvMsgSize(10))
+	NewService(WithServiceName("b"), WithMaxRecvMsgSize(20))
+	should.Equal(10.0, testutil.ToFloat64(requestLimits.WithLabelValues("a", "grpc_max_recv_msg_size")))
+	should.Equal(20.0, testutil.ToFloat64(requestLimits.WithLabelValues("b", "grpc_max_recv_msg_size")))
+}
+
+func TestBodyLimitMatch(t *testing.T) {
+	r, err := http.NewRequest(http.MethodPut, "http://127.0.0.1/v1/upload/a.png", nil)
+	require.NoError(t, err)
+
+	assert.True(t, BodyLimit{Path: "/v1/upload/*"}.match(r))
+	assert.True(t, BodyLimit{Method: "put", Path: "/v1/upload/a.png"}.match(r))
+	assert.False(t, BodyLimit{Method: http.MethodPost, Path: "/v1/upload/*"}.match(r))
+	assert.False(t, BodyLimit{Path: "/v1/upload"}.match(r))
+}
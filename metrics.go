@@ -0,0 +1,77 @@
+package gmicro
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// rejectedRequests counts the http gateway requests rejected by the request limits.
+	rejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmicro",
+		Subsystem: "http",
+		Name:      "rejected_requests_total",
+		Help:      "Total number of the http requests rejected by the request limits.",
+	}, []string{"reason", "method"})
+
+	// requestLimits the effective size limits of the http and gRPC requests of the services.
+	requestLimits = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gmicro",
+		Name:      "request_limit_bytes",
+		Help:      "The size limits of the http and gRPC requests in bytes, 0 means no limit.",
+	}, []string{"service", "limit"})
+
+	// minTransferRate the minimum transfer rate of the http request body of the services.
+	minTransferRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gmicro",
+		Subsystem: "http",
+		Name:      "min_transfer_rate_bytes_per_second",
+		Help:      "The minimum transfer rate of the http request body, 0 means no limit.",
+	}, []string{"service"})
+
+	// tenantRequests counts the requests of the tenants by the gRPC code,
+	// the http status of the custom routes is mapped to the gRPC code.
+	// The tenants not configured are counted as other.
+	tenantRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gmicro",
+		Name:      "tenant_requests_total",
+		Help:      "Total number of the requests of the tenants.",
+	}, []string{"tenant", "code"})
+)
+
+// registerMetrics registers the metrics of the enabled features to the prometheus default registry,
+// and exports the request limits of the service.
+func (s *Service) registerMetrics() {
+	if s.tenancy != nil {
+		registerCollectors(rejectedRequests, tenantRequests)
+	}
+
+	if s.limitsEnabled() {
+		registerCollectors(rejectedRequests, requestLimits, minTransferRate)
+		s.exportLimits()
+	}
+}
+
+// metricServiceName returns the service label of the metrics, the services in one process
+// are told apart by WithServiceName, the name of the program is used by default.
+func (s *Service) metricServiceName() string {
+	if s.serviceName != "" {
+		return s.serviceName
+	}
+
+	return filepath.Base(os.Args[0])
+}
+
+// registerCollectors registers the collectors, the ones registered already are skipped.
+func registerCollectors(collectors ...prometheus.Collector) {
+	for _, c := range collectors {
+		err := prometheus.Register(c)
+		var registered prometheus.AlreadyRegisteredError
+		if err != nil && !errors.As(err, &registered) {
+			panic(err)
+		}
+	}
+}
@@ -3,14 +3,16 @@ package gmicro
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	gRecovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
@@ -21,11 +23,9 @@ import (
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
-	"google.golang.org/grpc/status"
 )
 
 const (
@@ -34,6 +34,12 @@ const (
 
 	// the default time waiting for running goroutines to finish their jobs before the shutdown start.
 	defaultPreShutdownDelay = 2 * time.Second
+
+	// the default timeout for running all the start hooks
+	defaultStartTimeout = 10 * time.Second
+
+	// the interval of checking in-flight RPCs when the gRPC server shares the http port
+	inflightCheckInterval = 50 * time.Millisecond
 )
 
 // refer: https://github.com/golang/protobuf/blob/v1.4.3/jsonpb/encode.go#L30
@@ -66,6 +72,10 @@ type Service struct {
 	preShutdownDelay     time.Duration
 	interruptSignals     []os.Signal // interrupt signal
 	annotators           []AnnotatorFunc
+	propagator           *Propagator
+	tenancy              *tenancy
+	idempotency          *Idempotency
+	responseCache        *responseCache
 	staticDir            string                         // static dir
 	enableStaticAccess   bool                           // enable static file access
 	errorHandler         gRuntime.ErrorHandlerFunc      // gRPC error handler
@@ -79,7 +89,45 @@ type Service struct {
 	gRPCDialOptions      []grpc.DialOption
 	logger               Logger                // logger interface entry
 	handlerFromEndpoints []HandlerFromEndpoint // http gw endpoint
+	gatewayServices      map[string]bool       // the gRPC services served by the named gateway handlers
 	enablePrometheus     bool                  // enable prometheus monitor
+	inflightRPCs         int64                 // the number of RPCs being handled
+	inflightHTTP         int64                 // the number of http requests being handled, including the hijacked ones
+	cutOffRPCs           int64                 // the number of RPCs cut off by the last shutdown
+	startHooks           []StartHook           // hooks run before the listeners open
+	startTimeout         time.Duration         // timeout for running all the start hooks
+	startedFunc          func()                // called when the listeners are accepting
+	started              chan struct{}         // closed when the listeners are accepting
+	startedOnce          sync.Once             // notify started only once
+	reloadSignals        []os.Signal           // reload signal
+	configSource         ConfigSource          // the config source to reload from
+	reloadFuncs          []ReloadFunc          // notified when the service reloads
+	reloadable           reloadableSettings    // the settings which can be reloaded
+	registry             Registry              // service discovery backend
+	serviceName          string                // the service name registered to registry
+	serviceMetadata      map[string]string     // the metadata registered to registry
+	registerTTL          time.Duration         // the ttl of the registered instance
+	advertiseHost        string                // the host registered to registry
+	instance             *ServiceInstance      // the registered service instance
+	stopHeartbeat        func()                // stop the heartbeat of registered instance
+	cors                 *cors                 // handles the CORS requests of http gateway
+	enableGRPCWeb        bool                  // translate the gRPC-Web requests to gRPC server
+	enableConnect        bool                  // translate the Connect requests to gRPC server
+	streamRoutes         []StreamRoute         // the streaming methods served as SSE or WebSocket
+	streamHeartbeat      time.Duration         // the heartbeat interval of SSE and WebSocket
+	maxBodySize          int64                 // the max request body size of http gateway
+	bodyLimits           []BodyLimit           // the max request body size of the matched routes
+	maxHeaderBytes       int                   // the max request header size of http and gRPC
+	maxRecvMsgSize       int                   // the max message size the gRPC server can receive
+	maxSendMsgSize       int                   // the max message size the gRPC server can send
+	minTransferRate      int64                 // the minimum transfer rate of request body in bytes/s
+	compression          *compression          // compresses the responses of http gateway
+	grpcCompressor       string                // the default compressor of gRPC responses
+	static               *staticHandler        // serves the static files of http gateway
+	openAPIConfig        *OpenAPI              // the OpenAPI documents served by http gateway
+	openAPI              *openAPI              // the merged OpenAPI document loaded when the server starts
+	trustedProxies       []*net.IPNet          // the proxies whose X-Forwarded-* headers are trusted
+	gatewayToken         string                // proves the metadata is forwarded by the gateway of the Service
 }
 
 // DefaultHTTPHandler is the default http handler which does nothing.
@@ -100,13 +148,18 @@ func DefaultHTTPHandler(mux *gRuntime.ServeMux) http.Handler {
 // The main internal logic is to intercept all h2c traffic, then hijack and redirect it
 // to the corresponding handler according to different request traffic types to process
 func GRPCHandlerFunc(grpcServer *grpc.Server, otherHandler http.Handler) http.Handler {
+	return grpcHandlerFunc(grpcServer, otherHandler, &http2.Server{})
+}
+
+func grpcHandlerFunc(grpcServer *grpc.Server, otherHandler http.Handler, h2s *http2.Server) http.Handler {
 	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") {
+		if r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") &&
+			!isGRPCWebRequest(r) {
 			grpcServer.ServeHTTP(w, r)
 		} else {
 			otherHandler.ServeHTTP(w, r)
 		}
-	}), &http2.Server{})
+	}), h2s)
 }
 
 func defaultService() *Service {
@@ -116,6 +169,11 @@ func defaultService() *Service {
 	s.shutdownFunc = func() {}
 	s.shutdownTimeout = defaultShutdownTimeout
 	s.preShutdownDelay = defaultPreShutdownDelay
+	s.startTimeout = defaultStartTimeout
+	s.registerTTL = defaultRegisterTTL
+	s.streamHeartbeat = defaultStreamHeartbeat
+	s.started = make(chan struct{})
+	s.gatewayToken = Uuid()
 	s.logger = dummyLogger
 
 	// goroutine recover catch stack
@@ -131,6 +189,9 @@ func defaultService() *Service {
 	// default interrupt signals to catch, you can use InterruptSignal option to append more
 	s.interruptSignals = InterruptSignals
 
+	// default reload signals to catch, you can use WithReloadSignal option to append more
+	s.reloadSignals = ReloadSignals
+
 	// register interceptor
 	s.streamInterceptors = make([]grpc.StreamServerInterceptor, 0, 20)
 	s.unaryInterceptors = make([]grpc.UnaryServerInterceptor, 0, 20)
@@ -156,6 +217,9 @@ func NewService(opts ...Option) *Service {
 	// app option functions.
 	s.apply(opts)
 
+	// install the interceptor of the settings which can be changed by reloading config
+	s.installReloadable()
+
 	// install request interceptor
 	if s.enableRequestAccess {
 		s.unaryInterceptors = append(s.unaryInterceptors, s.RequestInterceptor)
@@ -187,19 +251,65 @@ func NewService(opts ...Option) *Service {
 		s.routes = append(s.routes, routeMetrics)
 	}
 
+	// static file access, the files are served in front of the gateway routes
+	if s.static == nil && s.enableStaticAccess {
+		if s.staticDir == "" {
+			s.logger.Printf("static file access is disabled, because the static dir is not set\n")
+		} else {
+			s.static = newStaticHandler(Static{Root: s.staticDir})
+		}
+	}
+
+	// the gRPC-Web clients send and read the gRPC headers
+	if s.enableGRPCWeb && s.cors != nil {
+		s.cors.allowHeaders(grpcWebRequestHeaders, grpcWebExposedHeaders)
+	}
+
+	if s.enableConnect && s.cors != nil {
+		s.cors.allowHeaders(connectRequestHeaders, nil)
+	}
+
+	if s.idempotency != nil && s.cors != nil {
+		s.cors.allowHeaders([]string{IdempotencyKeyHeader},
+			[]string{gRuntime.MetadataHeaderPrefix + IdempotentReplayedHeader})
+	}
+
 	// init gateway mux
 	s.muxOptions = append(s.muxOptions, gRuntime.WithErrorHandler(s.errorHandler))
 
+	// the gateway returns the ETag and Cache-Control of the cached replies
+	if s.responseCache != nil {
+		s.muxOptions = append(s.muxOptions, gRuntime.WithForwardResponseOption(cacheForwardResponse))
+	}
+
+	// the gateway maps the propagated headers to the metadata
+	if s.propagator != nil {
+		s.annotators = append(s.annotators, s.propagator.Annotator)
+	}
+
+	// the gateway forwards the tenant resolved from the http request
+	if s.tenancy != nil {
+		s.annotators = append(s.annotators, s.tenancy.annotator)
+	}
+
+	if s.idempotency != nil {
+		s.annotators = append(s.annotators, s.idempotency.annotator)
+	}
+
 	// init annotators
 	for _, annotator := range s.annotators {
 		s.muxOptions = append(s.muxOptions, gRuntime.WithMetadata(annotator))
 	}
 
-	s.mux = gRuntime.NewServeMux(s.muxOptions...)
+	// the routing errors are rendered by errorHandler, it can be replaced by using MuxOption,
+	// and the gateway forwards the request id and the client ip of the http request
+	s.mux = gRuntime.NewServeMux(append([]gRuntime.ServeMuxOption{
+		gRuntime.WithRoutingErrorHandler(s.routingErrorHandler),
+		gRuntime.WithMetadata(s.requestInfoAnnotator),
+	}, s.muxOptions...)...)
 
-	s.gRPCServerOptions = append(s.gRPCServerOptions,
-		grpc.ChainStreamInterceptor(s.streamInterceptors...),
-		grpc.ChainUnaryInterceptor(s.unaryInterceptors...))
+	s.messageSizeOptions()
+	s.gRPCServerOptions = append(s.gRPCServerOptions, s.interceptorOptions()...)
 
 	s.GRPCServer = grpc.NewServer(
 		s.gRPCServerOptions...,
@@ -216,6 +326,12 @@ func NewService(opts ...Option) *Service {
 		}
 	}
 
+	if s.maxHeaderBytes > 0 {
+		s.HTTPServer.MaxHeaderBytes = s.maxHeaderBytes
+	}
+
+	s.registerMetrics()
+
 	return s
 }
 
@@ -232,8 +348,9 @@ func (s *Service) RequestInterceptor(ctx context.Context, req interface{}, info
 
 	defer func() {
 		if r := recover(); r != nil {
-			// the error format defined by grpc must be used here to return code, desc
-			err = status.Errorf(codes.Internal, "%s", "server inner error")
+			// the panic is returned as the internal error with the request id
+			err = Internal(ReasonInternal, internalErrorMessage).
+				WithMetadata(map[string]string{"request_id": requestID})
 			s.logger.Printf("x-request-id:%s exec panic:%v req:%v reply:%v\n", requestID, r, req, reply)
 			s.logger.Printf("x-request-id:%s full stack:%s\n", requestID, string(debug.Stack()))
 		}
@@ -266,6 +383,79 @@ func (s *Service) RequestInterceptor(ctx context.Context, req interface{}, info
 	return reply, err
 }
 
+// interceptorOptions returns the gRPC server options which chain the interceptors,
+// the in-flight counter is always the outermost one, and then the RequestInfo and the error mapping.
+func (s *Service) interceptorOptions() []grpc.ServerOption {
+	unaryInterceptors, streamInterceptors := s.chainedInterceptors()
+	return []grpc.ServerOption{
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+	}
+}
+
+// chainedInterceptors returns the unary and stream interceptors in the chained order,
+// the RequestInfo is set before the error mapping so that the errors are logged with its request id.
+func (s *Service) chainedInterceptors() ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		s.inflightUnaryInterceptor, s.requestInfoUnaryInterceptor, s.errorUnaryInterceptor,
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		s.inflightStreamInterceptor, s.requestInfoStreamInterceptor, s.errorStreamInterceptor,
+	}
+	if s.tenancy != nil {
+		unaryInterceptors = append(unaryInterceptors, s.tenantUnaryInterceptor)
+		streamInterceptors = append(streamInterceptors, s.tenantStreamInterceptor)
+	}
+
+	if s.grpcCompressor != "" {
+		unaryInterceptors = append(unaryInterceptors, s.compressorUnaryInterceptor)
+		streamInterceptors = append(streamInterceptors, s.compressorStreamInterceptor)
+	}
+
+	unaryInterceptors = append(unaryInterceptors, s.unaryInterceptors...)
+
+	// the idempotency and the cache are the innermost ones, so the user interceptors
+	// such as the authentication are applied to the replayed and the cached replies
+	if s.idempotency != nil {
+		unaryInterceptors = append(unaryInterceptors, s.idempotencyUnaryInterceptor)
+	}
+
+	if s.responseCache != nil {
+		unaryInterceptors = append(unaryInterceptors, s.cacheUnaryInterceptor)
+	}
+
+	return unaryInterceptors, append(streamInterceptors, s.streamInterceptors...)
+}
+
+// inflightUnaryInterceptor counts the unary RPCs being handled.
+func (s *Service) inflightUnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	atomic.AddInt64(&s.inflightRPCs, 1)
+	defer atomic.AddInt64(&s.inflightRPCs, -1)
+
+	return handler(ctx, req)
+}
+
+// inflightStreamInterceptor counts the streaming RPCs being handled.
+func (s *Service) inflightStreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	atomic.AddInt64(&s.inflightRPCs, 1)
+	defer atomic.AddInt64(&s.inflightRPCs, -1)
+
+	return handler(srv, ss)
+}
+
+// InflightRPCs returns the number of RPCs being handled by the gRPC server.
+func (s *Service) InflightRPCs() int64 {
+	return atomic.LoadInt64(&s.inflightRPCs)
+}
+
+// CutOffRPCs returns the number of in-flight RPCs which were cut off by the last shutdown,
+// because they were not finished before the shutdown timeout.
+func (s *Service) CutOffRPCs() int64 {
+	return atomic.LoadInt64(&s.cutOffRPCs)
+}
+
 // GetPid gets the process id of server
 func (s *Service) GetPid() int {
 	return os.Getpid()
@@ -276,6 +466,17 @@ func (s *Service) AddHandlerFromEndpoint(h ...HandlerFromEndpoint) {
 	s.handlerFromEndpoints = append(s.handlerFromEndpoints, h...)
 }
 
+// AddServiceHandlerFromEndpoint adds the HandlerFromEndpoint of the gRPC service, the service is
+// its full name, eg: App.Grpc.Hello.GreeterService, Introspect lists the http bindings of it.
+func (s *Service) AddServiceHandlerFromEndpoint(service string, h HandlerFromEndpoint) {
+	if s.gatewayServices == nil {
+		s.gatewayServices = make(map[string]bool)
+	}
+
+	s.gatewayServices[service] = true
+	s.handlerFromEndpoints = append(s.handlerFromEndpoints, h)
+}
+
 // AddRoute add some route to routes
 func (s *Service) AddRoute(routes ...Route) {
 	s.routes = append(s.routes, routes...)
@@ -288,6 +489,23 @@ func (s *Service) Start(httpPort, grpcPort int) error {
 	s.httpServerAddress = fmt.Sprintf("0.0.0.0:%d", httpPort)
 	s.gRPCAddress = fmt.Sprintf("0.0.0.0:%d", grpcPort)
 
+	// run start hooks before the listeners open
+	err := s.runStartHooks()
+	if err != nil {
+		return err
+	}
+
+	grpcLis, err := s.listenGRPCServer()
+	if err != nil {
+		return err
+	}
+
+	httpLis, err := s.listenGRPCGateway()
+	if err != nil {
+		_ = grpcLis.Close()
+		return err
+	}
+
 	// intercept interrupt signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, s.interruptSignals...)
@@ -301,7 +519,7 @@ func (s *Service) Start(httpPort, grpcPort int) error {
 		defer s.recovery()
 
 		s.logger.Printf("Starting gPRC server listening on %d\n", grpcPort)
-		errChan1 <- s.startGRPCServer()
+		errChan1 <- s.GRPCServer.Serve(grpcLis)
 	}()
 
 	// start HTTP/1.0 gateway server
@@ -309,29 +527,54 @@ func (s *Service) Start(httpPort, grpcPort int) error {
 		defer s.recovery()
 
 		s.logger.Printf("Starting http server listening on %d\n", httpPort)
-		errChan2 <- s.startGRPCGateway()
+		errChan2 <- s.serveHTTP(httpLis)
 	}()
 
-	// wait for context cancellation or shutdown signal
-	select {
-	// if gRPC server fail to start
-	case err := <-errChan1:
-		return err
+	// intercept reload signals
+	reloadChan := make(chan os.Signal, 1)
+	if len(s.reloadSignals) > 0 {
+		signal.Notify(reloadChan, s.reloadSignals...)
+		defer signal.Stop(reloadChan)
+	}
 
-	// if http server fail to start
-	case err := <-errChan2:
+	// announce the service instance after the listeners start
+	err = s.registerInstance()
+	if err != nil {
+		s.GRPCServer.Stop()
+		_ = s.HTTPServer.Close()
 		return err
+	}
 
-	// if we received an interrupt signal
-	case sig := <-sigChan:
-		s.logger.Printf("Interrupt signal received: %v\n", sig)
-		s.Stop()
-		return nil
+	// both listeners are accepting connections now
+	s.notifyStarted()
+
+	// wait for context cancellation, shutdown signal or reload signal
+	for {
+		select {
+		// if gRPC server fail to start
+		case err := <-errChan1:
+			return err
+
+		// if http server fail to start
+		case err := <-errChan2:
+			return err
+
+		// if we received a reload signal
+		case sig := <-reloadChan:
+			s.logger.Printf("Reload signal received: %v\n", sig)
+			_ = s.Reload()
+
+		// if we received an interrupt signal
+		case sig := <-sigChan:
+			s.logger.Printf("Interrupt signal received: %v\n", sig)
+			s.Stop()
+			return nil
+		}
 	}
 }
 
-// startGRPCServer start grpc server.
-func (s *Service) startGRPCServer() error {
+// listenGRPCServer registers the reflection service and announces on the gRPC address.
+func (s *Service) listenGRPCServer() (net.Listener, error) {
 	// register reflection service on gRPC server.
 	reflection.Register(s.GRPCServer)
 
@@ -339,54 +582,132 @@ func (s *Service) startGRPCServer() error {
 		s.gRPCNetwork = "tcp"
 	}
 
-	lis, err := net.Listen(s.gRPCNetwork, s.gRPCAddress)
+	return net.Listen(s.gRPCNetwork, s.gRPCAddress)
+}
+
+// listenGRPCGateway registers the gateway handlers and routes, then announces on the http address.
+func (s *Service) listenGRPCGateway() (net.Listener, error) {
+	err := s.registerHandlerFromEndpoints()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// the OpenAPI documents are loaded before its routes are added
+	err = s.appOpenAPI()
+	if err != nil {
+		return nil, err
+	}
+
+	// apply routes
+	err = s.appRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.appStreamRoutes()
+	if err != nil {
+		return nil, err
 	}
 
-	return s.GRPCServer.Serve(lis)
+	// http server
+	s.HTTPServer.Addr = s.httpServerAddress
+	s.HTTPServer.Handler = s.httpMiddleware(s.httpHandler(s.mux))
+	s.HTTPServer.RegisterOnShutdown(s.shutdownFunc)
+
+	return net.Listen("tcp", s.HTTPServer.Addr)
 }
 
-func (s *Service) startGRPCGateway() error {
-	// Register http gw handlerFromEndpoint
+// registerHandlerFromEndpoints registers http gw handlerFromEndpoint
+func (s *Service) registerHandlerFromEndpoints() error {
 	ctx := context.Background()
-	var err error
 	for _, h := range s.handlerFromEndpoints {
-		err = h(ctx, s.mux, s.gRPCAddress, s.gRPCDialOptions)
+		err := h(ctx, s.mux, s.gRPCAddress, s.gRPCDialOptions)
 		if err != nil {
 			s.logger.Printf("register handler from endPoint error: %s\n", err.Error())
 			return err
 		}
 	}
 
-	// static file access
-	if s.enableStaticAccess {
-		// this is the fallback handler that will serve static files,
-		// if file does not exist, then a 404 error will be returned.
-		s.mux.Handle("GET", AllPattern(), s.ServeFile)
+	return nil
+}
+
+// httpMiddleware wraps the http gateway handler with the built-in http middlewares.
+func (s *Service) httpMiddleware(h http.Handler) http.Handler {
+	// the static files are served without tenant
+	if s.tenancy != nil {
+		h = s.tenantHandler(h)
 	}
 
-	// apply routes
-	err = s.appRoutes()
-	if err != nil {
-		return err
+	if s.responseCache != nil {
+		h = cacheHandler(h)
 	}
 
-	// http server
-	s.HTTPServer.Addr = s.httpServerAddress
-	s.HTTPServer.Handler = s.httpHandler(s.mux)
-	s.HTTPServer.RegisterOnShutdown(s.shutdownFunc)
+	if s.static != nil {
+		h = s.static.handler(h)
+	}
+
+	if s.enableConnect {
+		h = ConnectHandlerFunc(s.GRPCServer, h)
+	}
+
+	if s.enableGRPCWeb {
+		h = GRPCWebHandlerFunc(s.GRPCServer, h)
+	}
+
+	if s.maxBodySize > 0 || len(s.bodyLimits) > 0 || s.minTransferRate > 0 {
+		h = s.limitRequestBody(h)
+	}
+
+	if s.compression != nil {
+		h = s.compression.handler(h)
+	}
+
+	if s.cors != nil {
+		h = s.cors.handler(h)
+	}
+
+	return s.inflightHTTPHandler(s.requestInfoHandler(h))
+}
+
+// inflightHTTPHandler counts the http requests being handled, the hijacked WebSocket requests
+// are counted until their handlers return, though the http server does not track them.
+func (s *Service) inflightHTTPHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.inflightHTTP, 1)
+		defer atomic.AddInt64(&s.inflightHTTP, -1)
+
+		h.ServeHTTP(w, r)
+	})
+}
 
-	return s.HTTPServer.ListenAndServe()
+// serveHTTP serves the http server on lis, the error caused by shutdown is ignored
+// just like grpc.Server.Serve does.
+func (s *Service) serveHTTP(lis net.Listener) error {
+	err := s.HTTPServer.Serve(lis)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
 }
 
 func (s *Service) appRoutes() error {
+	names := make(map[string]bool, len(s.routes))
 	for _, route := range s.routes {
 		if !strings.HasPrefix(route.Path, "/") {
 			route.Path = "/" + route.Path
 		}
 
-		err := s.mux.HandlePath(route.Method, route.Path, route.Handler)
+		if route.Name != "" {
+			if names[route.Name] {
+				s.logger.Printf("add router error: the route name %s is duplicate\n", route.Name)
+				return fmt.Errorf("%w: %s", ErrDuplicateRouteName, route.Name)
+			}
+
+			names[route.Name] = true
+		}
+
+		err := s.mux.HandlePath(route.Method, route.Path, chainMiddlewares(route.Handler, route.Middlewares))
 		if err != nil {
 			s.logger.Printf("add router error:%s,current method:%s path:%s invalid", err.Error(),
 				route.Method, route.Path)
@@ -399,6 +720,10 @@ func (s *Service) appRoutes() error {
 
 // Stop stops the microservice gracefully.
 func (s *Service) Stop() {
+	// deregister the service instance first, so that the clients can stop sending
+	// new requests during preShutdownDelay
+	s.deregisterInstance()
+
 	// disable keep-alives on existing connections
 	s.HTTPServer.SetKeepAlivesEnabled(false)
 
@@ -408,22 +733,97 @@ func (s *Service) Stop() {
 		time.Sleep(s.preShutdownDelay)
 	}
 
+	// the gRPC and http servers share one shutdown deadline
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	// the gRPC-Web, Connect and stream route RPCs are served by the http server, and GracefulStop
+	// can not drain their transports, so the http server is shut down before the gRPC server
+	if s.servesGRPCOverHTTP() {
+		s.httpServerShutdown(ctx)
+		s.stopGRPCServer(ctx, false)
+		return
+	}
+
 	// gracefully stop gRPC server first
-	s.GRPCServer.GracefulStop()
+	s.stopGRPCServer(ctx, false)
 
 	// gracefully stop http server
-	s.httpServerShutdown()
+	s.httpServerShutdown(ctx)
 }
 
-// httpServerShutdown http gateway server graceful shutdown.
-func (s *Service) httpServerShutdown() {
+// stopGRPCServer stops the gRPC server gracefully until ctx is done.
+// If the pending RPCs are not finished before the deadline, the server will be
+// stopped abruptly, and the number of in-flight RPCs cut off will be returned,
+// it is also returned by CutOffRPCs.
+// When the gRPC server is served on the shared port, GracefulStop is not supported by
+// the transports of the http server, the GOAWAY frames are sent by the http2 server on shutdown,
+// so we only wait for the pending RPCs to finish. When the http server serves the gRPC-Web,
+// Connect or stream route RPCs, they must be finished before GracefulStop sends GOAWAY
+// to the connections of the gRPC listener.
+func (s *Service) stopGRPCServer(ctx context.Context, sharePort bool) int64 {
+	start := time.Now()
 	done := make(chan struct{}, 1)
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		s.shutdownTimeout,
-	)
+	go func() {
+		defer s.recovery()
+		defer close(done)
 
-	defer cancel()
+		if sharePort {
+			waitInflight(ctx, &s.inflightRPCs)
+			return
+		}
+
+		if s.servesGRPCOverHTTP() && !waitInflight(ctx, &s.inflightHTTP) {
+			return
+		}
+
+		// GracefulStop sends GOAWAY to all the connections and waits for the pending RPCs.
+		s.GRPCServer.GracefulStop()
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+
+	cutOff := s.InflightRPCs()
+	s.GRPCServer.Stop()
+	atomic.StoreInt64(&s.cutOffRPCs, cutOff)
+	if cutOff > 0 {
+		s.logger.Printf("Grpc server forced stop after %v, %d in-flight RPCs were cut off",
+			time.Since(start), cutOff)
+		return cutOff
+	}
+
+	s.logger.Printf("Grpc server shutdown success in %v", time.Since(start))
+	return 0
+}
+
+// servesGRPCOverHTTP reports whether the gRPC server serves the requests received by the http server.
+func (s *Service) servesGRPCOverHTTP() bool {
+	return s.enableGRPCWeb || s.enableConnect || len(s.streamRoutes) > 0
+}
+
+// waitInflight waits for the in-flight counter to drop to 0 until ctx is done,
+// it reports whether the counter dropped to 0.
+func waitInflight(ctx context.Context, counter *int64) bool {
+	ticker := time.NewTicker(inflightCheckInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(counter) > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+
+	return true
+}
+
+// httpServerShutdown http gateway server graceful shutdown until ctx is done.
+func (s *Service) httpServerShutdown(ctx context.Context) {
+	done := make(chan struct{}, 1)
 
 	// gracefully stop http server
 	// Doesn't block if no connections, but will otherwise wait
@@ -462,6 +862,17 @@ func (s *Service) StartGRPCAndHTTPServer(port int) error {
 	s.httpServerAddress = fmt.Sprintf("0.0.0.0:%d", port)
 	s.gRPCAddress = s.httpServerAddress
 
+	// run start hooks before the listener opens
+	err := s.runStartHooks()
+	if err != nil {
+		return err
+	}
+
+	lis, err := s.listenGRPCAndHTTPServer()
+	if err != nil {
+		return err
+	}
+
 	// intercept interrupt signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, s.interruptSignals...)
@@ -474,32 +885,82 @@ func (s *Service) StartGRPCAndHTTPServer(port int) error {
 		defer s.recovery()
 
 		s.logger.Printf("Starting http server and grpc server listening on %d\n", port)
-		errChan <- s.startGRPCAndHTTPServer()
+		errChan <- s.serveHTTP(lis)
 	}()
 
-	// wait for context cancellation or shutdown signal
-	select {
-	// if http server and gRPC server fail to start
-	case err := <-errChan:
+	// intercept reload signals
+	reloadChan := make(chan os.Signal, 1)
+	if len(s.reloadSignals) > 0 {
+		signal.Notify(reloadChan, s.reloadSignals...)
+		defer signal.Stop(reloadChan)
+	}
+
+	// announce the service instance after the listener starts
+	err = s.registerInstance()
+	if err != nil {
+		_ = s.HTTPServer.Close()
 		return err
-	// if we received an interrupt signal
-	case sig := <-sigChan:
-		s.logger.Printf("Interrupt signal received: %v\n", sig)
-		s.stopGRPCAndHTTPServer()
-		return nil
 	}
-}
 
-func (s *Service) startGRPCAndHTTPServer() error {
-	ctx := context.Background()
-	var err error
-	for _, h := range s.handlerFromEndpoints {
-		err = h(ctx, s.mux, s.gRPCAddress, s.gRPCDialOptions)
-		if err != nil {
-			s.logger.Printf("register handler from endPoint error: %s\n", err.Error())
+	// the shared listener is accepting connections now
+	s.notifyStarted()
+
+	// wait for context cancellation, shutdown signal or reload signal
+	for {
+		select {
+		// if http server and gRPC server fail to start
+		case err := <-errChan:
 			return err
+		// if we received a reload signal
+		case sig := <-reloadChan:
+			s.logger.Printf("Reload signal received: %v\n", sig)
+			_ = s.Reload()
+
+		// if we received an interrupt signal
+		case sig := <-sigChan:
+			s.logger.Printf("Interrupt signal received: %v\n", sig)
+			s.stopGRPCAndHTTPServer()
+			return nil
 		}
 	}
+}
+
+// listenGRPCAndHTTPServer announces on the address shared by the gRPC server and http server,
+// then registers the gateway handlers and routes.
+func (s *Service) listenGRPCAndHTTPServer() (net.Listener, error) {
+	// the gateway dials the gRPC server on the shared address when it registers,
+	// so the listener must be opened first.
+	lis, err := net.Listen("tcp", s.httpServerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	// the port 0 listens on a random port, the gateway dials the chosen one
+	s.httpServerAddress = lis.Addr().String()
+	s.gRPCAddress = s.httpServerAddress
+
+	err = s.registerGRPCAndHTTPHandler()
+	if err != nil {
+		_ = lis.Close()
+		return nil, err
+	}
+
+	return lis, nil
+}
+
+// registerGRPCAndHTTPHandler registers the gateway handlers and routes,
+// then sets the h2c handler of the http server.
+func (s *Service) registerGRPCAndHTTPHandler() error {
+	err := s.registerHandlerFromEndpoints()
+	if err != nil {
+		return err
+	}
+
+	// the OpenAPI documents are loaded before its routes are added
+	err = s.appOpenAPI()
+	if err != nil {
+		return err
+	}
 
 	// apply routes
 	err = s.appRoutes()
@@ -507,20 +968,36 @@ func (s *Service) startGRPCAndHTTPServer() error {
 		return err
 	}
 
+	err = s.appStreamRoutes()
+	if err != nil {
+		return err
+	}
+
 	// http server and h2c handler
 	// create a http mux
 	httpMux := http.NewServeMux()
 	httpMux.Handle("/", s.mux)
 
+	// the http2 server sends GOAWAY to the h2c connections when the http server shutdown.
+	h2s := &http2.Server{}
+	err = http2.ConfigureServer(s.HTTPServer, h2s)
+	if err != nil {
+		return err
+	}
+
 	s.HTTPServer.Addr = s.httpServerAddress
 	// gRPC server handler convert to http handler.
-	s.HTTPServer.Handler = GRPCHandlerFunc(s.GRPCServer, httpMux)
+	s.HTTPServer.Handler = grpcHandlerFunc(s.GRPCServer, s.httpMiddleware(httpMux), h2s)
 	s.HTTPServer.RegisterOnShutdown(s.shutdownFunc)
 
-	return s.HTTPServer.ListenAndServe()
+	return nil
 }
 
 func (s *Service) stopGRPCAndHTTPServer() {
+	// deregister the service instance first, so that the clients can stop sending
+	// new requests during preShutdownDelay
+	s.deregisterInstance()
+
 	// disable keep-alives on existing connections
 	s.HTTPServer.SetKeepAlivesEnabled(false)
 
@@ -530,8 +1007,16 @@ func (s *Service) stopGRPCAndHTTPServer() {
 		time.Sleep(s.preShutdownDelay)
 	}
 
+	// the http and gRPC servers share one shutdown deadline
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
 	// graceful server shutdown
-	s.httpServerShutdown()
+	s.httpServerShutdown(ctx)
+
+	// the hijacked h2c connections are not tracked by the http server,
+	// so the gRPC server should be stopped after the http server shutdown.
+	s.stopGRPCServer(ctx, true)
 }
 
 // The following method is only used to start the grpc server, but not start http gw.
@@ -543,6 +1028,9 @@ func NewServiceWithoutGateway(opts ...Option) *Service {
 	// app option functions.
 	s.apply(opts)
 
+	// install the interceptor of the settings which can be changed by reloading config
+	s.installReloadable()
+
 	// install request interceptor
 	if s.enableRequestAccess {
 		s.unaryInterceptors = append(s.unaryInterceptors, s.RequestInterceptor)
@@ -565,14 +1053,15 @@ func NewServiceWithoutGateway(opts ...Option) *Service {
 
 	s.muxOptions = nil
 
-	s.gRPCServerOptions = append(s.gRPCServerOptions,
-		grpc.ChainStreamInterceptor(s.streamInterceptors...),
-		grpc.ChainUnaryInterceptor(s.unaryInterceptors...))
+	s.messageSizeOptions()
+	s.gRPCServerOptions = append(s.gRPCServerOptions, s.interceptorOptions()...)
 
 	s.GRPCServer = grpc.NewServer(
 		s.gRPCServerOptions...,
 	)
 
+	s.registerMetrics()
+
 	return s
 }
 
@@ -580,6 +1069,17 @@ func NewServiceWithoutGateway(opts ...Option) *Service {
 func (s *Service) StartGRPCWithoutGateway(grpcPort int) error {
 	s.gRPCAddress = fmt.Sprintf("0.0.0.0:%d", grpcPort)
 
+	// run start hooks before the listener opens
+	err := s.runStartHooks()
+	if err != nil {
+		return err
+	}
+
+	lis, err := s.listenGRPCServer()
+	if err != nil {
+		return err
+	}
+
 	// intercept interrupt signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, s.interruptSignals...)
@@ -592,67 +1092,74 @@ func (s *Service) StartGRPCWithoutGateway(grpcPort int) error {
 		defer s.recovery()
 
 		s.logger.Printf("Starting gPRC server listening on %d\n", grpcPort)
-		errChan <- s.startGRPCServer()
+		errChan <- s.GRPCServer.Serve(lis)
 	}()
 
-	// wait for context cancellation or shutdown signal
-	select {
-	// if gRPC server fail to start
-	case err := <-errChan:
+	// intercept reload signals
+	reloadChan := make(chan os.Signal, 1)
+	if len(s.reloadSignals) > 0 {
+		signal.Notify(reloadChan, s.reloadSignals...)
+		defer signal.Stop(reloadChan)
+	}
+
+	// announce the service instance after the listener starts
+	err = s.registerInstance()
+	if err != nil {
+		s.GRPCServer.Stop()
 		return err
-	// if we received an interrupt signal
-	case sig := <-sigChan:
-		s.logger.Printf("Interrupt signal received: %v\n", sig)
-		s.StopGRPCWithoutGateway()
-		return nil
+	}
+
+	// the gRPC listener is accepting connections now
+	s.notifyStarted()
+
+	// wait for context cancellation, shutdown signal or reload signal
+	for {
+		select {
+		// if gRPC server fail to start
+		case err := <-errChan:
+			return err
+		// if we received a reload signal
+		case sig := <-reloadChan:
+			s.logger.Printf("Reload signal received: %v\n", sig)
+			_ = s.Reload()
+
+		// if we received an interrupt signal
+		case sig := <-sigChan:
+			s.logger.Printf("Interrupt signal received: %v\n", sig)
+			s.StopGRPCWithoutGateway()
+			return nil
+		}
 	}
 }
 
 // StopGRPCWithoutGateway stop the gRPC server gracefully
 func (s *Service) StopGRPCWithoutGateway() {
+	// deregister the service instance first, so that the clients can stop sending
+	// new requests during preShutdownDelay
+	s.deregisterInstance()
+
 	// we wait for a duration of preShutdownDelay for running goroutines to finish their jobs
 	if s.preShutdownDelay > 0 {
 		s.logger.Printf("Waiting for %v before shutdown start\n", s.preShutdownDelay)
 		time.Sleep(s.preShutdownDelay)
 	}
 
-	done := make(chan struct{}, 1)
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		s.shutdownTimeout,
-	)
-
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
 	defer cancel()
 
-	// gracefully stop gRPC server
-	go func() {
-		defer s.recovery()
-		defer close(done)
-
-		s.GRPCServer.GracefulStop()
-	}()
-
-	select {
-	case <-ctx.Done():
-		s.logger.Printf("Grpc server shutdown ctx cancel error: %v", ctx.Err())
-	case <-done:
-		s.logger.Printf("Grpc server shutdown success")
-	}
+	s.stopGRPCServer(ctx, false)
 }
 
-// ServeFile serves a file
+// ServeFile serves the static file of the request path, it can be used as the Route handler.
+// The file is looked up by the static config of WithStatic or WithStaticDir, and 404 is
+// returned if the file does not exist or it is denied by the static policy.
 func (s *Service) ServeFile(w http.ResponseWriter, r *http.Request, _ map[string]string) {
-	dir := s.staticDir
-	if s.staticDir == "" {
-		dir, _ = os.Getwd()
+	h := s.static
+	if h == nil && s.staticDir != "" {
+		h = newStaticHandler(Static{Root: s.staticDir})
 	}
 
-	// check if the file exists and fobid showing directory
-	path := filepath.Join(dir, r.URL.Path)
-	if fileInfo, err := os.Stat(path); os.IsNotExist(err) || fileInfo.IsDir() {
+	if h == nil || !h.serve(w, r) {
 		http.NotFound(w, r)
-		return
 	}
-
-	http.ServeFile(w, r, path)
 }
@@ -173,6 +173,7 @@ func TestNewService(t *testing.T) {
 	// grpc port 9999 alreday in use
 	err = s2.Start(httpPort, grpcPort)
 	should.Error(err)
+	s2.GRPCServer.Stop()
 
 	// create service s3 to trigger errChan2
 	s3 := NewService()
@@ -248,7 +249,7 @@ func TestErrorReverseProxyFunc(t *testing.T) {
 	s.httpServerAddress = fmt.Sprintf("0.0.0.0:%d", httpPort)
 	s.gRPCAddress = fmt.Sprintf("0.0.0.0:%d", grpcPort)
 
-	err := s.startGRPCGateway()
+	_, err := s.listenGRPCGateway()
 	should.EqualError(err, errText)
 }
 
@@ -460,3 +461,246 @@ func TestGRPCServerWithoutGateway(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	s.StopGRPCWithoutGateway()
 }
+
+// blockingGreeterService blocks the RPC until release is closed.
+type blockingGreeterService struct {
+	pb.UnimplementedGreeterServiceServer
+	release chan struct{}
+}
+
+func (s *blockingGreeterService) SayHello(ctx context.Context, in *pb.HelloReq) (*pb.HelloReply, error) {
+	<-s.release
+	return &pb.HelloReply{
+		Name:    "hello," + in.Name,
+		Message: "call ok",
+	}, nil
+}
+
+func TestStopGRPCServerCutOff(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewServiceWithoutGateway(
+		WithPreShutdownDelay(0),
+		WithShutdownTimeout(500*time.Millisecond),
+		WithLogger(LoggerFunc(log.Printf)),
+	)
+
+	greeter := &blockingGreeterService{release: make(chan struct{})}
+	defer close(greeter.release)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, greeter)
+
+	s.gRPCAddress = "127.0.0.1:29999"
+	lis, err := s.listenGRPCServer()
+	should.NoError(err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.GRPCServer.Serve(lis)
+	}()
+
+	conn, err := grpc.Dial(s.gRPCAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, e := pb.NewGreeterServiceClient(conn).SayHello(context.Background(), &pb.HelloReq{Name: "daheige"})
+		errChan <- e
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	should.Equal(int64(1), s.InflightRPCs())
+
+	t0 := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	should.Equal(int64(1), s.stopGRPCServer(ctx, false))
+	should.Equal(int64(1), s.CutOffRPCs())
+	should.Less(time.Since(t0), 2*time.Second)
+	should.Error(<-errChan)
+	should.NoError(<-serveErr)
+}
+
+func TestStopGRPCAndHTTPServerCutOff(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithShutdownTimeout(500*time.Millisecond),
+		WithLogger(LoggerFunc(log.Printf)),
+	)
+
+	greeter := &blockingGreeterService{release: make(chan struct{})}
+	defer close(greeter.release)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, greeter)
+
+	s.httpServerAddress = "127.0.0.1:28081"
+	s.gRPCAddress = s.httpServerAddress
+	lis, err := s.listenGRPCAndHTTPServer()
+	should.NoError(err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.serveHTTP(lis)
+	}()
+
+	conn, err := grpc.Dial(s.gRPCAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, e := pb.NewGreeterServiceClient(conn).SayHello(context.Background(), &pb.HelloReq{Name: "daheige"})
+		errChan <- e
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	should.Equal(int64(1), s.InflightRPCs())
+
+	// the http and gRPC servers share one shutdown deadline
+	t0 := time.Now()
+	s.stopGRPCAndHTTPServer()
+	should.Less(time.Since(t0), 900*time.Millisecond)
+	should.Error(<-errChan)
+	should.NoError(<-serveErr)
+}
+
+func TestStartHook(t *testing.T) {
+	var should = require.New(t)
+
+	hookErr := errors.New("init db error")
+	s := NewServiceWithoutGateway(
+		WithStartHook(func(ctx context.Context) error {
+			return hookErr
+		}),
+	)
+
+	err := s.StartGRPCWithoutGateway(29997)
+	should.ErrorIs(err, hookErr)
+
+	// the listener should not be opened
+	lis, err := net.Listen("tcp", ":29997")
+	should.NoError(err)
+	lis.Close()
+
+	s2 := NewServiceWithoutGateway(
+		WithStartTimeout(100*time.Millisecond),
+		WithStartHook(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}),
+	)
+
+	err = s2.StartGRPCWithoutGateway(29997)
+	should.ErrorIs(err, context.DeadlineExceeded)
+
+	// the panic of the hook becomes the start error
+	s3 := NewServiceWithoutGateway(
+		WithStartHook(func(ctx context.Context) error {
+			panic("init cache panic")
+		}),
+	)
+
+	err = s3.StartGRPCWithoutGateway(29997)
+	should.ErrorContains(err, "init cache panic")
+}
+
+func TestStarted(t *testing.T) {
+	var should = require.New(t)
+
+	var hookCalled bool
+	startedFunc := make(chan struct{})
+	s := NewServiceWithoutGateway(
+		WithPreShutdownDelay(0),
+		WithStartHook(func(ctx context.Context) error {
+			hookCalled = true
+			return nil
+		}),
+		WithStartedFunc(func() {
+			close(startedFunc)
+		}),
+	)
+
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &greeterService{})
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.StartGRPCWithoutGateway(29996)
+	}()
+
+	select {
+	case <-s.Started():
+	case <-time.After(3 * time.Second):
+		should.FailNow("service is not started")
+	}
+
+	<-startedFunc
+	should.True(hookCalled)
+
+	conn, err := grpc.Dial("127.0.0.1:29996", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	_, err = pb.NewGreeterServiceClient(conn).SayHello(context.Background(), &pb.HelloReq{Name: "daheige"})
+	should.NoError(err)
+
+	s.StopGRPCWithoutGateway()
+	should.NoError(<-errChan)
+}
+
+func TestStopGRPCServerOverHTTP(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithShutdownTimeout(3*time.Second),
+		WithGRPCWeb(true),
+		WithLogger(LoggerFunc(log.Printf)),
+	)
+
+	greeter := &countingGreeterService{started: make(chan struct{}), release: make(chan struct{})}
+	pb.RegisterGreeterServiceServer(s.GRPCServer, greeter)
+
+	s.gRPCAddress = "127.0.0.1:29993"
+	s.httpServerAddress = "127.0.0.1:28083"
+	grpcLis, err := s.listenGRPCServer()
+	should.NoError(err)
+	httpLis, err := s.listenGRPCGateway()
+	should.NoError(err)
+	go func() {
+		_ = s.GRPCServer.Serve(grpcLis)
+	}()
+	go func() {
+		_ = s.serveHTTP(httpLis)
+	}()
+
+	conn, err := grpc.Dial(s.gRPCAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	client := pb.NewGreeterServiceClient(conn)
+	errChan := make(chan error, 1)
+	go func() {
+		_, e := client.SayHello(context.Background(), &pb.HelloReq{Name: "slow"})
+		errChan <- e
+	}()
+	<-greeter.started
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	// the gRPC listener is stopped gracefully, the connection receives GOAWAY
+	// and the new RPCs are not accepted any more
+	time.Sleep(300 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = client.SayHello(ctx, &pb.HelloReq{Name: "daheige"})
+	should.Error(err)
+
+	// the pending RPC is finished
+	close(greeter.release)
+	should.NoError(<-errChan)
+	<-stopped
+	should.Equal(int64(0), s.CutOffRPCs())
+}
@@ -0,0 +1,378 @@
+package gmicro
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the default path of the merged OpenAPI document
+const defaultOpenAPIPath = "/openapi.json"
+
+// the html page of the API explorer, it reads the document from DocPath
+var openAPIExplorerTemplate = template.Must(template.New("explorer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API Explorer</title>
+<style>
+body{font-family:sans-serif;margin:24px}
+.op{border:1px solid #ccc;border-radius:4px;margin:8px 0;padding:8px}
+.method{display:inline-block;min-width:64px;font-weight:bold;text-transform:uppercase}
+.params label{display:block;margin:4px 0}
+textarea{width:100%;height:80px;font-family:monospace}
+pre{background:#f5f5f5;padding:8px;overflow:auto}
+</style>
+</head>
+<body>
+<h1 id="title">API Explorer</h1>
+<div id="ops"></div>
+<script>
+const docPath = {{.DocPath}};
+function el(tag, attrs, text) {
+  const e = document.createElement(tag);
+  Object.assign(e, attrs || {});
+  if (text !== undefined) e.textContent = text;
+  return e;
+}
+fetch(docPath).then(r => r.json()).then(doc => {
+  const info = doc.info || {};
+  document.getElementById("title").textContent = (info.title || "API Explorer") + " " + (info.version || "");
+  const base = doc.servers ? doc.servers[0].url : (doc.basePath || "");
+  const ops = document.getElementById("ops");
+  for (const [p, item] of Object.entries(doc.paths || {})) {
+    for (const [method, op] of Object.entries(item)) {
+      if (typeof op !== "object" || method === "parameters") continue;
+      const box = el("div", {className: "op"});
+      box.append(el("span", {className: "method"}, method), el("code", {}, p));
+      box.append(el("p", {}, op.summary || op.operationId || ""));
+      const params = el("div", {className: "params"});
+      const inputs = [];
+      for (const param of (op.parameters || [])) {
+        if (param.in === "body") continue;
+        const input = el("input", {placeholder: param.name});
+        inputs.push([param, input]);
+        const label = el("label", {}, param.name + " (" + param.in + ") ");
+        label.append(input);
+        params.append(label);
+      }
+      const hasBody = (op.parameters || []).some(x => x.in === "body") || op.requestBody;
+      const body = hasBody ? el("textarea", {placeholder: "request body json"}) : null;
+      if (body) params.append(body);
+      const out = el("pre");
+      const send = el("button", {}, "Send");
+      send.onclick = () => {
+        let url = p;
+        const query = new URLSearchParams();
+        for (const [param, input] of inputs) {
+          if (!input.value) continue;
+          if (param.in === "path") url = url.replace(new RegExp("{" + param.name + "(=[^}]*)?}"), encodeURIComponent(input.value));
+          if (param.in === "query") query.append(param.name, input.value);
+        }
+        const qs = query.toString();
+        const init = {method: method.toUpperCase(), headers: {"Content-Type": "application/json"}};
+        if (body && body.value) init.body = body.value;
+        fetch(base.replace(/\/$/, "") + url + (qs ? "?" + qs : ""), init)
+          .then(r => r.text().then(t => out.textContent = r.status + " " + r.statusText + "\n\n" + t))
+          .catch(e => out.textContent = e);
+      };
+      params.append(send);
+      box.append(params, out);
+      ops.append(box);
+    }
+  }
+});
+</script>
+</body>
+</html>
+`))
+
+// OpenAPI is the config of serving the OpenAPI (Swagger) documents generated by
+// protoc-gen-openapiv2, the documents are merged into one and the server address
+// is rewritten to the address requested by the client.
+type OpenAPI struct {
+	// FS the file system of the documents such as embed.FS,
+	// the Files are read from the operating system if it is nil.
+	FS fs.FS
+
+	// Files the json or yaml documents, the info and the other top level fields
+	// of the first document take precedence when they are merged.
+	Files []string
+
+	// Path the path of the merged json document, default: /openapi.json
+	Path string
+
+	// UIPath the path of the embedded API explorer, the explorer is disabled if it is empty.
+	UIPath string
+
+	// Title and Version override the info of the merged document if they are not empty.
+	Title   string
+	Version string
+
+	// ServerURL the scheme and host of the server address in the document, eg: https://api.example.com
+	// default: the X-Forwarded-Proto and X-Forwarded-Host of the trusted proxies,
+	// or the advertised host and the http port, or the host requested by the client.
+	ServerURL string
+}
+
+// openAPI serves the merged OpenAPI document and the explorer.
+type openAPI struct {
+	OpenAPI
+	doc map[string]interface{}
+
+	// trustedProxy reports whether the X-Forwarded-* headers of the request are trusted
+	trustedProxy func(r *http.Request) bool
+
+	// advertiseHost the advertised host and port of the http server
+	advertiseHost string
+}
+
+// loadOpenAPI reads and merges the documents.
+func loadOpenAPI(c OpenAPI) (*openAPI, error) {
+	if c.Path == "" {
+		c.Path = defaultOpenAPIPath
+	}
+
+	doc := make(map[string]interface{})
+	for _, file := range c.Files {
+		var (
+			b   []byte
+			err error
+		)
+		if c.FS != nil {
+			b, err = fs.ReadFile(c.FS, file)
+		} else {
+			b, err = os.ReadFile(file)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("read openapi document %s error: %w", file, err)
+		}
+
+		var src map[string]interface{}
+		switch strings.ToLower(path.Ext(file)) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(b, &src)
+		default:
+			err = json.Unmarshal(b, &src)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("decode openapi document %s error: %w", file, err)
+		}
+
+		mergeOpenAPI(doc, src)
+	}
+
+	info, _ := doc["info"].(map[string]interface{})
+	if info == nil {
+		info = make(map[string]interface{})
+		doc["info"] = info
+	}
+
+	if c.Title != "" {
+		info["title"] = c.Title
+	}
+
+	if c.Version != "" {
+		info["version"] = c.Version
+	}
+
+	return &openAPI{OpenAPI: c, doc: doc}, nil
+}
+
+// mergeOpenAPI merges the paths, schemas and tags of src into dst,
+// the other fields of dst are kept if they exist.
+func mergeOpenAPI(dst, src map[string]interface{}) {
+	for k, v := range src {
+		switch k {
+		case "paths", "components":
+			// the operations of the same path and the schemas of the components are merged
+			mergeMaps(dst, k, v, 2)
+		case "definitions", "parameters", "responses", "securityDefinitions":
+			mergeMaps(dst, k, v, 1)
+		case "tags":
+			tags, _ := dst[k].([]interface{})
+			names := make(map[interface{}]bool, len(tags))
+			for _, t := range tags {
+				if m, ok := t.(map[string]interface{}); ok {
+					names[m["name"]] = true
+				}
+			}
+
+			list, _ := v.([]interface{})
+			for _, t := range list {
+				m, ok := t.(map[string]interface{})
+				if ok && names[m["name"]] {
+					continue
+				}
+
+				tags = append(tags, t)
+			}
+
+			dst[k] = tags
+		default:
+			if _, ok := dst[k]; !ok {
+				dst[k] = v
+			}
+		}
+	}
+}
+
+// mergeMaps merges the map v into dst[key] recursively up to depth levels.
+func mergeMaps(dst map[string]interface{}, key string, v interface{}, depth int) {
+	src, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	m, ok := dst[key].(map[string]interface{})
+	if !ok {
+		m = make(map[string]interface{}, len(src))
+		dst[key] = m
+	}
+
+	for k, sv := range src {
+		if _, exists := m[k]; exists && depth > 1 {
+			mergeMaps(m, k, sv, depth-1)
+			continue
+		}
+
+		if _, exists := m[k]; !exists {
+			m[k] = sv
+		}
+	}
+}
+
+// serverAddress returns the scheme and host of the document, the forwarded headers
+// are used only if they are sent by the trusted proxies.
+func (o *openAPI) serverAddress(r *http.Request) (string, string) {
+	if o.ServerURL != "" {
+		if u, err := url.Parse(o.ServerURL); err == nil && u.Host != "" {
+			return u.Scheme, u.Host
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	host := r.Host
+	if o.advertiseHost != "" {
+		host = o.advertiseHost
+	}
+
+	if o.trustedProxy == nil || !o.trustedProxy(r) {
+		return scheme, host
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host = fwd
+	}
+
+	return scheme, host
+}
+
+// document returns the merged document with the server address of the request.
+func (o *openAPI) document(r *http.Request) map[string]interface{} {
+	scheme, host := o.serverAddress(r)
+
+	doc := make(map[string]interface{}, len(o.doc)+2)
+	for k, v := range o.doc {
+		doc[k] = v
+	}
+
+	if _, ok := doc["openapi"]; ok {
+		// OpenAPI 3 keeps the base path in the server url
+		basePath := ""
+		if servers, ok := o.doc["servers"].([]interface{}); ok && len(servers) > 0 {
+			if server, ok := servers[0].(map[string]interface{}); ok {
+				serverURL, _ := server["url"].(string)
+				if u, err := url.Parse(serverURL); err == nil {
+					basePath = strings.TrimSuffix(u.Path, "/")
+				}
+			}
+		}
+
+		doc["servers"] = []interface{}{map[string]interface{}{"url": scheme + "://" + host + basePath}}
+		return doc
+	}
+
+	doc["host"] = host
+	doc["schemes"] = []interface{}{scheme}
+	return doc
+}
+
+func (o *openAPI) serveDocument(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	writeJSON(w, http.StatusOK, o.document(r))
+}
+
+func (o *openAPI) serveExplorer(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = openAPIExplorerTemplate.Execute(w, map[string]string{"DocPath": o.Path})
+}
+
+// appOpenAPI loads the OpenAPI documents served by the routes of WithOpenAPI.
+func (s *Service) appOpenAPI() error {
+	if s.openAPIConfig == nil {
+		return nil
+	}
+
+	o, err := loadOpenAPI(*s.openAPIConfig)
+	if err != nil {
+		s.logger.Printf("load openapi documents error: %s\n", err.Error())
+		return err
+	}
+
+	o.trustedProxy = s.trustedProxy
+	if s.advertiseHost != "" {
+		_, port, _ := net.SplitHostPort(s.httpServerAddress)
+		o.advertiseHost = net.JoinHostPort(s.advertiseHost, port)
+	}
+
+	s.openAPI = o
+	return nil
+}
+
+// openAPIRoutes returns the routes of the document and the explorer, the document
+// is loaded when the server starts.
+func (s *Service) openAPIRoutes(c OpenAPI) []Route {
+	if c.Path == "" {
+		c.Path = defaultOpenAPIPath
+	}
+
+	routes := []Route{{
+		Method: http.MethodGet,
+		Path:   c.Path,
+		Name:   "openapi",
+		Handler: func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			s.openAPI.serveDocument(w, r, params)
+		},
+	}}
+	if c.UIPath != "" {
+		routes = append(routes, Route{
+			Method: http.MethodGet,
+			Path:   c.UIPath,
+			Name:   "openapi_explorer",
+			Handler: func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+				s.openAPI.serveExplorer(w, r, params)
+			},
+		})
+	}
+
+	return routes
+}
@@ -0,0 +1,138 @@
+package gmicro
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var openAPITestFiles = fstest.MapFS{
+	"hello.swagger.json": {Data: []byte(`{
+  "swagger": "2.0",
+  "info": {"title": "hello.proto", "version": "version not set"},
+  "tags": [{"name": "GreeterService"}],
+  "paths": {"/v1/say/{name}": {"get": {"operationId": "GreeterService_SayHello"}}},
+  "definitions": {"HelloReply": {"type": "object"}}
+}`)},
+	"user.swagger.yaml": {Data: []byte(`
+swagger: "2.0"
+info:
+  title: user.proto
+tags:
+  - name: GreeterService
+  - name: UserService
+paths:
+  /v1/say/{name}:
+    post:
+      operationId: GreeterService_Post
+  /v1/users/{id}:
+    get:
+      operationId: UserService_GetUser
+definitions:
+  User:
+    type: object
+`)},
+	"api.openapi.json": {Data: []byte(`{
+  "openapi": "3.0.1",
+  "info": {"title": "api"},
+  "servers": [{"url": "https://example.com/api/"}],
+  "paths": {}
+}`)},
+}
+
+func TestLoadOpenAPI(t *testing.T) {
+	var should = require.New(t)
+	o, err := loadOpenAPI(OpenAPI{
+		FS:      openAPITestFiles,
+		Files:   []string{"hello.swagger.json", "user.swagger.yaml"},
+		Version: "v1.0.0",
+	})
+	should.NoError(err)
+	should.Equal(defaultOpenAPIPath, o.Path)
+
+	b, err := json.Marshal(o.document(httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/openapi.json", nil)))
+	should.NoError(err)
+	should.JSONEq(`{
+  "swagger": "2.0",
+  "host": "127.0.0.1:8080",
+  "schemes": ["http"],
+  "info": {"title": "hello.proto", "version": "v1.0.0"},
+  "tags": [{"name": "GreeterService"}, {"name": "UserService"}],
+  "paths": {
+    "/v1/say/{name}": {
+      "get": {"operationId": "GreeterService_SayHello"},
+      "post": {"operationId": "GreeterService_Post"}
+    },
+    "/v1/users/{id}": {"get": {"operationId": "UserService_GetUser"}}
+  },
+  "definitions": {"HelloReply": {"type": "object"}, "User": {"type": "object"}}
+}`, string(b))
+
+	o, err = loadOpenAPI(OpenAPI{FS: openAPITestFiles, Files: []string{"api.openapi.json"}})
+	should.NoError(err)
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/openapi.json", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "api.example.org")
+
+	// the forwarded headers are ignored unless the request is sent by the trusted proxies
+	should.Equal([]interface{}{map[string]interface{}{"url": "http://127.0.0.1:8080/api"}}, o.document(req)["servers"])
+	o.advertiseHost = "10.0.0.1:8080"
+	should.Equal([]interface{}{map[string]interface{}{"url": "http://10.0.0.1:8080/api"}}, o.document(req)["servers"])
+
+	o.trustedProxy = NewService(WithTrustedProxies("192.0.2.0/24", "invalid")).trustedProxy
+	should.Equal([]interface{}{map[string]interface{}{"url": "https://api.example.org/api"}}, o.document(req)["servers"])
+	req.RemoteAddr = "198.51.100.1:1234"
+	should.Equal([]interface{}{map[string]interface{}{"url": "http://10.0.0.1:8080/api"}}, o.document(req)["servers"])
+
+	o.ServerURL = "https://api.example.com"
+	should.Equal([]interface{}{map[string]interface{}{"url": "https://api.example.com/api"}}, o.document(req)["servers"])
+
+	_, err = loadOpenAPI(OpenAPI{FS: openAPITestFiles, Files: []string{"missing.json"}})
+	assert.Error(t, err)
+}
+
+func TestOpenAPI(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithOpenAPI(OpenAPI{
+			FS:     openAPITestFiles,
+			Files:  []string{"hello.swagger.json"},
+			Path:   "/swagger/doc.json",
+			UIPath: "/swagger",
+		}),
+	)
+
+	var names []string
+	for _, route := range s.Introspect().Routes {
+		names = append(names, route.Name)
+	}
+
+	should.Equal([]string{"openapi", "openapi_explorer"}, names)
+
+	addr := startTestServer(t, s)
+
+	res, err := http.Get("http://" + addr + "/swagger/doc.json")
+	should.NoError(err)
+	var doc map[string]interface{}
+	should.NoError(json.NewDecoder(res.Body).Decode(&doc))
+	res.Body.Close()
+	should.Equal(addr, doc["host"])
+
+	res, err = http.Get("http://" + addr + "/swagger")
+	should.NoError(err)
+	b, err := io.ReadAll(res.Body)
+	should.NoError(err)
+	res.Body.Close()
+	should.Contains(res.Header.Get("Content-Type"), "text/html")
+	should.Contains(string(b), `const docPath = "/swagger/doc.json";`)
+
+	s = NewService(WithOpenAPI(OpenAPI{Files: []string{"missing.json"}}))
+	assert.Error(t, s.StartGRPCAndHTTPServer(0))
+}
@@ -41,7 +41,42 @@ func WithAnnotator(annotator ...AnnotatorFunc) Option {
 	}
 }
 
-// WithErrorHandler returns an Option to set the errorHandler
+// WithMetadataPropagation returns an Option to propagate the metadata keys of the
+// incoming requests to the outgoing calls, DefaultPropagationKeys are used if no key is specified.
+// The key ending with "*" matches the keys with its prefix, the baggage is propagated only if
+// it is allowlisted, eg: "baggage-*". The gateway forwards the http headers of the keys as metadata,
+// and the clients dialed with PropagationDialOptions carry them to the called services.
+func WithMetadataPropagation(keys ...string) Option {
+	return func(s *Service) {
+		s.propagator = NewPropagator(keys...)
+	}
+}
+
+// WithTenancy returns an Option to resolve the tenant of the requests and apply
+// the rate limit and authorization policies of the tenants.
+func WithTenancy(t Tenancy) Option {
+	return func(s *Service) {
+		s.tenancy = newTenancy(t)
+	}
+}
+
+// WithIdempotency returns an Option to replay the stored replies of the unary RPCs
+// which are called with the same Idempotency-Key.
+func WithIdempotency(c Idempotency) Option {
+	return func(s *Service) {
+		s.idempotency = newIdempotency(c)
+	}
+}
+
+// WithResponseCache returns an Option to cache the replies of the read-only unary RPCs.
+func WithResponseCache(c ResponseCache) Option {
+	return func(s *Service) {
+		s.responseCache = newResponseCache(c)
+	}
+}
+
+// WithErrorHandler returns an Option to set the errorHandler, default: runtime.DefaultHTTPErrorHandler,
+// use HTTPErrorHandler to render the errors as ErrorBody.
 func WithErrorHandler(errorHandler gRuntime.ErrorHandlerFunc) Option {
 	return func(s *Service) {
 		s.errorHandler = errorHandler
@@ -98,13 +133,22 @@ func WithStaticDir(dir string) Option {
 	}
 }
 
-// WithStaticAccess enable static file access
+// WithStaticAccess enable static file access, the files of staticDir are served
+// by the default policy of Static, it is disabled if staticDir is not set.
 func WithStaticAccess(b bool) Option {
 	return func(s *Service) {
 		s.enableStaticAccess = b
 	}
 }
 
+// WithStatic returns an Option to serve the static files of st.FS under st.Prefix,
+// such as the files embedded by embed.FS, it takes precedence over WithStaticDir.
+func WithStatic(st Static) Option {
+	return func(s *Service) {
+		s.static = newStaticHandler(st)
+	}
+}
+
 // WithGRPCServerOption returns an Option to append a gRPC server option
 func WithGRPCServerOption(serverOption ...grpc.ServerOption) Option {
 	return func(s *Service) {
@@ -162,6 +206,14 @@ func WithHandlerFromEndpoint(reverseProxyFunc ...HandlerFromEndpoint) Option {
 	}
 }
 
+// WithServiceHandlerFromEndpoint returns an Option to add the HandlerFromEndpoint of the gRPC service,
+// the service is its full name, eg: App.Grpc.Hello.GreeterService, so Introspect lists its http bindings.
+func WithServiceHandlerFromEndpoint(service string, reverseProxyFunc HandlerFromEndpoint) Option {
+	return func(s *Service) {
+		s.AddServiceHandlerFromEndpoint(service, reverseProxyFunc)
+	}
+}
+
 // WithRouteOpt adds additional routes
 func WithRouteOpt(routes ...Route) Option {
 	return func(s *Service) {
@@ -169,9 +221,255 @@ func WithRouteOpt(routes ...Route) Option {
 	}
 }
 
+// WithRouteGroup returns an Option to add the routes of the groups.
+func WithRouteGroup(groups ...RouteGroup) Option {
+	return func(s *Service) {
+		s.AddRouteGroup(groups...)
+	}
+}
+
 // WithGRPCNetwork set gRPC start network type.
 func WithGRPCNetwork(network string) Option {
 	return func(s *Service) {
 		s.gRPCNetwork = network
 	}
 }
+
+// WithStartHook returns an Option to append some hooks which will be called before the listeners open
+func WithStartHook(hooks ...StartHook) Option {
+	return func(s *Service) {
+		s.startHooks = append(s.startHooks, hooks...)
+	}
+}
+
+// WithStartTimeout returns an Option to set the timeout for running all the start hooks
+func WithStartTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.startTimeout = timeout
+	}
+}
+
+// WithStartedFunc returns an Option to register a function which will be called
+// when the listeners are accepting connections
+func WithStartedFunc(f func()) Option {
+	return func(s *Service) {
+		s.startedFunc = f
+	}
+}
+
+// WithReloadSignal returns an Option to append a reload signal
+func WithReloadSignal(signal os.Signal) Option {
+	return func(s *Service) {
+		s.reloadSignals = append(s.reloadSignals, signal)
+	}
+}
+
+// WithConfigSource returns an Option to set the config source which the service reloads from
+func WithConfigSource(source ConfigSource) Option {
+	return func(s *Service) {
+		s.configSource = source
+	}
+}
+
+// WithReloadFunc returns an Option to append some functions to be notified when the service reloads
+func WithReloadFunc(f ...ReloadFunc) Option {
+	return func(s *Service) {
+		s.reloadFuncs = append(s.reloadFuncs, f...)
+	}
+}
+
+// WithRegistry returns an Option to set the registry which the service registers to
+func WithRegistry(registry Registry) Option {
+	return func(s *Service) {
+		s.registry = registry
+	}
+}
+
+// WithServiceName returns an Option to set the service name registered to registry,
+// the executable name is used by default
+func WithServiceName(name string) Option {
+	return func(s *Service) {
+		s.serviceName = name
+	}
+}
+
+// WithServiceMetadata returns an Option to set the metadata registered to registry
+func WithServiceMetadata(md map[string]string) Option {
+	return func(s *Service) {
+		s.serviceMetadata = md
+	}
+}
+
+// WithRegisterTTL returns an Option to set the ttl of the registered instance,
+// the ttl less than 1 second is replaced by the default 15 seconds.
+func WithRegisterTTL(ttl time.Duration) Option {
+	return func(s *Service) {
+		if ttl < minRegisterTTL {
+			ttl = defaultRegisterTTL
+		}
+
+		s.registerTTL = ttl
+	}
+}
+
+// WithAdvertiseHost returns an Option to set the host registered to registry,
+// the first non-loopback IPv4 address is used by default
+func WithAdvertiseHost(host string) Option {
+	return func(s *Service) {
+		s.advertiseHost = host
+	}
+}
+
+// WithCORS returns an Option to enable CORS for the http gateway, the preflight requests are handled
+// before the gateway mux, so it works for the paths registered by HandlerFromEndpoint.
+func WithCORS(c CORSConfig) Option {
+	return func(s *Service) {
+		s.cors = newCORS(c)
+	}
+}
+
+// WithGRPCWeb returns an Option to translate the gRPC-Web requests received by
+// the http gateway to the gRPC server, so the browser gRPC-Web clients can be used.
+func WithGRPCWeb(b bool) Option {
+	return func(s *Service) {
+		s.enableGRPCWeb = b
+	}
+}
+
+// WithConnect returns an Option to translate the Connect protocol requests received by
+// the http gateway to the gRPC server, so the RPCs can be called with plain HTTP/1.1.
+func WithConnect(b bool) Option {
+	return func(s *Service) {
+		s.enableConnect = b
+	}
+}
+
+// WithStreamRoute returns an Option to expose the gRPC streaming methods on the http gateway
+// as Server-Sent Events or WebSocket.
+func WithStreamRoute(routes ...StreamRoute) Option {
+	return func(s *Service) {
+		s.streamRoutes = append(s.streamRoutes, routes...)
+	}
+}
+
+// WithStreamHeartbeat returns an Option to set the interval of SSE heartbeat comments
+// and WebSocket pings, default: 15s
+func WithStreamHeartbeat(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.streamHeartbeat = d
+		}
+	}
+}
+
+// WithEnvelope returns an Option to render the gateway replies and errors in the envelope,
+// it replaces the errorHandler and the marshaler of all MIME types.
+func WithEnvelope(e Envelope) Option {
+	return func(s *Service) {
+		s.errorHandler = e.ErrorHandler()
+		s.muxOptions = append(s.muxOptions, gRuntime.WithMarshalerOption(gRuntime.MIMEWildcard, e.Marshaler()))
+	}
+}
+
+// WithMaxBodySize returns an Option to limit the request body size of the http gateway,
+// the request with larger body is rejected with 413, default: no limit
+func WithMaxBodySize(n int64) Option {
+	return func(s *Service) {
+		s.maxBodySize = n
+	}
+}
+
+// WithBodyLimit returns an Option to limit the request body size of the matched
+// http gateway routes, it takes precedence over WithMaxBodySize.
+func WithBodyLimit(limits ...BodyLimit) Option {
+	return func(s *Service) {
+		s.bodyLimits = append(s.bodyLimits, limits...)
+	}
+}
+
+// WithMaxHeaderBytes returns an Option to limit the request header size
+// of the http server and gRPC server, default: 1MB for http.
+func WithMaxHeaderBytes(n int) Option {
+	return func(s *Service) {
+		s.maxHeaderBytes = n
+	}
+}
+
+// WithMaxRecvMsgSize returns an Option to set the max message size in bytes
+// the gRPC server can receive, default: 4MB
+func WithMaxRecvMsgSize(n int) Option {
+	return func(s *Service) {
+		s.maxRecvMsgSize = n
+	}
+}
+
+// WithMaxSendMsgSize returns an Option to set the max message size in bytes
+// the gRPC server can send, default: math.MaxInt32
+func WithMaxSendMsgSize(n int) Option {
+	return func(s *Service) {
+		s.maxSendMsgSize = n
+	}
+}
+
+// WithMinTransferRate returns an Option to reject the http gateway request with 408
+// if its body is sent slower than bytesPerSecond after the first second, default: no limit
+func WithMinTransferRate(bytesPerSecond int64) Option {
+	return func(s *Service) {
+		s.minTransferRate = bytesPerSecond
+	}
+}
+
+// WithCompression returns an Option to compress the http gateway responses
+// by the encoding negotiated with the Accept-Encoding header.
+func WithCompression(c Compression) Option {
+	return func(s *Service) {
+		s.compression = newCompression(c)
+	}
+}
+
+// WithGRPCCompressor returns an Option to compress the gRPC responses by the registered
+// compressor name such as EncodingGzip and EncodingZstd if the client supports it,
+// the zstd compressor is registered only when it is used.
+func WithGRPCCompressor(name string) Option {
+	return func(s *Service) {
+		if name == EncodingZstd {
+			registerZstdCompressor()
+		}
+
+		s.grpcCompressor = name
+	}
+}
+
+// WithIntrospection returns an Option to add the route of path which renders
+// the Service Introspection as JSON or HTML, it should be protected by the
+// middlewares such as authentication since it exposes the service internals.
+func WithIntrospection(path string, middlewares ...Middleware) Option {
+	return func(s *Service) {
+		s.routes = append(s.routes, Route{
+			Method:      http.MethodGet,
+			Path:        path,
+			Handler:     s.introspectionHandler,
+			Name:        "introspection",
+			Middlewares: middlewares,
+		})
+	}
+}
+
+// WithOpenAPI returns an Option to serve the merged OpenAPI documents and
+// the embedded API explorer on the http gateway, the routes are named openapi
+// and openapi_explorer.
+func WithOpenAPI(c OpenAPI) Option {
+	return func(s *Service) {
+		s.openAPIConfig = &c
+		s.routes = append(s.routes, s.openAPIRoutes(c)...)
+	}
+}
+
+// WithTrustedProxies returns an Option to trust the X-Forwarded-* headers of the requests
+// sent by the proxies, the proxy is an ip address or a CIDR such as 10.0.0.0/8,
+// the invalid ones are ignored. The forwarded headers are not trusted by default.
+func WithTrustedProxies(proxies ...string) Option {
+	return func(s *Service) {
+		s.trustedProxies = append(s.trustedProxies, parseTrustedProxies(proxies)...)
+	}
+}
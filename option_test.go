@@ -68,7 +68,7 @@ func TestInterruptSignal(t *testing.T) {
 		WithInterruptSignal(syscall.SIGKILL),
 	)
 
-	assert.Len(t, s.interruptSignals, 7)
+	assert.Len(t, s.interruptSignals, 6)
 }
 
 func TestGRPCServerOption(t *testing.T) {
@@ -117,3 +117,17 @@ func TestMuxOption(t *testing.T) {
 
 	assert.Len(t, s.muxOptions, 3)
 }
+
+func TestStartHookOption(t *testing.T) {
+	s := NewService(
+		WithStartHook(func(ctx context.Context) error {
+			return nil
+		}),
+		WithStartTimeout(3*time.Second),
+		WithStartedFunc(func() {}),
+	)
+
+	assert.Len(t, s.startHooks, 1)
+	assert.Equal(t, 3*time.Second, s.startTimeout)
+	assert.NotNil(t, s.startedFunc)
+}
@@ -0,0 +1,158 @@
+package gmicro
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// BaggagePrefix is the prefix of the custom metadata keys set by SetBaggage, eg: baggage-user-id,
+// the http gateway maps the Baggage-User-Id header to it. The baggage is propagated only if
+// it is allowlisted by its key or by the "baggage-*" pattern.
+const BaggagePrefix = "baggage-"
+
+// DefaultPropagationKeys the metadata keys propagated from the incoming to the outgoing calls by default,
+// they are the request id, tenant, locale and the w3c trace context headers.
+var DefaultPropagationKeys = []string{
+	XRequestID.String(), "x-tenant-id", "x-locale", "traceparent", "tracestate",
+}
+
+// Propagator copies the allowlisted metadata of the incoming context of the server
+// to the outgoing context, so the metadata is carried to the services called by the handlers.
+type Propagator struct {
+	keys     map[string]bool
+	prefixes []string
+}
+
+// NewPropagator returns a Propagator of the metadata keys, DefaultPropagationKeys are used
+// if no key is specified. The key ending with "*" matches the keys with its prefix,
+// eg: "baggage-*" propagates all the baggage.
+func NewPropagator(keys ...string) *Propagator {
+	if len(keys) == 0 {
+		keys = DefaultPropagationKeys
+	}
+
+	p := &Propagator{keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if strings.HasSuffix(key, "*") {
+			p.prefixes = append(p.prefixes, strings.TrimSuffix(key, "*"))
+			continue
+		}
+
+		p.keys[key] = true
+	}
+
+	return p
+}
+
+// propagated reports whether the metadata key is propagated.
+func (p *Propagator) propagated(key string) bool {
+	if p.keys[key] {
+		return true
+	}
+
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Propagate returns ctx with the propagated metadata of the incoming context appended to
+// the outgoing context, the keys set to the outgoing context explicitly are not overwritten.
+func (p *Propagator) Propagate(ctx context.Context) context.Context {
+	in, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	out, _ := metadata.FromOutgoingContext(ctx)
+	out = out.Copy()
+	changed := false
+	for key, values := range in {
+		if _, exists := out[key]; exists || !p.propagated(key) {
+			continue
+		}
+
+		out[key] = append([]string(nil), values...)
+		changed = true
+	}
+
+	if !changed {
+		return ctx
+	}
+
+	return metadata.NewOutgoingContext(ctx, out)
+}
+
+// UnaryClientInterceptor returns the client interceptor which propagates the metadata.
+func (p *Propagator) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(p.Propagate(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns the client stream interceptor which propagates the metadata.
+func (p *Propagator) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(p.Propagate(ctx), desc, cc, method, opts...)
+	}
+}
+
+// DialOptions returns the dial options which install the client interceptors,
+// eg: grpc.Dial(target, append(opts, p.DialOptions()...)...)
+func (p *Propagator) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(p.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(p.StreamClientInterceptor()),
+	}
+}
+
+// Annotator is the AnnotatorFunc of the gateway which maps the http headers of the propagated
+// keys and the Baggage-* headers to the metadata of the gRPC request.
+func (p *Propagator) Annotator(_ context.Context, r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for name, values := range r.Header {
+		key := strings.ToLower(name)
+		if p.propagated(key) {
+			md.Append(key, values...)
+		}
+	}
+
+	return md
+}
+
+// PropagationDialOptions returns the dial options of the Propagator enabled by WithMetadataPropagation,
+// the calls of the clients dialed with them carry the metadata of the handled request.
+// nil is returned if the propagation is not enabled.
+func (s *Service) PropagationDialOptions() []grpc.DialOption {
+	if s.propagator == nil {
+		return nil
+	}
+
+	return s.propagator.DialOptions()
+}
+
+// SetBaggage returns ctx with the baggage appended to the outgoing metadata,
+// the key is prefixed with BaggagePrefix, eg: baggage-user-id.
+func SetBaggage(ctx context.Context, key, value string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, BaggagePrefix+strings.ToLower(key), value)
+}
+
+// GetBaggage returns the baggage of key in the incoming metadata.
+func GetBaggage(ctx context.Context, key string) string {
+	values := GetIncomingMD(ctx).Get(BaggagePrefix + key)
+	if len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
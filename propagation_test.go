@@ -0,0 +1,116 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataGreeterService replies the propagated metadata of the request.
+type metadataGreeterService struct {
+	pb.UnimplementedGreeterServiceServer
+}
+
+func (s *metadataGreeterService) SayHello(ctx context.Context, in *pb.HelloReq) (*pb.HelloReply, error) {
+	md := GetIncomingMD(ctx)
+	return &pb.HelloReply{Name: in.Name, Message: strings.Join([]string{
+		GetStringFromMD(md, XRequestID), GetStringFromMD(md, CtxKey("x-tenant-id")),
+		GetBaggage(ctx, "user-id"), GetStringFromMD(md, CtxKey("authorization")),
+	}, "|")}, nil
+}
+
+// forwardGreeterService calls the downstream service with the context of the request.
+type forwardGreeterService struct {
+	pb.UnimplementedGreeterServiceServer
+	client pb.GreeterServiceClient
+}
+
+func (s *forwardGreeterService) SayHello(ctx context.Context, in *pb.HelloReq) (*pb.HelloReply, error) {
+	return s.client.SayHello(ctx, in)
+}
+
+func TestPropagator(t *testing.T) {
+	var should = require.New(t)
+	p := NewPropagator()
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-request-id", "abc", "x-tenant-id", "t1", "baggage-user-id", "u1", "authorization", "token",
+	))
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "t2")
+	out := GetOutgoingMD(p.Propagate(ctx))
+	should.Equal(metadata.Pairs("x-request-id", "abc", "x-tenant-id", "t2"), out)
+
+	// the baggage is propagated only if it is allowlisted
+	p = NewPropagator(append([]string{"baggage-*"}, DefaultPropagationKeys...)...)
+	out = GetOutgoingMD(p.Propagate(ctx))
+	should.Equal(metadata.Pairs("x-request-id", "abc", "x-tenant-id", "t2", "baggage-user-id", "u1"), out)
+	should.False(NewPropagator("baggage-user-id").propagated("baggage-role"))
+
+	ctx = context.Background()
+	should.Equal(ctx, p.Propagate(ctx))
+
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	should.NoError(err)
+	r.Header.Set("Traceparent", "00-1-2-01")
+	r.Header.Set("Baggage-User-Id", "u1")
+	r.Header.Set("Cookie", "a=b")
+	should.Equal(metadata.Pairs("traceparent", "00-1-2-01", "baggage-user-id", "u1"), p.Annotator(ctx, r))
+
+	ctx = SetBaggage(context.Background(), "User-Id", "u2")
+	should.Equal([]string{"u2"}, GetOutgoingMD(ctx).Get("baggage-user-id"))
+}
+
+func TestMetadataPropagation(t *testing.T) {
+	var should = require.New(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	downstream := grpc.NewServer()
+	pb.RegisterGreeterServiceServer(downstream, &metadataGreeterService{})
+	go func() {
+		_ = downstream.Serve(lis)
+	}()
+	defer downstream.Stop()
+
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithMetadataPropagation(append([]string{"baggage-user-id"}, DefaultPropagationKeys...)...),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+	)
+	conn, err := grpc.Dial(lis.Addr().String(), append(s.PropagationDialOptions(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	should.NoError(err)
+	defer conn.Close()
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &forwardGreeterService{client: pb.NewGreeterServiceClient(conn)})
+
+	addr := startTestServer(t, s)
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/v1/say/daheige", nil)
+	should.NoError(err)
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("X-Tenant-Id", "t1")
+	req.Header.Set("Baggage-User-Id", "u1")
+	req.Header.Set("Authorization", "token")
+	res, err := http.DefaultClient.Do(req)
+	should.NoError(err)
+	var reply map[string]interface{}
+	should.NoError(json.NewDecoder(res.Body).Decode(&reply))
+	res.Body.Close()
+	should.Equal(http.StatusOK, res.StatusCode)
+	should.Equal("abc|t1|u1|", reply["message"])
+
+	// the generated request id of the gateway is propagated too
+	res, err = http.Get("http://" + addr + "/v1/say/daheige")
+	should.NoError(err)
+	should.NoError(json.NewDecoder(res.Body).Decode(&reply))
+	res.Body.Close()
+	should.Regexp(`^[0-9a-f]{32}\|\|\|$`, reply["message"])
+}
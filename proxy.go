@@ -0,0 +1,101 @@
+package gmicro
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies parses the ip addresses and CIDRs of the proxies, the invalid ones are ignored.
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				continue
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		if _, ipNet, err := net.ParseCIDR(p); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+
+	return nets
+}
+
+// trustedProxy reports whether the request is sent by the trusted proxies,
+// so its X-Forwarded-* headers can be trusted.
+func (s *Service) trustedProxy(r *http.Request) bool {
+	return s.trustedIP(remoteIP(r))
+}
+
+// trustedIP reports whether the ip is one of the trusted proxies.
+func (s *Service) trustedIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedClientIP returns the client ip of the request sent by the remote ip, the forwarded
+// addresses are trusted only if the remote ip is a trusted proxy. The X-Forwarded-For is checked
+// from right to left, the first address which is not a trusted proxy is the client ip.
+func (s *Service) forwardedClientIP(remote string, forwardedFor string, realIP string) string {
+	if !s.trustedIP(remote) {
+		return remote
+	}
+
+	var forwarded []string
+	for _, ip := range strings.Split(forwardedFor, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			forwarded = append(forwarded, ip)
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if i == 0 || !s.trustedIP(forwarded[i]) {
+			return forwarded[i]
+		}
+	}
+
+	if realIP != "" {
+		return realIP
+	}
+
+	return remote
+}
+
+// httpClientIP returns the client ip of the http request.
+func (s *Service) httpClientIP(r *http.Request) string {
+	return s.forwardedClientIP(remoteIP(r), strings.Join(r.Header.Values("X-Forwarded-For"), ","),
+		strings.TrimSpace(r.Header.Get("X-Real-Ip")))
+}
+
+// remoteIP returns the ip of the remote address of the request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,45 @@
+package gmicro
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxy(t *testing.T) {
+	s := NewService(WithTrustedProxies("10.0.0.0/8", "192.0.2.1", "::1", "invalid", "10.0.0.0/33"))
+	assert.Len(t, s.trustedProxies, 3)
+
+	for addr, trusted := range map[string]bool{
+		"10.1.2.3:1234":  true,
+		"192.0.2.1:1234": true,
+		"192.0.2.2:1234": false,
+		"[::1]:1234":     true,
+		"invalid":        false,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = addr
+		assert.Equal(t, trusted, s.trustedProxy(r), addr)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+	assert.False(t, NewService().trustedProxy(r))
+
+	// the forwarded addresses of the untrusted clients are ignored
+	r.Header.Set("X-Forwarded-For", "203.0.113.1")
+	r.RemoteAddr = "198.51.100.1:1234"
+	assert.Equal(t, "198.51.100.1", s.httpClientIP(r))
+	assert.Equal(t, "198.51.100.1", GetHTTPClientIP(r))
+
+	// the trusted proxies are skipped from right to left
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.1, 198.51.100.2, 10.0.0.9")
+	assert.Equal(t, "198.51.100.2", s.httpClientIP(r))
+	r.Header.Set("X-Forwarded-For", "10.0.0.8, 10.0.0.9")
+	assert.Equal(t, "10.0.0.8", s.httpClientIP(r))
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("X-Real-Ip", "203.0.113.2")
+	assert.Equal(t, "203.0.113.2", s.httpClientIP(r))
+}
@@ -2,6 +2,8 @@ package gmicro
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -27,3 +29,62 @@ func WithRateLimit(limiter Limiter) grpc.UnaryServerInterceptor {
 		return handler(ctx, req)
 	}
 }
+
+// TokenBucket is a Limiter based on the token bucket algorithm,
+// its rate and burst can be changed at runtime.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // max tokens of the bucket
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a TokenBucket which allows rate requests per second
+// and permits bursts of at most burst requests.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Limit implements Limiter interface.
+func (b *TokenBucket) Limit() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return true
+	}
+
+	b.tokens--
+	return false
+}
+
+// SetRate changes the rate and burst of the bucket.
+func (b *TokenBucket) SetRate(rate float64, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.rate = rate
+	b.burst = float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// refill adds the tokens produced since the last call, b.mu must be held.
+func (b *TokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+
+	b.last = now
+}
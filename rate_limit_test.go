@@ -0,0 +1,24 @@
+package gmicro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(10, 2)
+	assert.False(t, b.Limit())
+	assert.False(t, b.Limit())
+	assert.True(t, b.Limit())
+
+	// 10 tokens per second, one token is added after 100ms
+	time.Sleep(120 * time.Millisecond)
+	assert.False(t, b.Limit())
+	assert.True(t, b.Limit())
+
+	b.SetRate(0, 0)
+	time.Sleep(120 * time.Millisecond)
+	assert.True(t, b.Limit())
+}
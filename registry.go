@@ -0,0 +1,175 @@
+package gmicro
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// the default ttl of the registered service instance
+	defaultRegisterTTL = 15 * time.Second
+	minRegisterTTL     = time.Second
+
+	// the default timeout of registering and deregistering
+	defaultRegisterTimeout = 3 * time.Second
+)
+
+// ErrInstanceNotFound the service instance is not registered or expired.
+var ErrInstanceNotFound = errors.New("service instance not found")
+
+// ServiceInstance is the service instance announced to the Registry.
+type ServiceInstance struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Address  string            `json:"address"` // gRPC address eg: ip:port
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Healthy  bool              `json:"healthy"`
+}
+
+// Registry is the interface of service discovery backend, the Service registers
+// itself after the listeners start and deregisters during Stop.
+// Heartbeat is called every ttl/3 to keep the instance alive, the instance will
+// be expired if no heartbeat is received within ttl.
+type Registry interface {
+	Register(ctx context.Context, ins *ServiceInstance, ttl time.Duration) error
+	Heartbeat(ctx context.Context, ins *ServiceInstance) error
+	Deregister(ctx context.Context, ins *ServiceInstance) error
+}
+
+// registerInstance registers the service instance and keeps it alive by heartbeat.
+func (s *Service) registerInstance() error {
+	if s.registry == nil {
+		return nil
+	}
+
+	if s.serviceName == "" {
+		s.serviceName = filepath.Base(os.Args[0])
+	}
+
+	s.instance = &ServiceInstance{
+		ID:       s.serviceName + "-" + Uuid(),
+		Name:     s.serviceName,
+		Address:  s.advertiseAddress(),
+		Metadata: s.serviceMetadata,
+		Healthy:  true,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRegisterTimeout)
+	defer cancel()
+
+	err := s.registry.Register(ctx, s.instance, s.registerTTL)
+	if err != nil {
+		s.logger.Printf("register service instance %s error: %s\n", s.instance.ID, err.Error())
+		return err
+	}
+
+	s.logger.Printf("Service instance %s registered, address: %s\n", s.instance.ID, s.instance.Address)
+
+	heartbeatCtx, cancelHeartbeat := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer s.recovery()
+		defer close(done)
+
+		s.heartbeat(heartbeatCtx, s.instance)
+	}()
+
+	s.stopHeartbeat = func() {
+		cancelHeartbeat()
+		<-done
+	}
+
+	return nil
+}
+
+// heartbeat keeps the instance alive until ctx is done, the instance will be
+// registered again if the heartbeat fails.
+func (s *Service) heartbeat(ctx context.Context, ins *ServiceInstance) {
+	ticker := time.NewTicker(s.registerTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		hbCtx, cancel := context.WithTimeout(ctx, defaultRegisterTimeout)
+		err := s.registry.Heartbeat(hbCtx, ins)
+		if err != nil {
+			s.logger.Printf("service instance %s heartbeat error: %s\n", ins.ID, err.Error())
+			err = s.registry.Register(hbCtx, ins, s.registerTTL)
+			if err != nil {
+				s.logger.Printf("register service instance %s error: %s\n", ins.ID, err.Error())
+			}
+		}
+
+		cancel()
+	}
+}
+
+// deregisterInstance stops the heartbeat and deregisters the service instance.
+func (s *Service) deregisterInstance() {
+	if s.registry == nil || s.instance == nil {
+		return
+	}
+
+	s.stopHeartbeat()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRegisterTimeout)
+	defer cancel()
+
+	s.instance.Healthy = false
+	err := s.registry.Deregister(ctx, s.instance)
+	if err != nil {
+		s.logger.Printf("deregister service instance %s error: %s\n", s.instance.ID, err.Error())
+		return
+	}
+
+	s.logger.Printf("Service instance %s deregistered\n", s.instance.ID)
+	s.instance = nil
+}
+
+// advertiseAddress returns the gRPC address announced to the registry,
+// the host is detected from the network interfaces if it is not specified.
+func (s *Service) advertiseAddress() string {
+	_, port, _ := net.SplitHostPort(s.gRPCAddress)
+	host := s.advertiseHost
+	if host == "" {
+		host = localIP()
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// localIP returns the first non-loopback IPv4 address of the host.
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				return ipNet.IP.String()
+			}
+		}
+	}
+
+	return "127.0.0.1"
+}
+
+// splitPort returns the host and the int port of address.
+func splitPort(address string) (string, int, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+
+	p, err := strconv.Atoi(port)
+	return host, p, err
+}
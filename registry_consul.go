@@ -0,0 +1,168 @@
+package gmicro
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+)
+
+// the consul check status
+const (
+	consulCheckPassing  = "passing"
+	consulCheckCritical = "critical"
+)
+
+// ConsulRegistration is the service registration of consul agent,
+// its fields are the same as api.AgentServiceRegistration and api.AgentServiceCheck.
+// Status is the aggregated check status returned by the health api.
+type ConsulRegistration struct {
+	ID                             string
+	Name                           string
+	Address                        string
+	Port                           int
+	Meta                           map[string]string
+	CheckID                        string
+	TTL                            string
+	DeregisterCriticalServiceAfter string
+	Status                         string
+}
+
+// ConsulAgent is the subset of the consul agent api used by ConsulRegistry,
+// it can be implemented by a thin wrapper of github.com/hashicorp/consul/api.Agent.
+type ConsulAgent interface {
+	ServiceRegister(reg *ConsulRegistration) error
+	ServiceDeregister(serviceID string) error
+	UpdateTTL(checkID, output, status string) error
+
+	// HealthService returns the registrations of the service and the consul index,
+	// it blocks until the index is greater than waitIndex or ctx is done,
+	// just like the blocking query of api.Health.Service.
+	HealthService(ctx context.Context, name string, waitIndex uint64) ([]*ConsulRegistration, uint64, error)
+}
+
+// ConsulRegistry is a Registry which registers the instances to consul agent
+// with a TTL check, the heartbeat updates the check status by the instance health.
+type ConsulRegistry struct {
+	agent ConsulAgent
+}
+
+var (
+	_ Registry  = (*ConsulRegistry)(nil)
+	_ Discovery = (*ConsulRegistry)(nil)
+)
+
+// NewConsulRegistry returns a ConsulRegistry.
+func NewConsulRegistry(agent ConsulAgent) *ConsulRegistry {
+	return &ConsulRegistry{agent: agent}
+}
+
+// Register implements Registry interface.
+func (r *ConsulRegistry) Register(_ context.Context, ins *ServiceInstance, ttl time.Duration) error {
+	host, port, err := splitPort(ins.Address)
+	if err != nil {
+		return err
+	}
+
+	err = r.agent.ServiceRegister(&ConsulRegistration{
+		ID:      ins.ID,
+		Name:    ins.Name,
+		Address: host,
+		Port:    port,
+		Meta:    ins.Metadata,
+		CheckID: r.checkID(ins),
+		TTL:     ttl.String(),
+		// the critical instance will be removed by consul if it never comes back
+		DeregisterCriticalServiceAfter: (10 * ttl).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.updateTTL(ins)
+}
+
+// Heartbeat implements Registry interface.
+func (r *ConsulRegistry) Heartbeat(_ context.Context, ins *ServiceInstance) error {
+	return r.updateTTL(ins)
+}
+
+// Deregister implements Registry interface.
+func (r *ConsulRegistry) Deregister(_ context.Context, ins *ServiceInstance) error {
+	return r.agent.ServiceDeregister(ins.ID)
+}
+
+// GetService implements Discovery interface.
+func (r *ConsulRegistry) GetService(ctx context.Context, name string) ([]*ServiceInstance, error) {
+	regs, _, err := r.agent.HealthService(ctx, name, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*ServiceInstance, 0, len(regs))
+	for _, reg := range regs {
+		list = append(list, &ServiceInstance{
+			ID:       reg.ID,
+			Name:     reg.Name,
+			Address:  net.JoinHostPort(reg.Address, strconv.Itoa(reg.Port)),
+			Metadata: reg.Meta,
+			Healthy:  reg.Status == consulCheckPassing,
+		})
+	}
+
+	return list, nil
+}
+
+// Watch implements Discovery interface, the instances are looked up when
+// the blocking query of consul returns a new index.
+func (r *ConsulRegistry) Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error) {
+	notify := make(chan struct{}, 1)
+	go func() {
+		// wait for a while before the next blocking query
+		backoff := func() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(defaultWatchInterval):
+			}
+		}
+
+		var index uint64
+		for ctx.Err() == nil {
+			_, newIndex, err := r.agent.HealthService(ctx, name, index)
+			if err != nil {
+				backoff()
+				continue
+			}
+
+			switch {
+			case newIndex < index:
+				// the index of consul is reset, query from the beginning
+				index = 0
+			case newIndex == index:
+				// the query returns without change, eg: the wait time is reached,
+				// back off so that the loop never spins on the same index
+				backoff()
+			default:
+				index = newIndex
+				select {
+				case notify <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return watchService(ctx, name, r.GetService, notify, defaultWatchInterval), nil
+}
+
+func (r *ConsulRegistry) updateTTL(ins *ServiceInstance) error {
+	if ins.Healthy {
+		return r.agent.UpdateTTL(r.checkID(ins), "", consulCheckPassing)
+	}
+
+	return r.agent.UpdateTTL(r.checkID(ins), "", consulCheckCritical)
+}
+
+func (r *ConsulRegistry) checkID(ins *ServiceInstance) string {
+	return "service:" + ins.ID
+}
@@ -0,0 +1,155 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultEtcdPrefix is the default key prefix of the instances registered to etcd.
+const DefaultEtcdPrefix = "/gmicro/services/"
+
+// EtcdClient is the subset of the etcd v3 api used by EtcdRegistry,
+// it can be implemented by a thin wrapper of go.etcd.io/etcd/client/v3.Client, eg:
+//
+//	func (c *etcdClient) Grant(ctx context.Context, ttl int64) (int64, error) {
+//		resp, err := c.Client.Grant(ctx, ttl)
+//		if err != nil {
+//			return 0, err
+//		}
+//
+//		return int64(resp.ID), nil
+//	}
+type EtcdClient interface {
+	Grant(ctx context.Context, ttl int64) (leaseID int64, err error)
+	KeepAliveOnce(ctx context.Context, leaseID int64) error
+	Revoke(ctx context.Context, leaseID int64) error
+	Put(ctx context.Context, key, val string, leaseID int64) error
+	Delete(ctx context.Context, key string) error
+
+	// GetPrefix returns the values of the keys with prefix.
+	GetPrefix(ctx context.Context, prefix string) ([]string, error)
+
+	// WatchPrefix returns a channel which fires when the keys with prefix change,
+	// the channel is closed when ctx is done.
+	WatchPrefix(ctx context.Context, prefix string) <-chan struct{}
+}
+
+// EtcdRegistry is a Registry which stores the instances in etcd,
+// the instance key is prefix + name + "/" + id and bound to a lease of ttl.
+type EtcdRegistry struct {
+	client EtcdClient
+	prefix string
+
+	mu     sync.Mutex
+	leases map[string]int64 // instance id -> lease id
+}
+
+var (
+	_ Registry  = (*EtcdRegistry)(nil)
+	_ Discovery = (*EtcdRegistry)(nil)
+)
+
+// NewEtcdRegistry returns an EtcdRegistry, the DefaultEtcdPrefix is used if prefix is empty.
+func NewEtcdRegistry(client EtcdClient, prefix string) *EtcdRegistry {
+	if prefix == "" {
+		prefix = DefaultEtcdPrefix
+	}
+
+	return &EtcdRegistry{client: client, prefix: prefix, leases: make(map[string]int64)}
+}
+
+// Register implements Registry interface.
+func (r *EtcdRegistry) Register(ctx context.Context, ins *ServiceInstance, ttl time.Duration) error {
+	b, err := json.Marshal(ins)
+	if err != nil {
+		return err
+	}
+
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	leaseID, err := r.client.Grant(ctx, seconds)
+	if err != nil {
+		return err
+	}
+
+	err = r.client.Put(ctx, r.key(ins), string(b), leaseID)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.leases[ins.ID] = leaseID
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Heartbeat implements Registry interface.
+func (r *EtcdRegistry) Heartbeat(ctx context.Context, ins *ServiceInstance) error {
+	r.mu.Lock()
+	leaseID, ok := r.leases[ins.ID]
+	r.mu.Unlock()
+	if !ok {
+		return ErrInstanceNotFound
+	}
+
+	return r.client.KeepAliveOnce(ctx, leaseID)
+}
+
+// Deregister implements Registry interface.
+func (r *EtcdRegistry) Deregister(ctx context.Context, ins *ServiceInstance) error {
+	r.mu.Lock()
+	leaseID, ok := r.leases[ins.ID]
+	delete(r.leases, ins.ID)
+	r.mu.Unlock()
+
+	err := r.client.Delete(ctx, r.key(ins))
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		return r.client.Revoke(ctx, leaseID)
+	}
+
+	return nil
+}
+
+// GetService implements Discovery interface.
+func (r *EtcdRegistry) GetService(ctx context.Context, name string) ([]*ServiceInstance, error) {
+	vals, err := r.client.GetPrefix(ctx, r.servicePrefix(name))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*ServiceInstance, 0, len(vals))
+	for _, val := range vals {
+		ins := &ServiceInstance{}
+		if err = json.Unmarshal([]byte(val), ins); err != nil {
+			return nil, err
+		}
+
+		list = append(list, ins)
+	}
+
+	return list, nil
+}
+
+// Watch implements Discovery interface, the instances are looked up when the keys change.
+func (r *EtcdRegistry) Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error) {
+	notify := r.client.WatchPrefix(ctx, r.servicePrefix(name))
+	return watchService(ctx, name, r.GetService, notify, defaultWatchInterval), nil
+}
+
+func (r *EtcdRegistry) key(ins *ServiceInstance) string {
+	return r.servicePrefix(ins.Name) + ins.ID
+}
+
+func (r *EtcdRegistry) servicePrefix(name string) string {
+	return r.prefix + name + "/"
+}
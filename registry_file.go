@@ -0,0 +1,134 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileRegistry is a Registry which stores the instances in a json file,
+// the services on the same host can discover each other by sharing the file.
+// The file is rewritten atomically, but it is not locked between processes,
+// so it is not suitable for the services registering frequently.
+type FileRegistry struct {
+	mu   sync.Mutex
+	path string
+}
+
+var (
+	_ Registry  = (*FileRegistry)(nil)
+	_ Discovery = (*FileRegistry)(nil)
+)
+
+// NewFileRegistry returns a FileRegistry which stores the instances in path.
+func NewFileRegistry(path string) *FileRegistry {
+	return &FileRegistry{path: path}
+}
+
+// Register implements Registry interface.
+func (r *FileRegistry) Register(_ context.Context, ins *ServiceInstance, ttl time.Duration) error {
+	return r.update(func(t registryTable) error {
+		t.register(ins, ttl)
+		return nil
+	})
+}
+
+// Heartbeat implements Registry interface.
+func (r *FileRegistry) Heartbeat(_ context.Context, ins *ServiceInstance) error {
+	return r.update(func(t registryTable) error {
+		return t.heartbeat(ins)
+	})
+}
+
+// Deregister implements Registry interface.
+func (r *FileRegistry) Deregister(_ context.Context, ins *ServiceInstance) error {
+	return r.update(func(t registryTable) error {
+		t.deregister(ins)
+		return nil
+	})
+}
+
+// GetService returns the alive instances of the service.
+func (r *FileRegistry) GetService(_ context.Context, name string) ([]*ServiceInstance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.instances(name), nil
+}
+
+// Watch implements Discovery interface, the file is checked every defaultWatchInterval.
+func (r *FileRegistry) Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error) {
+	return watchService(ctx, name, r.GetService, nil, defaultWatchInterval), nil
+}
+
+// update loads the table from file, applies fn and saves the table back.
+func (r *FileRegistry) update(fn func(t registryTable) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	err = fn(t)
+	if err != nil {
+		return err
+	}
+
+	return r.save(t)
+}
+
+func (r *FileRegistry) load() (registryTable, error) {
+	t := make(registryTable)
+	b, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return t, nil
+	}
+
+	err = json.Unmarshal(b, &t)
+	return t, err
+}
+
+func (r *FileRegistry) save(t registryTable) error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename it, so that the readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), r.path)
+}
@@ -0,0 +1,153 @@
+package gmicro
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// registryEntry is the registered instance with its expiration.
+type registryEntry struct {
+	Instance *ServiceInstance `json:"instance"`
+	TTL      time.Duration    `json:"ttl"`
+	ExpireAt time.Time        `json:"expire_at"`
+}
+
+// registryTable stores the registry entries by service name and instance id.
+type registryTable map[string]map[string]*registryEntry
+
+func (t registryTable) register(ins *ServiceInstance, ttl time.Duration) {
+	entries, ok := t[ins.Name]
+	if !ok {
+		entries = make(map[string]*registryEntry)
+		t[ins.Name] = entries
+	}
+
+	cp := *ins
+	entries[ins.ID] = &registryEntry{Instance: &cp, TTL: ttl, ExpireAt: time.Now().Add(ttl)}
+}
+
+func (t registryTable) heartbeat(ins *ServiceInstance) error {
+	entry, ok := t[ins.Name][ins.ID]
+	if !ok || time.Now().After(entry.ExpireAt) {
+		return ErrInstanceNotFound
+	}
+
+	entry.Instance.Healthy = ins.Healthy
+	entry.ExpireAt = time.Now().Add(entry.TTL)
+	return nil
+}
+
+func (t registryTable) deregister(ins *ServiceInstance) {
+	delete(t[ins.Name], ins.ID)
+	if len(t[ins.Name]) == 0 {
+		delete(t, ins.Name)
+	}
+}
+
+// instances returns the copies of the instances which are not expired.
+func (t registryTable) instances(name string) []*ServiceInstance {
+	now := time.Now()
+	list := make([]*ServiceInstance, 0, len(t[name]))
+	for _, entry := range t[name] {
+		if now.After(entry.ExpireAt) {
+			continue
+		}
+
+		cp := *entry.Instance
+		list = append(list, &cp)
+	}
+
+	return list
+}
+
+// MemoryRegistry is a Registry which stores the instances in memory,
+// it is useful for tests and the services running in one process.
+type MemoryRegistry struct {
+	mu       sync.RWMutex
+	table    registryTable
+	watchers map[chan struct{}]struct{} // notified when the instances change
+}
+
+var (
+	_ Registry  = (*MemoryRegistry)(nil)
+	_ Discovery = (*MemoryRegistry)(nil)
+)
+
+// NewMemoryRegistry returns an empty MemoryRegistry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		table:    make(registryTable),
+		watchers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Register implements Registry interface.
+func (r *MemoryRegistry) Register(_ context.Context, ins *ServiceInstance, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.table.register(ins, ttl)
+	r.notifyLocked()
+	return nil
+}
+
+// Heartbeat implements Registry interface.
+func (r *MemoryRegistry) Heartbeat(_ context.Context, ins *ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	healthy := r.table[ins.Name][ins.ID] != nil && r.table[ins.Name][ins.ID].Instance.Healthy
+	err := r.table.heartbeat(ins)
+	if err == nil && healthy != ins.Healthy {
+		r.notifyLocked()
+	}
+
+	return err
+}
+
+// Deregister implements Registry interface.
+func (r *MemoryRegistry) Deregister(_ context.Context, ins *ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.table.deregister(ins)
+	r.notifyLocked()
+	return nil
+}
+
+// GetService returns the alive instances of the service.
+func (r *MemoryRegistry) GetService(_ context.Context, name string) ([]*ServiceInstance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.table.instances(name), nil
+}
+
+// Watch implements Discovery interface, the watchers are notified when the instances
+// change, and the expired instances are removed within defaultWatchInterval.
+func (r *MemoryRegistry) Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error) {
+	notify := make(chan struct{}, 1)
+	r.mu.Lock()
+	r.watchers[notify] = struct{}{}
+	r.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		delete(r.watchers, notify)
+		r.mu.Unlock()
+	}()
+
+	return watchService(ctx, name, r.GetService, notify, defaultWatchInterval), nil
+}
+
+// notifyLocked notifies all the watchers without blocking, r.mu must be held.
+func (r *MemoryRegistry) notifyLocked() {
+	for notify := range r.watchers {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package gmicro
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type lookupRegistry interface {
+	Registry
+	GetService(ctx context.Context, name string) ([]*ServiceInstance, error)
+}
+
+func testLookupRegistry(t *testing.T, r lookupRegistry) {
+	var should = require.New(t)
+	ctx := context.Background()
+
+	ins := &ServiceInstance{ID: "hello-1", Name: "hello", Address: "127.0.0.1:50051", Healthy: true}
+	should.NoError(r.Register(ctx, ins, 200*time.Millisecond))
+	should.NoError(r.Register(ctx, &ServiceInstance{ID: "world-1", Name: "world"}, time.Second))
+
+	list, err := r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.Len(list, 1)
+	should.Equal(*ins, *list[0])
+
+	// heartbeat keeps the instance alive
+	time.Sleep(120 * time.Millisecond)
+	should.NoError(r.Heartbeat(ctx, ins))
+	time.Sleep(120 * time.Millisecond)
+	list, err = r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.Len(list, 1)
+
+	// the instance is expired without heartbeat
+	time.Sleep(250 * time.Millisecond)
+	list, err = r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.Len(list, 0)
+	should.ErrorIs(r.Heartbeat(ctx, ins), ErrInstanceNotFound)
+
+	should.NoError(r.Deregister(ctx, &ServiceInstance{ID: "world-1", Name: "world"}))
+	list, err = r.GetService(ctx, "world")
+	should.NoError(err)
+	should.Len(list, 0)
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	testLookupRegistry(t, NewMemoryRegistry())
+}
+
+func TestFileRegistry(t *testing.T) {
+	testLookupRegistry(t, NewFileRegistry(filepath.Join(t.TempDir(), "registry.json")))
+
+	// the registries sharing a file can discover each other
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r1 := NewFileRegistry(path)
+	r2 := NewFileRegistry(path)
+	ins := &ServiceInstance{ID: "hello-1", Name: "hello", Address: "127.0.0.1:50051"}
+	require.NoError(t, r1.Register(context.Background(), ins, time.Minute))
+	list, err := r2.GetService(context.Background(), "hello")
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+// fakeEtcdClient is an in-memory EtcdClient.
+type fakeEtcdClient struct {
+	mu      sync.Mutex
+	nextID  int64
+	leases  map[int64]int64 // lease id -> ttl
+	kvs     map[string]string
+	keys    map[string]int64 // key -> lease id
+	renewed map[int64]int
+}
+
+func newFakeEtcdClient() *fakeEtcdClient {
+	return &fakeEtcdClient{
+		leases:  make(map[int64]int64),
+		kvs:     make(map[string]string),
+		keys:    make(map[string]int64),
+		renewed: make(map[int64]int),
+	}
+}
+
+func (c *fakeEtcdClient) Grant(_ context.Context, ttl int64) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	c.leases[c.nextID] = ttl
+	return c.nextID, nil
+}
+
+func (c *fakeEtcdClient) KeepAliveOnce(_ context.Context, leaseID int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.leases[leaseID]; !ok {
+		return errors.New("lease not found")
+	}
+
+	c.renewed[leaseID]++
+	return nil
+}
+
+func (c *fakeEtcdClient) Revoke(_ context.Context, leaseID int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.leases, leaseID)
+	for key, id := range c.keys {
+		if id == leaseID {
+			delete(c.keys, key)
+			delete(c.kvs, key)
+		}
+	}
+
+	return nil
+}
+
+func (c *fakeEtcdClient) Put(_ context.Context, key, val string, leaseID int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.kvs[key] = val
+	c.keys[key] = leaseID
+	return nil
+}
+
+func (c *fakeEtcdClient) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.kvs, key)
+	delete(c.keys, key)
+	return nil
+}
+
+func (c *fakeEtcdClient) GetPrefix(_ context.Context, prefix string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var vals []string
+	for key, val := range c.kvs {
+		if strings.HasPrefix(key, prefix) {
+			vals = append(vals, val)
+		}
+	}
+
+	sort.Strings(vals)
+	return vals, nil
+}
+
+func (c *fakeEtcdClient) WatchPrefix(ctx context.Context, _ string) <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+
+	return ch
+}
+
+func TestEtcdRegistry(t *testing.T) {
+	var should = require.New(t)
+	ctx := context.Background()
+
+	client := newFakeEtcdClient()
+	r := NewEtcdRegistry(client, "")
+	ins := &ServiceInstance{ID: "hello-1", Name: "hello", Address: "127.0.0.1:50051", Healthy: true}
+	should.ErrorIs(r.Heartbeat(ctx, ins), ErrInstanceNotFound)
+
+	should.NoError(r.Register(ctx, ins, 10*time.Second))
+	should.Contains(client.kvs["/gmicro/services/hello/hello-1"], `"address":"127.0.0.1:50051"`)
+	should.Equal(int64(10), client.leases[client.keys["/gmicro/services/hello/hello-1"]])
+
+	should.NoError(r.Heartbeat(ctx, ins))
+	should.Equal(1, client.renewed[1])
+
+	list, err := r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.Len(list, 1)
+	should.Equal(*ins, *list[0])
+
+	should.NoError(r.Deregister(ctx, ins))
+	should.Len(client.kvs, 0)
+	should.Len(client.leases, 0)
+}
+
+// fakeConsulAgent is an in-memory ConsulAgent, the index starts from 1 like consul.
+type fakeConsulAgent struct {
+	mu       sync.Mutex
+	index    uint64
+	services map[string]*ConsulRegistration
+	checks   map[string]string
+}
+
+func (a *fakeConsulAgent) ServiceRegister(reg *ConsulRegistration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.index++
+	a.services[reg.ID] = reg
+	return nil
+}
+
+func (a *fakeConsulAgent) ServiceDeregister(serviceID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.index++
+	delete(a.services, serviceID)
+	delete(a.checks, "service:"+serviceID)
+	return nil
+}
+
+func (a *fakeConsulAgent) UpdateTTL(checkID, _, status string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.index++
+	a.checks[checkID] = status
+	return nil
+}
+
+func (a *fakeConsulAgent) HealthService(ctx context.Context, name string,
+	waitIndex uint64) ([]*ConsulRegistration, uint64, error) {
+	for {
+		a.mu.Lock()
+		if waitIndex == 0 || a.index > waitIndex {
+			var regs []*ConsulRegistration
+			for id, reg := range a.services {
+				if reg.Name == name {
+					cp := *reg
+					cp.Status = a.checks["service:"+id]
+					regs = append(regs, &cp)
+				}
+			}
+
+			index := a.index
+			a.mu.Unlock()
+			return regs, index, nil
+		}
+
+		a.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestConsulRegistry(t *testing.T) {
+	var should = require.New(t)
+	ctx := context.Background()
+
+	agent := &fakeConsulAgent{index: 1, services: map[string]*ConsulRegistration{}, checks: map[string]string{}}
+	r := NewConsulRegistry(agent)
+	ins := &ServiceInstance{
+		ID: "hello-1", Name: "hello", Address: "127.0.0.1:50051",
+		Metadata: map[string]string{"version": "v1"}, Healthy: true,
+	}
+
+	should.NoError(r.Register(ctx, ins, 10*time.Second))
+	reg := agent.services["hello-1"]
+	should.Equal("127.0.0.1", reg.Address)
+	should.Equal(50051, reg.Port)
+	should.Equal("10s", reg.TTL)
+	should.Equal("v1", reg.Meta["version"])
+	should.Equal(consulCheckPassing, agent.checks["service:hello-1"])
+
+	list, err := r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.Len(list, 1)
+	should.Equal(*ins, *list[0])
+
+	ins.Healthy = false
+	should.NoError(r.Heartbeat(ctx, ins))
+	should.Equal(consulCheckCritical, agent.checks["service:hello-1"])
+	list, err = r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.False(list[0].Healthy)
+
+	should.NoError(r.Deregister(ctx, ins))
+	should.Len(agent.services, 0)
+
+	should.Error(r.Register(ctx, &ServiceInstance{ID: "hello-2", Address: "127.0.0.1"}, time.Second))
+}
+
+func TestServiceRegistry(t *testing.T) {
+	var should = require.New(t)
+	ctx := context.Background()
+
+	r := NewMemoryRegistry()
+	s := NewServiceWithoutGateway(
+		WithPreShutdownDelay(0),
+		WithRegistry(r),
+		WithServiceName("hello"),
+		WithServiceMetadata(map[string]string{"version": "v1"}),
+		WithRegisterTTL(300*time.Millisecond),
+		WithAdvertiseHost("127.0.0.1"),
+	)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.StartGRPCWithoutGateway(29994)
+	}()
+
+	<-s.Started()
+	list, err := r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.Len(list, 1)
+	should.Equal("127.0.0.1:29994", list[0].Address)
+	should.Equal("v1", list[0].Metadata["version"])
+
+	// the heartbeat keeps the instance alive
+	time.Sleep(500 * time.Millisecond)
+	list, err = r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.Len(list, 1)
+
+	s.StopGRPCWithoutGateway()
+	should.NoError(<-errChan)
+
+	list, err = r.GetService(ctx, "hello")
+	should.NoError(err)
+	should.Len(list, 0)
+}
+
+func TestConsulRegistryWatch(t *testing.T) {
+	var should = require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	agent := &fakeConsulAgent{index: 1, services: map[string]*ConsulRegistration{}, checks: map[string]string{}}
+	r := NewConsulRegistry(agent)
+	ch, err := r.Watch(ctx, "hello")
+	should.NoError(err)
+	should.Len(<-ch, 0)
+
+	ins := &ServiceInstance{ID: "hello-1", Name: "hello", Address: "127.0.0.1:50051", Healthy: true}
+	should.NoError(r.Register(ctx, ins, 10*time.Second))
+	// the check may be passed after the service is registered
+	should.Eventually(func() bool {
+		list := <-ch
+		return len(list) == 1 && list[0].Healthy
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	for range ch {
+	}
+}
+
+// staleConsulAgent returns the same index without blocking.
+type staleConsulAgent struct {
+	fakeConsulAgent
+	blockingQueries int64
+}
+
+func (a *staleConsulAgent) HealthService(_ context.Context, _ string, waitIndex uint64) ([]*ConsulRegistration, uint64, error) {
+	if waitIndex > 0 {
+		atomic.AddInt64(&a.blockingQueries, 1)
+	}
+
+	return nil, 1, nil
+}
+
+func TestConsulRegistryWatchBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	agent := &staleConsulAgent{}
+	ch, err := NewConsulRegistry(agent).Watch(ctx, "hello")
+	require.NoError(t, err)
+	for range ch {
+	}
+
+	// the blocking query returns the same index and backs off
+	assert.Equal(t, int64(1), atomic.LoadInt64(&agent.blockingQueries))
+}
+
+func TestWithRegisterTTL(t *testing.T) {
+	assert.Equal(t, defaultRegisterTTL, NewService(WithRegisterTTL(0)).registerTTL)
+	assert.Equal(t, defaultRegisterTTL, NewService(WithRegisterTTL(time.Nanosecond)).registerTTL)
+	assert.Equal(t, 3*time.Second, NewService(WithRegisterTTL(3*time.Second)).registerTTL)
+}
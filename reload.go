@@ -0,0 +1,173 @@
+package gmicro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the log levels of Config.LogLevel
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+)
+
+// ErrNoConfigSource the service has no config source to reload from.
+var ErrNoConfigSource = errors.New("no config source to reload")
+
+// ConfigSource returns the latest config when the service reloads, eg:
+//
+//	func() (*gmicro.Config, error) {
+//		return gmicro.LoadConfig("app.yaml")
+//	}
+type ConfigSource func() (*Config, error)
+
+// ReloadFunc is notified with the new config when the service reloads,
+// the listeners will not be restarted.
+type ReloadFunc func(c *Config) error
+
+// reloadableSettings holds the settings which can be changed by reloading config,
+// the CORS origins and the tenant policies are reloaded by their own handlers.
+type reloadableSettings struct {
+	mu             sync.RWMutex
+	limiter        *TokenBucket // nil when rate limit is disabled
+	methodTimeouts map[string]time.Duration
+	debug          bool // log the access of every unary RPC
+
+	configured bool                        // the settings are set by Config.Options
+	accessLog  grpc.UnaryServerInterceptor // logs the access when debug is true
+}
+
+// apply updates the settings from config.
+func (r *reloadableSettings) apply(c *Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case c.RateLimit.Rate <= 0:
+		r.limiter = nil
+	case r.limiter == nil:
+		r.limiter = NewTokenBucket(c.RateLimit.Rate, c.RateLimit.Burst)
+	default:
+		r.limiter.SetRate(c.RateLimit.Rate, c.RateLimit.Burst)
+	}
+
+	r.methodTimeouts = make(map[string]time.Duration, len(c.MethodTimeouts))
+	for method, timeout := range c.MethodTimeouts {
+		r.methodTimeouts[method] = timeout.Duration()
+	}
+
+	r.debug = c.LogLevel == logLevelDebug
+}
+
+// unaryInterceptor applies the rate limit, the per-method timeout and the debug access log.
+func (r *reloadableSettings) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	r.mu.RLock()
+	limiter := r.limiter
+	timeout := r.methodTimeouts[info.FullMethod]
+	debug := r.debug
+	r.mu.RUnlock()
+
+	if limiter != nil && limiter.Limit() {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"%s is rejected by rate_limit,please retry later.", info.FullMethod)
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if debug && r.accessLog != nil {
+		return r.accessLog(ctx, req, info, handler)
+	}
+
+	return handler(ctx, req)
+}
+
+// withReloadableConfig sets the settings of c which can be reloaded.
+func withReloadableConfig(c *Config) Option {
+	return func(s *Service) {
+		s.reloadable.apply(c)
+		s.reloadable.configured = true
+	}
+}
+
+// installReloadable installs the interceptor of the reloadable settings
+// when the service is created by Config.Options or it has a config source.
+func (s *Service) installReloadable() {
+	if !s.reloadable.configured && s.configSource == nil {
+		return
+	}
+
+	// the access is logged by RequestInterceptor already when request access is enabled
+	if !s.enableRequestAccess {
+		s.reloadable.accessLog = s.RequestInterceptor
+	}
+
+	s.unaryInterceptors = append(s.unaryInterceptors, s.reloadable.unaryInterceptor)
+}
+
+// AddReloadFunc adds some functions to be notified when the service reloads.
+func (s *Service) AddReloadFunc(f ...ReloadFunc) {
+	s.reloadFuncs = append(s.reloadFuncs, f...)
+}
+
+// Reload loads the config from the config source, then updates the reloadable settings
+// and notifies the reload functions, the listeners are kept running.
+// It is called when the service receives a reload signal, SIGHUP by default.
+//
+// The log level, rate limit and method timeouts are reloaded, the CORS origins are reloaded
+// if CORS is enabled and the config has cors.allowed_origins, and the required flag and the
+// policies of the tenants are reloaded if tenancy is enabled and the config has a tenant resolver.
+// The config which does not pass Config.Validate is not applied, and its error is returned.
+func (s *Service) Reload() error {
+	if s.configSource == nil {
+		s.logger.Printf("Reload config error: %s\n", ErrNoConfigSource.Error())
+		return ErrNoConfigSource
+	}
+
+	c, err := s.configSource()
+	if err == nil {
+		// the invalid config is not applied, the current settings are kept
+		err = c.Validate()
+	}
+
+	if err != nil {
+		s.logger.Printf("Reload config error: %s\n", err.Error())
+		return err
+	}
+
+	s.reloadable.apply(c)
+	if s.cors != nil && len(c.CORS.AllowedOrigins) > 0 {
+		s.cors.setOrigins(c.CORS.AllowedOrigins)
+	}
+
+	if s.tenancy != nil && c.Tenancy.Enabled() {
+		s.tenancy.setPolicies(c.Tenancy.tenancy())
+	}
+
+	var errs []string
+	for _, f := range s.reloadFuncs {
+		if e := f(c); e != nil {
+			errs = append(errs, e.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		err = fmt.Errorf("reload func error: %v", errs)
+		s.logger.Printf("Reload config error: %s\n", err.Error())
+		return err
+	}
+
+	s.logger.Printf("Reload config success\n")
+	return nil
+}
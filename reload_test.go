@@ -0,0 +1,217 @@
+package gmicro
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReload(t *testing.T) {
+	var should = require.New(t)
+
+	c := DefaultConfig()
+	c.RateLimit = RateLimitConf{Rate: 1, Burst: 1}
+
+	newConfig := DefaultConfig()
+	newConfig.MethodTimeouts = map[string]Duration{"/test/Timeout": Duration(time.Second)}
+
+	var notified *Config
+	opts, err := c.Options()
+	should.NoError(err)
+	s := NewService(append(opts,
+		WithConfigSource(func() (*Config, error) {
+			return newConfig, nil
+		}),
+		WithReloadFunc(func(c *Config) error {
+			notified = c
+			return nil
+		}),
+	)...)
+
+	interceptor := s.reloadable.unaryInterceptor
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, ok := ctx.Deadline()
+		return ok, nil
+	}
+
+	// rate limit with burst 1
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Timeout"}, handler)
+	should.NoError(err)
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Timeout"}, handler)
+	should.Equal(codes.ResourceExhausted, status.Code(err))
+
+	should.NoError(s.Reload())
+	should.Equal(newConfig, notified)
+
+	// rate limit is disabled and the method timeout is applied
+	for i := 0; i < 3; i++ {
+		hasDeadline, e := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Timeout"}, handler)
+		should.NoError(e)
+		should.Equal(true, hasDeadline)
+	}
+
+	hasDeadline, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Other"}, handler)
+	should.NoError(err)
+	should.Equal(false, hasDeadline)
+}
+
+func TestReloadPolicies(t *testing.T) {
+	var should = require.New(t)
+
+	c := DefaultConfig()
+	c.CORS.AllowedOrigins = []string{"https://a.example.com"}
+	c.Tenancy = TenancyConf{Header: "X-Tenant-Id", Tenants: map[string]TenantConf{
+		"acme": {RateLimit: RateLimitConf{Rate: 0.001, Burst: 1}},
+	}}
+
+	newConfig := DefaultConfig()
+	newConfig.LogLevel = "debug"
+	newConfig.CORS.AllowedOrigins = []string{"https://b.example.com"}
+	newConfig.Tenancy = TenancyConf{Header: "X-Tenant-Id", Tenants: map[string]TenantConf{
+		"acme": {AllowMethods: []string{"/test/Allowed"}},
+		"beta": {},
+	}}
+
+	opts, err := c.Options()
+	should.NoError(err)
+	logger := &bufferLogger{}
+	s := NewService(append(opts, WithLogger(logger), WithTenancy(Tenancy{
+		Resolvers: []TenantResolver{TenantFromHeader("X-Tenant-Id")},
+		Tenants:   map[string]TenantPolicy{"acme": {RateLimit: RateLimitConf{Rate: 0.001, Burst: 1}}},
+		Default: TenantPolicy{Authorize: func(ctx context.Context, tenant string, method string) error {
+			return errors.New("denied")
+		}},
+	}), WithConfigSource(func() (*Config, error) {
+		return newConfig, nil
+	}))...)
+
+	should.NoError(s.tenancy.authorize(context.Background(), "acme", "/test/Other"))
+	should.Error(s.tenancy.authorize(context.Background(), "acme", "/test/Other"))
+	should.ErrorIs(s.tenancy.check("beta"), PermissionDenied(ReasonUnknownTenant, ""))
+	should.True(s.cors.allowedOrigins().allowed("https://a.example.com"))
+
+	should.NoError(s.Reload())
+
+	// the rate limit of acme is removed and its allowed methods are applied
+	should.NoError(s.tenancy.authorize(context.Background(), "acme", "/test/Allowed"))
+	should.NoError(s.tenancy.authorize(context.Background(), "acme", "/test/Allowed"))
+	should.ErrorIs(s.tenancy.authorize(context.Background(), "acme", "/test/Other"),
+		PermissionDenied(ReasonTenantForbidden, ""))
+	should.NoError(s.tenancy.check("beta"))
+	should.Empty(s.tenancy.limiters)
+
+	// the Authorize of the default policy set by WithTenancy is kept
+	should.EqualError(s.tenancy.authorize(context.Background(), "gamma", "/test/Allowed"), "denied")
+
+	should.False(s.cors.allowedOrigins().allowed("https://a.example.com"))
+	should.True(s.cors.allowedOrigins().allowed("https://b.example.com"))
+
+	// the debug level logs the access
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	_, err = s.reloadable.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test/Debug"}, handler)
+	should.NoError(err)
+	should.True(logger.contains("exec begin,method:/test/Debug"))
+}
+
+func TestReloadConfigSource(t *testing.T) {
+	var should = require.New(t)
+
+	// the reloadable interceptor is installed with the config source only
+	c := DefaultConfig()
+	s := NewService(WithConfigSource(func() (*Config, error) {
+		return c, nil
+	}))
+	should.Len(s.unaryInterceptors, len(NewService().unaryInterceptors)+1)
+	interceptor := s.unaryInterceptors[len(s.unaryInterceptors)-1]
+
+	c.RateLimit = RateLimitConf{Rate: 0.001, Burst: 1}
+	should.NoError(s.Reload())
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test/Limit"}
+	_, err := interceptor(context.Background(), nil, info, handler)
+	should.NoError(err)
+	_, err = interceptor(context.Background(), nil, info, handler)
+	should.Equal(codes.ResourceExhausted, status.Code(err))
+}
+
+func TestReloadError(t *testing.T) {
+	s := NewService()
+	assert.ErrorIs(t, s.Reload(), ErrNoConfigSource)
+
+	sourceErr := errors.New("config file not found")
+	s = NewService(WithConfigSource(func() (*Config, error) {
+		return nil, sourceErr
+	}))
+	assert.ErrorIs(t, s.Reload(), sourceErr)
+
+	// the invalid config is not applied
+	invalid := DefaultConfig()
+	invalid.RateLimit = RateLimitConf{Rate: -1}
+	invalid.LogLevel = logLevelDebug
+	s = NewService(WithConfigSource(func() (*Config, error) {
+		return invalid, nil
+	}))
+	assert.ErrorIs(t, s.Reload(), ErrInvalidConfig)
+	assert.False(t, s.reloadable.debug)
+
+	s = NewService(WithConfigSource(func() (*Config, error) {
+		return DefaultConfig(), nil
+	}))
+	s.AddReloadFunc(func(c *Config) error {
+		return errors.New("invalid cors origins")
+	})
+	assert.Error(t, s.Reload())
+}
+
+func TestReloadSignal(t *testing.T) {
+	var should = require.New(t)
+
+	reloaded := make(chan struct{}, 1)
+	s := NewServiceWithoutGateway(
+		WithPreShutdownDelay(0),
+		WithConfigSource(func() (*Config, error) {
+			return DefaultConfig(), nil
+		}),
+		WithReloadFunc(func(c *Config) error {
+			reloaded <- struct{}{}
+			return nil
+		}),
+	)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.StartGRPCWithoutGateway(29995)
+	}()
+
+	<-s.Started()
+	should.NoError(syscall.Kill(s.GetPid(), syscall.SIGHUP))
+
+	select {
+	case <-reloaded:
+	case <-time.After(3 * time.Second):
+		should.FailNow("config is not reloaded")
+	}
+
+	// the service is still running after reload
+	select {
+	case err := <-errChan:
+		should.FailNow("service exited", "%v", err)
+	default:
+	}
+
+	s.StopGRPCWithoutGateway()
+	should.NoError(<-errChan)
+}
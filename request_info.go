@@ -0,0 +1,210 @@
+package gmicro
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestInfo is the information of the request carried by the context,
+// it is set by the Service for both the gRPC handlers and the http handlers.
+type RequestInfo struct {
+	// RequestID the x-request-id of the request, it is generated if the client does not send it.
+	RequestID string
+
+	// Method the gRPC full method, eg: /App.Grpc.Hello.GreeterService/SayHello,
+	// or the http method, eg: GET
+	Method string
+
+	// URI the gRPC full method or the http request uri
+	URI string
+
+	// ClientIP the ip address of the client, the X-Forwarded-For and X-Real-Ip are trusted
+	// only if they are sent by the proxies of WithTrustedProxies, and the gRPC requests of the
+	// gateway carry the client ip of the http requests.
+	ClientIP string
+
+	// Tenant the tenant resolved by Tenancy, it is empty if the Tenancy is not enabled.
+	Tenant string
+
+	// StartTime the time the request is received
+	StartTime time.Time
+}
+
+// the metadata keys forwarded by the gateway, the gateway token proves that the metadata
+// is forwarded by the gateway of the same Service rather than sent by the client.
+const (
+	gatewayTokenMDKey    = "gmicro-gateway-token"
+	gatewayClientIPMDKey = "gmicro-client-ip"
+)
+
+var (
+	requestInfoKey = NewKey[*RequestInfo]("request-info")
+	loggerKey      = NewKey[Logger]("logger")
+
+	// fromGatewayKey reports whether the gRPC request is sent by the gateway of the Service
+	fromGatewayKey = NewKey[bool]("from-gateway")
+)
+
+// ContextWithRequestInfo returns a copy of ctx with the RequestInfo,
+// the fields are also set to the RequestIDKey, ClientIPKey, RequestMethodKey and RequestURIKey.
+func ContextWithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	if info == nil {
+		return ctx
+	}
+
+	ctx = RequestIDKey.With(ctx, info.RequestID)
+	ctx = ClientIPKey.With(ctx, info.ClientIP)
+	ctx = RequestMethodKey.With(ctx, info.Method)
+	ctx = RequestURIKey.With(ctx, info.URI)
+	return requestInfoKey.With(ctx, info)
+}
+
+// RequestInfoFromContext returns the RequestInfo in ctx.
+func RequestInfoFromContext(ctx context.Context) (*RequestInfo, bool) {
+	info, ok := requestInfoKey.From(ctx)
+	return info, ok && info != nil
+}
+
+// ContextWithLogger returns a copy of ctx with the logger which is used by LoggerFromContext.
+func ContextWithLogger(ctx context.Context, logger Logger) context.Context {
+	return loggerKey.With(ctx, logger)
+}
+
+// LoggerFromContext returns the logger of ctx, the messages are prefixed with
+// the request id, method, client ip and tenant of the RequestInfo in ctx.
+// The Service sets its logger to the context of every request,
+// nothing is written if ctx has no logger.
+func LoggerFromContext(ctx context.Context) Logger {
+	logger, ok := loggerKey.From(ctx)
+	if !ok || logger == nil {
+		logger = dummyLogger
+	}
+
+	info, ok := RequestInfoFromContext(ctx)
+	if !ok {
+		return logger
+	}
+
+	prefix := "x-request-id:" + info.RequestID + " method:" + info.Method + " client-ip:" + info.ClientIP + " "
+	if info.Tenant != "" {
+		prefix += "tenant:" + info.Tenant + " "
+	}
+
+	return &requestLogger{logger: logger, prefix: prefix}
+}
+
+// requestLogger prefixes the messages with the request fields.
+type requestLogger struct {
+	logger Logger
+	prefix string
+}
+
+// Printf implements Logger interface.
+func (l *requestLogger) Printf(msg string, args ...interface{}) {
+	// the prefix is escaped so that it is not treated as verbs
+	l.logger.Printf(strings.ReplaceAll(l.prefix, "%", "%%")+msg, args...)
+}
+
+// requestContext returns ctx with the RequestInfo of the gRPC request and the logger of the Service.
+// The client ip forwarded by the gateway is trusted with the gateway token, and the gateway token
+// is removed from the metadata.
+func (s *Service) requestContext(ctx context.Context, fullMethod string) context.Context {
+	md := GetIncomingMD(ctx).Copy()
+	tokens := md.Get(gatewayTokenMDKey)
+	fromGateway := len(tokens) == 1 && subtle.ConstantTimeCompare([]byte(tokens[0]), []byte(s.gatewayToken)) == 1
+	info := &RequestInfo{
+		RequestID: GetStringFromMD(md, XRequestID),
+		Method:    fullMethod,
+		URI:       fullMethod,
+		StartTime: time.Now(),
+	}
+
+	if info.RequestID == "" {
+		info.RequestID = Uuid()
+	}
+
+	// the request id is set to the metadata so that the generated one is the same as the
+	// request id read by RequestInterceptor and the other interceptors, and the one
+	// forwarded by the gateway is not duplicated by the propagated header.
+	md.Set(XRequestID.String(), info.RequestID)
+
+	peerIP, _ := GetGRPCClientIP(ctx)
+	if clientIP := md.Get(gatewayClientIPMDKey); fromGateway && len(clientIP) == 1 {
+		info.ClientIP = clientIP[0]
+	} else {
+		info.ClientIP = s.forwardedClientIP(peerIP, strings.Join(md.Get("x-forwarded-for"), ","), "")
+	}
+
+	md.Delete(gatewayTokenMDKey)
+	md.Delete(gatewayClientIPMDKey)
+	ctx = fromGatewayKey.With(metadata.NewIncomingContext(ctx, md), fromGateway)
+	return ContextWithLogger(ContextWithRequestInfo(ctx, info), s.logger)
+}
+
+func (s *Service) requestInfoUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(s.requestContext(ctx, info.FullMethod), req)
+}
+
+func (s *Service) requestInfoStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: s.requestContext(ss.Context(), info.FullMethod)})
+}
+
+// contextServerStream replaces the context of the grpc.ServerStream.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the replaced context.
+func (ss *contextServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// requestInfoHandler sets the RequestInfo and the logger to the context of the http request,
+// the generated request id is set to the X-Request-Id header of the request, so the error
+// response of the request carries the same request id.
+func (s *Service) requestInfoHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the clients can not send the metadata forwarded by the gateway
+		for _, key := range []string{gatewayTokenMDKey, gatewayClientIPMDKey, XRequestID.String()} {
+			r.Header.Del(gRuntime.MetadataHeaderPrefix + key)
+		}
+
+		info := &RequestInfo{
+			RequestID: r.Header.Get("X-Request-Id"),
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			ClientIP:  s.httpClientIP(r),
+			StartTime: time.Now(),
+		}
+
+		if info.RequestID == "" {
+			info.RequestID = Uuid()
+			r.Header.Set("X-Request-Id", info.RequestID)
+		}
+
+		ctx := ContextWithLogger(ContextWithRequestInfo(r.Context(), info), s.logger)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestInfoAnnotator forwards the request id and the client ip of the http request
+// to the gRPC request of the gateway with the gateway token.
+func (s *Service) requestInfoAnnotator(ctx context.Context, _ *http.Request) metadata.MD {
+	md := metadata.Pairs(gatewayTokenMDKey, s.gatewayToken)
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		md.Set(XRequestID.String(), info.RequestID)
+		md.Set(gatewayClientIPMDKey, info.ClientIP)
+	}
+
+	return md
+}
@@ -0,0 +1,155 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// bufferLogger records the messages.
+type bufferLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *bufferLogger) Printf(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, fmt.Sprintf(msg, args...))
+}
+
+func (l *bufferLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, log := range l.logs {
+		if strings.Contains(log, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestInfoGreeterService logs the message with the logger of the context.
+type requestInfoGreeterService struct {
+	pb.UnimplementedGreeterServiceServer
+}
+
+func (s *requestInfoGreeterService) SayHello(ctx context.Context, in *pb.HelloReq) (*pb.HelloReply, error) {
+	LoggerFromContext(ctx).Printf("say hello to %s", in.Name)
+	info, _ := RequestInfoFromContext(ctx)
+	return &pb.HelloReply{Name: info.ClientIP, Message: info.RequestID}, nil
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	var should = require.New(t)
+	should.NotPanics(func() { LoggerFromContext(context.Background()).Printf("nothing is written") })
+
+	logger := &bufferLogger{}
+	ctx := ContextWithLogger(context.Background(), logger)
+	LoggerFromContext(ctx).Printf("no request %d", 1)
+	should.Equal([]string{"no request 1"}, logger.logs)
+
+	ctx = ContextWithRequestInfo(ctx, &RequestInfo{RequestID: "id%d", Method: "GET", ClientIP: "10.0.0.1"})
+	LoggerFromContext(ctx).Printf("hello %s", "daheige")
+	should.Equal("x-request-id:id%d method:GET client-ip:10.0.0.1 hello daheige", logger.logs[1])
+
+	_, ok := RequestInfoFromContext(context.Background())
+	should.False(ok)
+}
+
+func TestRequestInfo(t *testing.T) {
+	var should = require.New(t)
+	logger := &bufferLogger{}
+	httpRequestIDs := make(chan string, 1)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithLogger(logger),
+		WithRequestAccess(true),
+		WithTrustedProxies("127.0.0.1"),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithAnnotator(func(ctx context.Context, _ *http.Request) metadata.MD {
+			if info, ok := RequestInfoFromContext(ctx); ok {
+				httpRequestIDs <- info.RequestID
+			}
+
+			return nil
+		}),
+		WithRouteOpt(Route{Method: http.MethodGet, Path: "/info", Handler: func(w http.ResponseWriter, r *http.Request,
+			_ map[string]string) {
+			info, ok := RequestInfoFromContext(r.Context())
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			LoggerFromContext(r.Context()).Printf("route %s", info.URI)
+			_, _ = io.WriteString(w, info.RequestID+" "+info.ClientIP)
+		}}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &requestInfoGreeterService{})
+
+	addr := startTestServer(t, s)
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/info?a=1", nil)
+	should.NoError(err)
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	res, err := http.DefaultClient.Do(req)
+	should.NoError(err)
+	b, err := io.ReadAll(res.Body)
+	should.NoError(err)
+	res.Body.Close()
+
+	// the last address which is not a trusted proxy is the client
+	should.Equal("abc 10.0.0.2", string(b))
+	should.True(logger.contains("x-request-id:abc method:GET client-ip:10.0.0.2 route /info?a=1"))
+
+	// the gateway forwards the generated request id and the client ip to the gRPC request
+	req, err = http.NewRequest(http.MethodGet, "http://"+addr+"/v1/say/daheige", nil)
+	should.NoError(err)
+	req.Header.Set("X-Forwarded-For", "10.0.0.3")
+	req.Header.Set(gRuntime.MetadataHeaderPrefix+gatewayClientIPMDKey, "10.0.0.4")
+	res, err = http.DefaultClient.Do(req)
+	should.NoError(err)
+	var body map[string]string
+	should.NoError(json.NewDecoder(res.Body).Decode(&body))
+	res.Body.Close()
+	should.Equal(http.StatusOK, res.StatusCode)
+	should.Equal(<-httpRequestIDs, body["message"])
+	should.Equal("10.0.0.3", body["name"])
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	client := pb.NewGreeterServiceClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), XRequestID.String(), "req-1",
+		gatewayClientIPMDKey, "10.0.0.4", gatewayTokenMDKey, "guess")
+	reply, err := client.SayHello(ctx, &pb.HelloReq{Name: "daheige"})
+	should.NoError(err)
+	should.Equal("req-1", reply.Message)
+	should.Equal("127.0.0.1", reply.Name)
+	should.True(logger.contains("x-request-id:req-1 method:/App.Grpc.Hello.GreeterService/SayHello " +
+		"client-ip:127.0.0.1 say hello to daheige"))
+
+	// the request id is generated and shared with RequestInterceptor
+	reply, err = client.SayHello(context.Background(), &pb.HelloReq{Name: "daheige"})
+	should.NoError(err)
+	assert.NotEmpty(t, reply.Message)
+	should.True(logger.contains("x-request-id:" + reply.Message + " method:/App.Grpc.Hello.GreeterService/SayHello"))
+	should.True(logger.contains("exec begin,method:/App.Grpc.Hello.GreeterService/SayHello x-request-id:" + reply.Message))
+}
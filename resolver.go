@@ -0,0 +1,128 @@
+package gmicro
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// ResolverScheme is the scheme of the target resolved by Discovery,
+	// eg: gmicro://service-name or gmicro:///service-name
+	ResolverScheme = "gmicro"
+
+	// MetadataWeight is the metadata key of the instance weight used by WeightedRoundRobin,
+	// the weight is 1 if it is not set or invalid.
+	MetadataWeight = "weight"
+)
+
+// weightKey is the address attribute key of the instance weight.
+type weightKey struct{}
+
+// NewResolverBuilder returns a resolver.Builder of the ResolverScheme,
+// the addresses are updated when the instances watched from d change,
+// and only the healthy instances are used.
+func NewResolverBuilder(d Discovery) resolver.Builder {
+	return &discoveryResolverBuilder{discovery: d}
+}
+
+// DialService dials the service discovered by d with the balancer, eg: LeastRequest,
+// the grpc default pick_first balancer is used if balancerName is empty.
+// The insecure credentials are used if no dial option is specified.
+func DialService(ctx context.Context, d Discovery, name string, balancerName string,
+	opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if len(opts) == 0 {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	opts = append(opts, grpc.WithResolvers(NewResolverBuilder(d)))
+	if balancerName != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(
+			fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, balancerName)))
+	}
+
+	return grpc.DialContext(ctx, ResolverScheme+"://"+name, opts...)
+}
+
+type discoveryResolverBuilder struct {
+	discovery Discovery
+}
+
+// Build implements resolver.Builder interface.
+func (b *discoveryResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn,
+	_ resolver.BuildOptions) (resolver.Resolver, error) {
+	name := target.URL.Host
+	if name == "" {
+		name = strings.TrimPrefix(target.URL.Path, "/")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := b.discovery.Watch(ctx, name)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r := &discoveryResolver{cancel: cancel}
+	go r.watch(ch, cc)
+
+	return r, nil
+}
+
+// Scheme implements resolver.Builder interface.
+func (b *discoveryResolverBuilder) Scheme() string {
+	return ResolverScheme
+}
+
+type discoveryResolver struct {
+	cancel context.CancelFunc
+}
+
+func (r *discoveryResolver) watch(ch <-chan []*ServiceInstance, cc resolver.ClientConn) {
+	for list := range ch {
+		addrs := make([]resolver.Address, 0, len(list))
+		for _, ins := range list {
+			if !ins.Healthy {
+				continue
+			}
+
+			addrs = append(addrs, resolver.Address{
+				Addr:       ins.Address,
+				Attributes: attributes.New(weightKey{}, instanceWeight(ins)),
+			})
+		}
+
+		// the stale addresses are dropped, so the balancer stops sending to them
+		if len(addrs) == 0 {
+			_ = cc.UpdateState(resolver.State{Addresses: nil})
+			cc.ReportError(ErrNoHealthyInstance)
+			continue
+		}
+
+		_ = cc.UpdateState(resolver.State{Addresses: addrs})
+	}
+}
+
+// ResolveNow implements resolver.Resolver interface, the addresses are pushed by watch.
+func (r *discoveryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close implements resolver.Resolver interface.
+func (r *discoveryResolver) Close() {
+	r.cancel()
+}
+
+// instanceWeight returns the weight of the instance from its metadata.
+func instanceWeight(ins *ServiceInstance) int {
+	weight, err := strconv.Atoi(ins.Metadata[MetadataWeight])
+	if err != nil || weight < 1 {
+		return 1
+	}
+
+	return weight
+}
@@ -0,0 +1,170 @@
+package gmicro
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+// addrGreeterService replies with the address of the server.
+type addrGreeterService struct {
+	pb.UnimplementedGreeterServiceServer
+	addr string
+}
+
+func (s *addrGreeterService) SayHello(ctx context.Context, in *pb.HelloReq) (*pb.HelloReply, error) {
+	return &pb.HelloReply{Name: in.Name, Message: s.addr}, nil
+}
+
+// startGreeterInstances starts the grpc servers and registers them with the weights.
+func startGreeterInstances(t *testing.T, r Registry, weights ...int) []*ServiceInstance {
+	list := make([]*ServiceInstance, 0, len(weights))
+	for i, weight := range weights {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		server := grpc.NewServer()
+		pb.RegisterGreeterServiceServer(server, &addrGreeterService{addr: lis.Addr().String()})
+		go server.Serve(lis)
+		t.Cleanup(server.Stop)
+
+		ins := &ServiceInstance{
+			ID:       "hello-" + strconv.Itoa(i),
+			Name:     "hello",
+			Address:  lis.Addr().String(),
+			Metadata: map[string]string{MetadataWeight: strconv.Itoa(weight)},
+			Healthy:  true,
+		}
+		require.NoError(t, r.Register(context.Background(), ins, time.Minute))
+		list = append(list, ins)
+	}
+
+	return list
+}
+
+// sayHellos calls SayHello n times and returns the call count of each address.
+func sayHellos(t *testing.T, conn *grpc.ClientConn, n int) map[string]int {
+	counts := make(map[string]int)
+	client := pb.NewGreeterServiceClient(conn)
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		res, err := client.SayHello(ctx, &pb.HelloReq{Name: "daheige"}, grpc.WaitForReady(true))
+		cancel()
+		require.NoError(t, err)
+		counts[res.Message]++
+	}
+
+	return counts
+}
+
+func TestDialServiceWeightedRoundRobin(t *testing.T) {
+	var should = require.New(t)
+
+	r := NewMemoryRegistry()
+	list := startGreeterInstances(t, r, 1, 2, 3)
+
+	conn, err := DialService(context.Background(), r, "hello", WeightedRoundRobin)
+	should.NoError(err)
+	defer conn.Close()
+
+	// wait for all the instances to be ready
+	should.Eventually(func() bool {
+		return len(sayHellos(t, conn, 6)) == 3
+	}, 3*time.Second, 10*time.Millisecond)
+
+	counts := sayHellos(t, conn, 60)
+	should.Equal(10, counts[list[0].Address])
+	should.Equal(20, counts[list[1].Address])
+	should.Equal(30, counts[list[2].Address])
+
+	// the unhealthy and deregistered instances are removed
+	list[1].Healthy = false
+	should.NoError(r.Heartbeat(context.Background(), list[1]))
+	should.NoError(r.Deregister(context.Background(), list[2]))
+	should.Eventually(func() bool {
+		counts = sayHellos(t, conn, 6)
+		return len(counts) == 1 && counts[list[0].Address] == 6
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// no request is sent to the last deregistered instance
+	should.NoError(r.Deregister(context.Background(), list[0]))
+	should.Eventually(func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err := pb.NewGreeterServiceClient(conn).SayHello(ctx, &pb.HelloReq{Name: "daheige"})
+		return err != nil
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestDialServiceLeastRequest(t *testing.T) {
+	r := NewMemoryRegistry()
+	startGreeterInstances(t, r, 1, 1)
+
+	conn, err := DialService(context.Background(), r, "hello", LeastRequest)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the idle instances are picked in turn
+	assert.Eventually(t, func() bool {
+		return len(sayHellos(t, conn, 4)) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+// fakeSubConn is a balancer.SubConn used to test the pickers.
+type fakeSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func TestLeastRequestPicker(t *testing.T) {
+	var should = require.New(t)
+
+	sc1, sc2 := &fakeSubConn{name: "sc1"}, &fakeSubConn{name: "sc2"}
+	picker := (&lrPickerBuilder{}).Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			sc1: {Address: resolver.Address{Addr: "sc1"}},
+			sc2: {Address: resolver.Address{Addr: "sc2"}},
+		},
+	})
+
+	first, err := picker.Pick(balancer.PickInfo{})
+	should.NoError(err)
+
+	// the other one has less in-flight requests
+	second, err := picker.Pick(balancer.PickInfo{})
+	should.NoError(err)
+	should.NotEqual(first.SubConn, second.SubConn)
+
+	// the first one is picked after its request is done
+	first.Done(balancer.DoneInfo{})
+	third, err := picker.Pick(balancer.PickInfo{})
+	should.NoError(err)
+	should.Equal(first.SubConn, third.SubConn)
+
+	var inflight int64
+	for _, item := range picker.(*lrPicker).items {
+		inflight += atomic.LoadInt64(&item.inflight)
+	}
+	should.Equal(int64(2), inflight)
+
+	_, err = (&lrPickerBuilder{}).Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{})
+	should.ErrorIs(err, balancer.ErrNoSubConnAvailable)
+}
+
+func TestInstanceWeight(t *testing.T) {
+	assert.Equal(t, 1, instanceWeight(&ServiceInstance{}))
+	assert.Equal(t, 1, instanceWeight(&ServiceInstance{Metadata: map[string]string{MetadataWeight: "abc"}}))
+	assert.Equal(t, 1, instanceWeight(&ServiceInstance{Metadata: map[string]string{MetadataWeight: "-1"}}))
+	assert.Equal(t, 5, instanceWeight(&ServiceInstance{Metadata: map[string]string{MetadataWeight: "5"}}))
+}
@@ -1,15 +1,191 @@
 package gmicro
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
 	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
 )
 
+var (
+	// ErrRouteNotFound there is no route with the name.
+	ErrRouteNotFound = errors.New("route not found")
+
+	// ErrDuplicateRouteName the route name is used by more than one route.
+	ErrDuplicateRouteName = errors.New("duplicate route name")
+)
+
 // Route represents the route for mux
 type Route struct {
-	Method  string
-	Path    string
-	Handler gRuntime.HandlerFunc
+	Method      string
+	Path        string
+	Handler     gRuntime.HandlerFunc
+	Name        string       // the unique name to look up the route, it is optional
+	Middlewares []Middleware // the middlewares wrapping Handler, the first one is the outermost
+}
+
+// Middleware wraps the route handler, it can be used to authenticate, log or
+// rate limit the requests of the routes.
+type Middleware func(next gRuntime.HandlerFunc) gRuntime.HandlerFunc
+
+// HTTPMiddleware converts the standard http middleware to Middleware,
+// the path params are passed through.
+func HTTPMiddleware(m func(http.Handler) http.Handler) Middleware {
+	return func(next gRuntime.HandlerFunc) gRuntime.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next(w, r, pathParams)
+			})).ServeHTTP(w, r)
+		}
+	}
+}
+
+// RouteGroup is a group of routes sharing the path prefix and the middlewares,
+// the middlewares of the group wrap the middlewares of its routes and sub groups.
+type RouteGroup struct {
+	Prefix      string
+	Middlewares []Middleware
+	Routes      []Route
+	Groups      []RouteGroup
+}
+
+// routes returns the routes of the group and its sub groups with the prefix and middlewares applied.
+func (g RouteGroup) routes() []Route {
+	prefix := strings.TrimSuffix("/"+strings.Trim(g.Prefix, "/"), "/")
+	routes := make([]Route, 0, len(g.Routes))
+	for _, route := range g.Routes {
+		route.Path = prefix + "/" + strings.TrimPrefix(route.Path, "/")
+		route.Middlewares = append(append([]Middleware{}, g.Middlewares...), route.Middlewares...)
+		routes = append(routes, route)
+	}
+
+	for _, sub := range g.Groups {
+		sub.Prefix = prefix + "/" + strings.Trim(sub.Prefix, "/")
+		sub.Middlewares = append(append([]Middleware{}, g.Middlewares...), sub.Middlewares...)
+		routes = append(routes, sub.routes()...)
+	}
+
+	return routes
+}
+
+// chainMiddlewares wraps h with the middlewares, the first one is the outermost.
+func chainMiddlewares(h gRuntime.HandlerFunc, middlewares []Middleware) gRuntime.HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+
+	return h
+}
+
+// AddRouteGroup adds the routes of the groups.
+func (s *Service) AddRouteGroup(groups ...RouteGroup) {
+	for _, g := range groups {
+		s.routes = append(s.routes, g.routes()...)
+	}
+}
+
+// URLFor returns the path of the named route with the path params filled in,
+// eg: the path of route /v1/users/{id} is /v1/users/1 if pathParams is {"id": "1"}.
+func (s *Service) URLFor(name string, pathParams map[string]string) (string, error) {
+	for _, route := range s.routes {
+		if route.Name != name {
+			continue
+		}
+
+		var b strings.Builder
+		p := "/" + strings.TrimPrefix(route.Path, "/")
+		for {
+			start := strings.Index(p, "{")
+			if start < 0 {
+				b.WriteString(p)
+				return b.String(), nil
+			}
+
+			end := strings.Index(p[start:], "}")
+			if end < 0 {
+				return "", fmt.Errorf("invalid route path %s", route.Path)
+			}
+
+			key := p[start+1 : start+end]
+			if i := strings.Index(key, "="); i >= 0 {
+				key = key[:i]
+			}
+
+			val, ok := pathParams[key]
+			if !ok {
+				return "", fmt.Errorf("missing path param %s of route %s", key, name)
+			}
+
+			segments := strings.Split(val, "/")
+			for i := range segments {
+				segments[i] = url.PathEscape(segments[i])
+			}
+
+			b.WriteString(p[:start])
+			b.WriteString(strings.Join(segments, "/"))
+			p = p[start+end+1:]
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrRouteNotFound, name)
+}
+
+// RateLimitMiddleware returns a Middleware which rejects the requests with 429
+// when the limiter limits them, the error is rendered by the errorHandler.
+func (s *Service) RateLimitMiddleware(limiter Limiter) Middleware {
+	return func(next gRuntime.HandlerFunc) gRuntime.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			if limiter.Limit() {
+				s.rejectRequest(w, r, http.StatusTooManyRequests, "rate_limit",
+					ResourceExhausted("RATE_LIMITED", "too many requests, please retry later"))
+				return
+			}
+
+			next(w, r, pathParams)
+		}
+	}
+}
+
+// AccessLogMiddleware returns a Middleware which logs the method, path, status and
+// cost time of the requests by the logger of Service.
+func (s *Service) AccessLogMiddleware() Middleware {
+	return func(next gRuntime.HandlerFunc) gRuntime.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			t := time.Now()
+			sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			next(sw, r, pathParams)
+			s.logger.Printf("http request method:%s path:%s status:%d cost time:%v\n",
+				r.Method, r.URL.Path, sw.status, time.Since(t))
+		}
+	}
+}
+
+// statusResponseWriter records the status code of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher.
+func (w *statusResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped http.ResponseWriter.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 // AllPattern returns a pattern which matches any url
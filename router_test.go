@@ -0,0 +1,130 @@
+package gmicro
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// traceMiddleware appends name to the X-Trace response header.
+func traceMiddleware(name string) Middleware {
+	return func(next gRuntime.HandlerFunc) gRuntime.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			w.Header().Add("X-Trace", name)
+			next(w, r, pathParams)
+		}
+	}
+}
+
+func TestRouteGroup(t *testing.T) {
+	var should = require.New(t)
+	hello := func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		_, _ = io.WriteString(w, "hello,"+pathParams["name"])
+	}
+
+	auth := HTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithRouteGroup(RouteGroup{
+			Prefix:      "/api/",
+			Middlewares: []Middleware{traceMiddleware("api")},
+			Routes: []Route{
+				{Method: http.MethodGet, Path: "hello/{name}", Handler: hello, Name: "hello",
+					Middlewares: []Middleware{traceMiddleware("hello")}},
+			},
+			Groups: []RouteGroup{
+				{
+					Prefix:      "admin",
+					Middlewares: []Middleware{auth, traceMiddleware("admin")},
+					Routes:      []Route{{Method: http.MethodGet, Path: "/users/{name}", Handler: hello, Name: "user"}},
+				},
+			},
+		}),
+	)
+	s.AddRouteGroup(RouteGroup{
+		Prefix:      "/limited",
+		Middlewares: []Middleware{s.RateLimitMiddleware(NewTokenBucket(0.001, 1)), s.AccessLogMiddleware()},
+		Routes:      []Route{{Method: http.MethodGet, Path: "/{name}", Handler: hello}},
+	})
+
+	path, err := s.URLFor("user", map[string]string{"name": "a b"})
+	should.NoError(err)
+	should.Equal("/api/admin/users/a%20b", path)
+
+	addr := startTestServer(t, s)
+
+	for _, c := range []struct {
+		path   string
+		token  string
+		status int
+		trace  []string
+		body   string
+	}{
+		{"/api/hello/daheige", "", http.StatusOK, []string{"api", "hello"}, "hello,daheige"},
+		{"/api/admin/users/daheige", "", http.StatusUnauthorized, []string{"api"}, ""},
+		{"/api/admin/users/daheige", "Bearer token", http.StatusOK, []string{"api", "admin"}, "hello,daheige"},
+		{"/limited/daheige", "", http.StatusOK, nil, "hello,daheige"},
+		{"/limited/daheige", "", http.StatusTooManyRequests, nil, ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+c.path, nil)
+		should.NoError(err)
+		req.Header.Set("Authorization", c.token)
+		res, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+		b, err := io.ReadAll(res.Body)
+		should.NoError(err)
+		res.Body.Close()
+		should.Equal(c.status, res.StatusCode, c.path)
+		should.Equal(c.trace, res.Header.Values("X-Trace"), c.path)
+		if c.body != "" {
+			should.Equal(c.body, string(b), c.path)
+		}
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	s := NewService(WithRouteOpt(
+		Route{Method: http.MethodGet, Path: "/v1/files/{path=**}", Name: "file"},
+		Route{Method: http.MethodGet, Path: "health", Name: "health"},
+	))
+
+	path, err := s.URLFor("file", map[string]string{"path": "a/b c.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/v1/files/a/b%20c.txt", path)
+
+	path, err = s.URLFor("health", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/health", path)
+
+	_, err = s.URLFor("file", nil)
+	assert.Error(t, err)
+
+	_, err = s.URLFor("unknown", nil)
+	assert.ErrorIs(t, err, ErrRouteNotFound)
+}
+
+func TestDuplicateRouteName(t *testing.T) {
+	s := NewService(WithRouteOpt(
+		Route{Method: http.MethodGet, Path: "/a", Name: "a", Handler: func(http.ResponseWriter, *http.Request, map[string]string) {}},
+		Route{Method: http.MethodGet, Path: "/b", Name: "a", Handler: func(http.ResponseWriter, *http.Request, map[string]string) {}},
+	))
+
+	err := s.appRoutes()
+	assert.ErrorIs(t, err, ErrDuplicateRouteName)
+	assert.True(t, strings.HasSuffix(err.Error(), ": a"))
+}
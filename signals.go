@@ -7,6 +7,11 @@ import (
 
 // InterruptSignals interrupt signals.
 var InterruptSignals = []os.Signal{
-	syscall.SIGINT, syscall.SIGTERM, os.Interrupt, syscall.SIGHUP,
+	syscall.SIGINT, syscall.SIGTERM, os.Interrupt,
 	syscall.SIGSTOP, syscall.SIGQUIT,
 }
+
+// ReloadSignals reload signals, the service reloads its config instead of exiting.
+var ReloadSignals = []os.Signal{
+	syscall.SIGHUP,
+}
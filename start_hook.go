@@ -0,0 +1,67 @@
+package gmicro
+
+import (
+	"context"
+	"fmt"
+)
+
+// StartHook is called before the listeners open, it is the place to initialize
+// dependencies such as database and cache connections.
+// If a StartHook returns an error, the service will abort starting.
+type StartHook func(ctx context.Context) error
+
+// runStartHooks runs the start hooks in the order they were registered,
+// all of them must be finished within startTimeout.
+func (s *Service) runStartHooks() error {
+	if len(s.startHooks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.startTimeout)
+	defer cancel()
+
+	for i, hook := range s.startHooks {
+		errChan := make(chan error, 1)
+		go func() {
+			// the panic of the hook aborts starting instead of crashing the process
+			defer func() {
+				if r := recover(); r != nil {
+					errChan <- fmt.Errorf("panic: %v", r)
+				}
+			}()
+
+			errChan <- hook(ctx)
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.logger.Printf("run start hook #%d timeout: %v\n", i, ctx.Err())
+			return fmt.Errorf("start hook #%d: %w", i, ctx.Err())
+		case err := <-errChan:
+			if err != nil {
+				s.logger.Printf("run start hook #%d error: %s\n", i, err.Error())
+				return fmt.Errorf("start hook #%d: %w", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// notifyStarted closes the started channel and calls the startedFunc only once.
+func (s *Service) notifyStarted() {
+	s.startedOnce.Do(func() {
+		s.logger.Printf("Service started, pid: %d\n", s.GetPid())
+		close(s.started)
+		if s.startedFunc != nil {
+			s.startedFunc()
+		}
+	})
+}
+
+// Started returns a channel which will be closed when the listeners of the service
+// are accepting connections, it is useful for tests and orchestrators to know exactly
+// when the service is ready.
+func (s *Service) Started() <-chan struct{} {
+	return s.started
+}
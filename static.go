@@ -0,0 +1,566 @@
+package gmicro
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// the Cache-Control of the hashed assets which never change
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// the html of the directory listing
+var directoryListingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{range .Entries}}<li><a href="{{$.Path}}{{.Name}}{{if .Dir}}/{{end}}">{{.Name}}{{if .Dir}}/{{end}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// Static is the static files config of the http gateway, the files are served before
+// the gateway routes, the request is passed to the gateway if the file does not exist
+// or it is denied by the policy.
+type Static struct {
+	// FS the static files, such as embed.FS
+	FS fs.FS
+
+	// Root the directory of the static files which is used if FS is nil,
+	// the symlinks resolved outside Root are denied.
+	Root string
+
+	// Prefix the url prefix of the static files, default: /
+	Prefix string
+
+	// Index the file served for the directory, default: index.html
+	Index string
+
+	// SPAFallback serves the Index of Prefix for the unknown paths requested by
+	// the browsers (Accept: text/html), so the single-page app can handle its routes.
+	// The paths are unknown when the gateway responds them with 404.
+	SPAFallback bool
+
+	// MaxAge the Cache-Control max-age of the files which are not hashed,
+	// the files are revalidated by ETag every time if it is 0.
+	MaxAge time.Duration
+
+	// Precompressed serves the .br or .gz variant of the file if it exists
+	// and is accepted by the client.
+	Precompressed bool
+
+	// AllowDotfiles serves the files and directories whose names begin with ".",
+	// such as .env and .git, they are denied by default.
+	AllowDotfiles bool
+
+	// Extensions the allowed file extensions such as .html and .js, all the extensions
+	// are allowed if it is empty.
+	Extensions []string
+
+	// DirectoryListing lists the directory without Index as HTML,
+	// or as JSON if the request accepts application/json.
+	DirectoryListing bool
+}
+
+// DirectoryEntry is the entry of the directory listing.
+type DirectoryEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// staticHandler serves the static files with ETag and Cache-Control headers.
+type staticHandler struct {
+	Static
+	etags sync.Map // file name => staticETag
+}
+
+type staticETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+func newStaticHandler(st Static) *staticHandler {
+	if st.Prefix == "" {
+		st.Prefix = "/"
+	}
+
+	if !strings.HasPrefix(st.Prefix, "/") {
+		st.Prefix = "/" + st.Prefix
+	}
+
+	if !strings.HasSuffix(st.Prefix, "/") {
+		st.Prefix += "/"
+	}
+
+	if st.Index == "" {
+		st.Index = "index.html"
+	}
+
+	if st.FS == nil && st.Root != "" {
+		st.FS = rootFS(st.Root)
+	}
+
+	return &staticHandler{Static: st}
+}
+
+// handler serves the GET and HEAD requests of the files under Prefix, the other
+// requests are passed to next, and the Index is served if next responds the
+// SPA route with 404.
+func (h *staticHandler) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if h.serve(w, r) {
+			return
+		}
+
+		if !h.spaFallback(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		nw := &notFoundResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(nw, r)
+		if !nw.notFound {
+			return
+		}
+
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Length")
+		if !h.serveFile(w, r, "") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+// serve serves the file of the request path, it returns false if the file
+// does not exist or it is denied by the policy.
+// The directory requested without the trailing slash is redirected, so the relative
+// links of its index are resolved under it.
+func (h *staticHandler) serve(w http.ResponseWriter, r *http.Request) bool {
+	p := path.Clean("/" + r.URL.Path)
+	if p != "/" {
+		p += "/"
+	}
+
+	if h.FS == nil || !strings.HasPrefix(p, h.Prefix) {
+		return false
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(p, h.Prefix), "/")
+	if !strings.HasSuffix(r.URL.Path, "/") && h.servesDirectory(name) {
+		if r.URL.RawQuery != "" {
+			p += "?" + r.URL.RawQuery
+		}
+
+		http.Redirect(w, r, p, http.StatusMovedPermanently)
+		return true
+	}
+
+	return h.serveFile(w, r, name)
+}
+
+// spaFallback reports whether the Index of Prefix is served for the request
+// if the path is unknown.
+func (h *staticHandler) spaFallback(r *http.Request) bool {
+	if !h.SPAFallback || h.FS == nil || !strings.Contains(r.Header.Get("Accept"), "text/html") ||
+		websocket.IsWebSocketUpgrade(r) {
+		return false
+	}
+
+	p := path.Clean("/" + r.URL.Path)
+	return strings.HasPrefix(p+"/", h.Prefix)
+}
+
+// servesDirectory reports whether the name is a directory served by its Index or listing.
+func (h *staticHandler) servesDirectory(name string) bool {
+	if !h.allowedPath(name) {
+		return false
+	}
+
+	if name == "" {
+		name = "."
+	}
+
+	fi, err := fs.Stat(h.FS, name)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+
+	if h.DirectoryListing {
+		return true
+	}
+
+	fi, err = fs.Stat(h.FS, path.Join(name, h.Index))
+	return err == nil && !fi.IsDir() && h.allowedExtension(h.Index)
+}
+
+// serveFile serves the file or the index of the directory, it returns false if the file does not exist.
+func (h *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !h.allowedPath(name) {
+		return false
+	}
+
+	if name == "" {
+		name = "."
+	}
+
+	fi, err := fs.Stat(h.FS, name)
+	if err == nil && fi.IsDir() {
+		dir := name
+		name = path.Join(dir, h.Index)
+		fi, err = fs.Stat(h.FS, name)
+		if err != nil && h.DirectoryListing {
+			return h.listDirectory(w, r, dir)
+		}
+	}
+
+	if err != nil || fi.IsDir() || !h.allowedExtension(name) {
+		return false
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if h.Precompressed {
+		// the response depends on Accept-Encoding even if the variant is not served
+		addVary(w.Header(), "Accept-Encoding")
+		if variant, encoding, variantInfo := h.precompressed(r, name); variant != "" {
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+
+			w.Header().Set("Content-Encoding", encoding)
+			name, fi = variant, variantInfo
+		}
+	}
+
+	f, err := h.FS.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return false
+		}
+
+		content = bytes.NewReader(b)
+	}
+
+	etag, err := h.etag(name, fi, content)
+	if err != nil {
+		return false
+	}
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", h.cacheControl(name))
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+	return true
+}
+
+// precompressed returns the compressed variant of the file accepted by the client.
+func (h *staticHandler) precompressed(r *http.Request, name string) (string, string, fs.FileInfo) {
+	accept := r.Header.Get("Accept-Encoding")
+	for _, v := range []struct{ ext, encoding string }{{".br", EncodingBrotli}, {".gz", EncodingGzip}} {
+		if !acceptsEncoding(accept, v.encoding) {
+			continue
+		}
+
+		if fi, err := fs.Stat(h.FS, name+v.ext); err == nil && !fi.IsDir() {
+			return name + v.ext, v.encoding, fi
+		}
+	}
+
+	return "", "", nil
+}
+
+// etag returns the ETag of the file content, it is cached until the file is changed.
+func (h *staticHandler) etag(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if v, ok := h.etags.Load(name); ok {
+		e := v.(staticETag)
+		if e.modTime.Equal(fi.ModTime()) && e.size == fi.Size() {
+			return e.etag, nil
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil))[:16])
+	h.etags.Store(name, staticETag{modTime: fi.ModTime(), size: fi.Size(), etag: etag})
+	return etag, nil
+}
+
+// allowedPath reports whether the path is allowed by the dotfiles policy.
+func (h *staticHandler) allowedPath(name string) bool {
+	if h.AllowDotfiles || name == "" {
+		return true
+	}
+
+	for _, seg := range strings.Split(name, "/") {
+		if strings.HasPrefix(seg, ".") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allowedExtension reports whether the file extension is allowed by the Extensions.
+func (h *staticHandler) allowedExtension(name string) bool {
+	if len(h.Extensions) == 0 {
+		return true
+	}
+
+	ext := path.Ext(name)
+	for _, v := range h.Extensions {
+		if strings.EqualFold(v, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listDirectory renders the allowed entries of the directory as JSON or HTML.
+func (h *staticHandler) listDirectory(w http.ResponseWriter, r *http.Request, dir string) bool {
+	list, err := fs.ReadDir(h.FS, dir)
+	if err != nil {
+		return false
+	}
+
+	entries := make([]DirectoryEntry, 0, len(list))
+	for _, e := range list {
+		// the entry is stated through FS, so the symlinks escaping the root are hidden
+		fi, err := fs.Stat(h.FS, path.Join(dir, e.Name()))
+		if err != nil || !h.allowedPath(e.Name()) || (!fi.IsDir() && !h.allowedExtension(e.Name())) {
+			continue
+		}
+
+		entries = append(entries, DirectoryEntry{Name: e.Name(), Dir: fi.IsDir(), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, entries)
+		return true
+	}
+
+	p := h.Prefix
+	if dir != "." {
+		p += dir + "/"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = directoryListingTemplate.Execute(w, map[string]interface{}{"Path": p, "Entries": entries})
+	return true
+}
+
+func (h *staticHandler) cacheControl(name string) string {
+	if isHashedAsset(name) {
+		return immutableCacheControl
+	}
+
+	if h.MaxAge > 0 {
+		return fmt.Sprintf("public, max-age=%d", int64(h.MaxAge/time.Second))
+	}
+
+	return "no-cache"
+}
+
+// isHashedAsset reports whether the file name contains a content hash generated by
+// the bundlers, eg: app.3f2a9c1d.js or index-B1x9kQ2z.css
+// The hash is either the hex digits and letters of at least 8 characters, or the
+// 8 characters base64url hash of vite which mixes digits, lower and upper case letters.
+func isHashedAsset(name string) bool {
+	base := path.Base(name)
+	for _, ext := range []string{".br", ".gz"} {
+		base = strings.TrimSuffix(base, ext)
+	}
+
+	base = strings.TrimSuffix(base, path.Ext(base))
+	i := strings.LastIndexAny(base, ".-")
+	if i < 0 {
+		return false
+	}
+
+	hash := base[i+1:]
+	if len(hash) < 8 {
+		return false
+	}
+
+	var digit, hexLetter, lower, upper, other bool
+	for _, c := range hash {
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+		case c >= 'a' && c <= 'f':
+			hexLetter, lower = true, true
+		case c >= 'g' && c <= 'z':
+			lower = true
+			other = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+			other = true
+		case c == '_':
+			other = true
+		default:
+			return false
+		}
+	}
+
+	if !other {
+		return digit && hexLetter
+	}
+
+	return base[i] == '-' && len(hash) == 8 && digit && lower && upper
+}
+
+// addVary adds the header name to the Vary header unless it is present.
+func addVary(header http.Header, name string) {
+	for _, v := range header.Values("Vary") {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), name) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", name)
+}
+
+// notFoundResponseWriter discards the 404 response, so it can be replaced.
+type notFoundResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	notFound    bool
+}
+
+func (w *notFoundResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	if status == http.StatusNotFound {
+		w.notFound = true
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *notFoundResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.notFound {
+		return len(b), nil
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (w *notFoundResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.notFound {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped http.ResponseWriter.
+func (w *notFoundResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// acceptsEncoding reports whether the encoding is accepted by the Accept-Encoding header.
+func acceptsEncoding(acceptEncoding string, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params := part, ""
+		if i := strings.Index(part, ";"); i >= 0 {
+			name, params = part[:i], strings.TrimSpace(part[i+1:])
+		}
+
+		if strings.EqualFold(strings.TrimSpace(name), encoding) {
+			return params != "q=0" && params != "q=0.0"
+		}
+	}
+
+	return false
+}
+
+// rootFS is the file system of the directory, it denies the files which are
+// resolved outside the directory by the symlinks.
+type rootFS string
+
+// Open implements fs.FS interface.
+func (dir rootFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) || strings.ContainsAny(name, `\:`) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	root, err := filepath.Abs(string(dir))
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	file, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if file != root && !strings.HasPrefix(file, root+string(filepath.Separator)) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+
+	return os.Open(file)
+}
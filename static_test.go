@@ -0,0 +1,285 @@
+package gmicro
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticHandler(t *testing.T) {
+	var should = require.New(t)
+	files := fstest.MapFS{
+		"index.html":                {Data: []byte("<html>index</html>"), ModTime: time.Now()},
+		"assets/app.3f2a9c1d.js":    {Data: []byte("console.log('app')")},
+		"assets/app.3f2a9c1d.js.br": {Data: []byte("brotli")},
+		"assets/style.css":          {Data: []byte("body{}")},
+		"assets/style.css.gz":       {Data: []byte("gzip")},
+		"docs/index.html":           {Data: []byte("<html>docs</html>")},
+	}
+
+	h := newStaticHandler(Static{FS: files, Prefix: "/web", SPAFallback: true, MaxAge: time.Hour, Precompressed: true}).
+		handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/web/users/") {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.WriteHeader(http.StatusTeapot)
+		}))
+
+	serve := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodGet, "/web/", nil)
+	should.Equal(http.StatusOK, w.Code)
+	should.Equal("<html>index</html>", w.Body.String())
+	should.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	should.Equal("public, max-age=3600", w.Header().Get("Cache-Control"))
+
+	etag := w.Header().Get("ETag")
+	should.NotEmpty(etag)
+	w = serve(http.MethodGet, "/web/index.html", map[string]string{"If-None-Match": etag})
+	should.Equal(http.StatusNotModified, w.Code)
+
+	w = serve(http.MethodGet, "/web/assets/app.3f2a9c1d.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	should.Equal("brotli", w.Body.String())
+	should.Equal(EncodingBrotli, w.Header().Get("Content-Encoding"))
+	should.Contains(w.Header().Get("Content-Type"), "javascript")
+	should.Equal(immutableCacheControl, w.Header().Get("Cache-Control"))
+
+	w = serve(http.MethodGet, "/web/assets/style.css", map[string]string{"Accept-Encoding": "gzip"})
+	should.Equal("gzip", w.Body.String())
+	should.Equal(EncodingGzip, w.Header().Get("Content-Encoding"))
+
+	w = serve(http.MethodGet, "/web/assets/style.css", nil)
+	should.Equal("body{}", w.Body.String())
+	should.Empty(w.Header().Get("Content-Encoding"))
+	should.Equal("Accept-Encoding", w.Header().Get("Vary"))
+
+	// the directory is redirected to the path with the trailing slash
+	w = serve(http.MethodGet, "/web/docs?lang=en", nil)
+	should.Equal(http.StatusMovedPermanently, w.Code)
+	should.Equal("/web/docs/?lang=en", w.Header().Get("Location"))
+	w = serve(http.MethodGet, "/web", nil)
+	should.Equal(http.StatusMovedPermanently, w.Code)
+	should.Equal("/web/", w.Header().Get("Location"))
+	w = serve(http.MethodGet, "/web/docs/", nil)
+	should.Equal("<html>docs</html>", w.Body.String())
+
+	// the SPA routes requested by the browsers fall back to the index when next responds 404
+	w = serve(http.MethodGet, "/web/users/1", map[string]string{"Accept": "text/html,application/xhtml+xml"})
+	should.Equal(http.StatusOK, w.Code)
+	should.Equal("<html>index</html>", w.Body.String())
+	should.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	w = serve(http.MethodGet, "/web/api", map[string]string{"Accept": "text/html"})
+	should.Equal(http.StatusTeapot, w.Code)
+
+	for _, c := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/web/users/1", http.StatusNotFound},
+		{http.MethodPost, "/web/index.html", http.StatusTeapot},
+		{http.MethodGet, "/index.html", http.StatusTeapot},
+		{http.MethodGet, "/web/../index.html", http.StatusTeapot},
+	} {
+		w = serve(c.method, c.path, nil)
+		should.Equal(c.status, w.Code, c.path)
+	}
+}
+
+func TestIsHashedAsset(t *testing.T) {
+	assert.True(t, isHashedAsset("assets/app.3f2a9c1d.js"))
+	assert.True(t, isHashedAsset("index-B1x9kQ2z.css"))
+	assert.True(t, isHashedAsset("app.3f2a9c1d.js.gz"))
+	assert.False(t, isHashedAsset("index.html"))
+	assert.False(t, isHashedAsset("my-document.pdf"))
+	assert.False(t, isHashedAsset("report-20240101.pdf"))
+	assert.False(t, isHashedAsset("my-component1.js"))
+	assert.False(t, isHashedAsset("release.notes2024.txt"))
+	assert.False(t, isHashedAsset("chapter-section1.html"))
+}
+
+func TestStaticSPAFallback(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithStatic(Static{FS: fstest.MapFS{"index.html": {Data: []byte("<html>index</html>")}}, SPAFallback: true}),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &errorGreeterService{})
+
+	addr := startTestServer(t, s)
+
+	// the gateway routes are not replaced by the index even if they are requested by the browsers
+	for path, body := range map[string]string{
+		"/v1/say/daheige": `{"name":"hello,daheige","message":"call ok"}`,
+		"/users/1":        "<html>index</html>",
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+		should.NoError(err)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		res, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+		b, err := io.ReadAll(res.Body)
+		should.NoError(err)
+		res.Body.Close()
+		should.Equal(http.StatusOK, res.StatusCode, path)
+		if strings.HasPrefix(path, "/v1/") {
+			should.JSONEq(body, string(b), path)
+		} else {
+			should.Equal(body, string(b), path)
+		}
+	}
+}
+
+func TestStaticAccess(t *testing.T) {
+	var should = require.New(t)
+	dir := t.TempDir()
+	should.NoError(os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0o600))
+
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithStaticDir(dir),
+		WithStaticAccess(true),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &errorGreeterService{})
+
+	addr := startTestServer(t, s)
+
+	for path, body := range map[string]string{
+		"/hello.txt":      "hello",
+		"/v1/say/daheige": `{"name":"hello,daheige","message":"call ok"}`,
+	} {
+		res, err := http.Get("http://" + addr + path)
+		should.NoError(err)
+		b, err := io.ReadAll(res.Body)
+		should.NoError(err)
+		res.Body.Close()
+		should.Equal(http.StatusOK, res.StatusCode, path)
+		if strings.HasPrefix(path, "/v1/") {
+			should.JSONEq(body, string(b), path)
+		} else {
+			should.Equal(body, string(b), path)
+		}
+	}
+}
+
+func TestStaticPolicy(t *testing.T) {
+	var should = require.New(t)
+	outside := t.TempDir()
+	should.NoError(os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o600))
+
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"app.js":         "app",
+		"app.js.map":     "map",
+		".env":           "TOKEN=1",
+		".git/config":    "config",
+		"docs/guide.txt": "guide",
+		"docs/<b>.txt":   "escaped",
+	} {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		should.NoError(os.MkdirAll(filepath.Dir(file), 0o700))
+		should.NoError(os.WriteFile(file, []byte(content), 0o600))
+	}
+
+	should.NoError(os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "escape.txt")))
+	should.NoError(os.Symlink(outside, filepath.Join(root, "outside")))
+	should.NoError(os.Symlink(filepath.Join(root, "app.js"), filepath.Join(root, "link.js")))
+
+	s := NewService(WithStatic(Static{
+		Root:             root,
+		Extensions:       []string{".js", ".txt"},
+		DirectoryListing: true,
+	}))
+
+	serve := func(target string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = target
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		s.ServeFile(w, req, nil)
+		return w
+	}
+
+	for target, body := range map[string]string{
+		"/app.js":         "app",
+		"/link.js":        "app",
+		"/docs/guide.txt": "guide",
+	} {
+		w := serve(target, "")
+		should.Equal(http.StatusOK, w.Code, target)
+		should.Equal(body, w.Body.String(), target)
+	}
+
+	for _, target := range []string{
+		"/../" + filepath.Base(outside) + "/secret.txt",
+		"/docs/../../secret.txt",
+		"/escape.txt",
+		"/outside/secret.txt",
+		"/.env",
+		"/.git/config",
+		"/app.js.map",
+		`/docs\..\..\secret.txt`,
+		"/missing.txt",
+	} {
+		w := serve(target, "")
+		should.Equal(http.StatusNotFound, w.Code, target)
+		should.NotContains(w.Body.String(), "secret", target)
+	}
+
+	w := serve("/docs/", "text/html")
+	should.Equal(http.StatusOK, w.Code)
+	should.Contains(w.Body.String(), `<a href="/docs/guide.txt">guide.txt</a>`)
+	should.Contains(w.Body.String(), "&lt;b&gt;.txt")
+
+	w = serve("/", "application/json")
+	should.Equal(http.StatusOK, w.Code)
+	should.JSONEq(`["app.js","docs","link.js"]`, entryNames(t, w.Body.Bytes()))
+}
+
+func entryNames(t *testing.T, b []byte) string {
+	var entries []DirectoryEntry
+	require.NoError(t, json.Unmarshal(b, &entries))
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+
+	b, err := json.Marshal(names)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestServeFileWithoutStaticDir(t *testing.T) {
+	s := NewService(WithStaticAccess(true))
+	assert.Nil(t, s.static)
+
+	req := httptest.NewRequest(http.MethodGet, "/go.mod", nil)
+	w := httptest.NewRecorder()
+	s.ServeFile(w, req, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
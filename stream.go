@@ -0,0 +1,433 @@
+package gmicro
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// the default interval of the SSE comments and WebSocket pings
+	defaultStreamHeartbeat = 15 * time.Second
+
+	// the max length of the WebSocket close reason
+	maxCloseReasonLength = 123
+
+	// the WebSocket close code is closeCodeGRPCBase plus the gRPC code when the RPC fails
+	closeCodeGRPCBase = 4000
+)
+
+// StreamRoute exposes the gRPC streaming method on the http gateway mux.
+// The server streaming method is served as Server-Sent Events, the request message is
+// the JSON body of POST or the "message" query parameter of GET, eg:
+//
+//	GET /v1/hello/stream?message={"name":"daheige"}
+//
+// and every response message is sent as a "data" event, the stream ends with an "end" event
+// or an "error" event with the code and message.
+// All the streaming methods can be served over WebSocket, every text message is a JSON message,
+// the client sends an empty message to close the request stream, and the server closes
+// the connection with code 1000 or 4000 plus the gRPC code when the RPC fails.
+// The write deadline of the SSE response is extended by every write, so the stream
+// is not cut by the WriteTimeout of HTTPServer as long as the heartbeat is running.
+type StreamRoute struct {
+	Path       string // http path, eg: /v1/hello/stream
+	FullMethod string // gRPC full method name, eg: /App.Grpc.Hello.GreeterService/SayHelloStream
+}
+
+// appStreamRoutes registers the stream routes on the gateway mux.
+func (s *Service) appStreamRoutes() error {
+	if len(s.streamRoutes) > 0 {
+		registerJSONCodec()
+	}
+
+	methods := make(map[string]grpc.MethodInfo)
+	for name, info := range s.GRPCServer.GetServiceInfo() {
+		for _, method := range info.Methods {
+			methods["/"+name+"/"+method.Name] = method
+		}
+	}
+
+	for _, route := range s.streamRoutes {
+		info, ok := methods[route.FullMethod]
+		if !ok || (!info.IsClientStream && !info.IsServerStream) {
+			s.logger.Printf("add stream router error, %s is not a streaming method", route.FullMethod)
+			return fmt.Errorf("stream route %s: %s is not a streaming method", route.Path, route.FullMethod)
+		}
+
+		if !strings.HasPrefix(route.Path, "/") {
+			route.Path = "/" + route.Path
+		}
+
+		handler := s.streamHandler(route.FullMethod, info)
+		methods := []string{http.MethodGet}
+		if !info.IsClientStream {
+			methods = append(methods, http.MethodPost)
+		}
+
+		for _, method := range methods {
+			if err := s.mux.HandlePath(method, route.Path, handler); err != nil {
+				s.logger.Printf("add stream router error:%s,current method:%s path:%s invalid", err.Error(),
+					method, route.Path)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) streamHandler(fullMethod string, info grpc.MethodInfo) gRuntime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		if websocket.IsWebSocketUpgrade(r) {
+			s.serveWebSocket(w, r, fullMethod, info)
+			return
+		}
+
+		if info.IsClientStream {
+			http.Error(w, "the client streaming method must be called over WebSocket", http.StatusBadRequest)
+			return
+		}
+
+		s.serveSSE(w, r, fullMethod)
+	}
+}
+
+// serveSSE calls the server streaming method and sends the response messages as Server-Sent Events.
+// The heartbeat comments keep the connection alive, and the gRPC stream is canceled
+// when the client goes away.
+func (s *Service) serveSSE(w http.ResponseWriter, r *http.Request, fullMethod string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	msg := []byte(r.URL.Query().Get("message"))
+	if r.Method == http.MethodPost {
+		var err error
+		msg, err = io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(msg) == 0 {
+		msg = []byte("{}")
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // disable the buffering of nginx
+	w.WriteHeader(http.StatusOK)
+	s.extendWriteDeadline(w)
+	flusher.Flush()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var mu sync.Mutex
+	write := func(b []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		s.extendWriteDeadline(w)
+		_, err := w.Write(b)
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer s.recovery()
+		defer wg.Done()
+
+		ticker := time.NewTicker(s.streamHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_ = write([]byte(": ping\n\n"))
+			}
+		}
+	}()
+
+	mw := &grpcMessageWriter{header: make(http.Header), onMessage: func(m []byte) error {
+		return write(sseEvent("", m))
+	}}
+
+	req := grpcStreamRequest(r.WithContext(ctx), fullMethod, io.NopCloser(bytes.NewReader(grpcFrame(msg))))
+	s.GRPCServer.ServeHTTP(mw, req)
+	cancel()
+	wg.Wait()
+
+	code, message := mw.status()
+	if code == codes.OK {
+		_ = write(sseEvent("end", []byte("{}")))
+		return
+	}
+
+	b, _ := json.Marshal(&connectError{Code: connectCode(code), Message: message})
+	_ = write(sseEvent("error", b))
+}
+
+// extendWriteDeadline extends the write deadline of the long-lived response to the next
+// heartbeat plus the WriteTimeout of HTTPServer, the deadline is cleared without WriteTimeout.
+func (s *Service) extendWriteDeadline(w http.ResponseWriter) {
+	var deadline time.Time
+	if s.HTTPServer.WriteTimeout > 0 {
+		deadline = time.Now().Add(s.streamHeartbeat + s.HTTPServer.WriteTimeout)
+	}
+
+	_ = setWriteDeadline(w, deadline)
+}
+
+// writeDeadliner is implemented by the http.ResponseWriter of net/http since go1.20.
+type writeDeadliner interface {
+	SetWriteDeadline(deadline time.Time) error
+}
+
+// setWriteDeadline sets the write deadline of the connection of w, the wrappers of w are unwrapped.
+func setWriteDeadline(w http.ResponseWriter, deadline time.Time) error {
+	for {
+		switch t := w.(type) {
+		case writeDeadliner:
+			return t.SetWriteDeadline(deadline)
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return http.ErrNotSupported
+		}
+	}
+}
+
+// serveWebSocket calls the streaming method over WebSocket, the request messages are
+// sent to the gRPC stream as they are received, the client is blocked when the gRPC stream
+// does not receive them, and the response messages are sent in the same way.
+func (s *Service) serveWebSocket(w http.ResponseWriter, r *http.Request, fullMethod string, info grpc.MethodInfo) {
+	upgrader := websocket.Upgrader{}
+	if s.cors != nil {
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || s.cors.allowedOrigins().allowed(origin)
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Printf("websocket upgrade error: %s\n", err.Error())
+		return
+	}
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// the client is considered gone if no message or pong is received within 2 heartbeats
+	timeout := 2 * s.streamHeartbeat
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	pr, pw := io.Pipe()
+	readDone := make(chan struct{})
+	go func() {
+		defer s.recovery()
+		defer close(readDone)
+
+		closed := false
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				cancel()
+				return
+			}
+
+			_ = conn.SetReadDeadline(time.Now().Add(timeout))
+			if closed {
+				continue
+			}
+
+			if len(msg) > 0 {
+				if _, err = pw.Write(grpcFrame(msg)); err != nil {
+					closed = true
+					continue
+				}
+			}
+
+			// the empty message or the only message of the server streaming method closes the request stream
+			if len(msg) == 0 || !info.IsClientStream {
+				closed = true
+				_ = pw.Close()
+			}
+		}
+	}()
+
+	go func() {
+		defer s.recovery()
+
+		ticker := time.NewTicker(s.streamHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)) != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	mw := &grpcMessageWriter{header: make(http.Header), onMessage: func(m []byte) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+		err := conn.WriteMessage(websocket.TextMessage, m)
+		if err != nil {
+			cancel()
+		}
+
+		return err
+	}}
+
+	s.GRPCServer.ServeHTTP(mw, grpcStreamRequest(r.WithContext(ctx), fullMethod, pr))
+	_ = pr.Close()
+
+	closeCode, reason := websocket.CloseNormalClosure, ""
+	if code, message := mw.status(); code != codes.OK {
+		closeCode, reason = closeCodeGRPCBase+int(code), message
+		if len(reason) > maxCloseReasonLength {
+			reason = reason[:maxCloseReasonLength]
+		}
+	}
+
+	// wait for the close message of the client
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason),
+		time.Now().Add(time.Second))
+	select {
+	case <-readDone:
+	case <-time.After(time.Second):
+	}
+}
+
+// grpcStreamRequest returns the gRPC request of the JSON messages in body.
+func grpcStreamRequest(r *http.Request, fullMethod string, body io.ReadCloser) *http.Request {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodPost
+	req.URL.Path, req.URL.RawPath, req.URL.RawQuery = fullMethod, "", ""
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	for _, k := range []string{"Connection", "Upgrade", "Content-Length", "Sec-Websocket-Key",
+		"Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol"} {
+		req.Header.Del(k)
+	}
+
+	req.Header.Set("Content-Type", "application/grpc+"+jsonCodecName)
+	req.ContentLength = -1
+	req.Body = body
+	return req
+}
+
+// grpcFrame returns the gRPC message frame of msg.
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// sseEvent returns the Server-Sent Event of data, the event type is omitted if it is empty.
+func sseEvent(event string, data []byte) []byte {
+	var b strings.Builder
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// grpcMessageWriter is the http.ResponseWriter passed to the gRPC server,
+// it splits the response body into the messages and passes them to onMessage.
+type grpcMessageWriter struct {
+	header        http.Header
+	headerWritten map[string]bool
+	buf           []byte
+	onMessage     func([]byte) error
+}
+
+// Header implements http.ResponseWriter interface.
+func (mw *grpcMessageWriter) Header() http.Header {
+	return mw.header
+}
+
+// WriteHeader implements http.ResponseWriter interface.
+func (mw *grpcMessageWriter) WriteHeader(int) {
+	if mw.headerWritten != nil {
+		return
+	}
+
+	mw.headerWritten = make(map[string]bool, len(mw.header))
+	for k := range mw.header {
+		mw.headerWritten[k] = true
+	}
+}
+
+// Write implements http.ResponseWriter interface.
+func (mw *grpcMessageWriter) Write(b []byte) (int, error) {
+	mw.WriteHeader(http.StatusOK)
+	mw.buf = append(mw.buf, b...)
+	for len(mw.buf) >= 5 {
+		size := int(binary.BigEndian.Uint32(mw.buf[1:5]))
+		if len(mw.buf) < 5+size {
+			break
+		}
+
+		msg := mw.buf[5 : 5+size]
+		mw.buf = mw.buf[5+size:]
+		if err := mw.onMessage(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// Flush implements http.Flusher interface.
+func (mw *grpcMessageWriter) Flush() {
+	mw.WriteHeader(http.StatusOK)
+}
+
+// status returns the status of the gRPC response.
+func (mw *grpcMessageWriter) status() (codes.Code, string) {
+	mw.WriteHeader(http.StatusOK)
+	return grpcStatus(grpcTrailers(mw.header, mw.headerWritten))
+}
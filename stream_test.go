@@ -0,0 +1,161 @@
+package gmicro
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestStreamRoute(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithStreamHeartbeat(50*time.Millisecond),
+		WithStreamRoute(
+			StreamRoute{Path: "/v1/repeat", FullMethod: "/gmicro.test.TestService/Repeat"},
+			StreamRoute{Path: "/v1/wait", FullMethod: "/gmicro.test.TestService/Wait"},
+			StreamRoute{Path: "v1/echo", FullMethod: "/gmicro.test.TestService/Echo"},
+		),
+	)
+	s.GRPCServer.RegisterService(&testServiceDesc, struct{}{})
+
+	addr := startTestServer(t, s)
+
+	// server streaming as SSE
+	res, err := http.Get("http://" + addr + "/v1/repeat?message=" + url.QueryEscape(`{"name":"daheige"}`))
+	should.NoError(err)
+	should.Equal("text/event-stream", res.Header.Get("Content-Type"))
+	b := new(strings.Builder)
+	_, err = bufio.NewReader(res.Body).WriteTo(b)
+	should.NoError(err)
+	res.Body.Close()
+	should.Equal(strings.Repeat("data: {\"name\":\"daheige\"}\n\n", 3)+
+		"event: error\ndata: {\"code\":\"aborted\",\"message\":\"stream aborted\"}\n\n", b.String())
+
+	res, err = http.Post("http://"+addr+"/v1/repeat", "application/json", strings.NewReader(`{"name":"hello"}`))
+	should.NoError(err)
+	res.Body.Close()
+	should.Equal(http.StatusOK, res.StatusCode)
+
+	// the heartbeat is sent and the gRPC stream is canceled when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/v1/wait", nil)
+	should.NoError(err)
+	res, err = http.DefaultClient.Do(req)
+	should.NoError(err)
+	reader := bufio.NewReader(res.Body)
+	line, err := reader.ReadString('\n')
+	should.NoError(err)
+	should.Equal("data: {}\n", line)
+	_, _ = reader.ReadString('\n')
+	line, err = reader.ReadString('\n')
+	should.NoError(err)
+	should.Equal(": ping\n", line)
+	cancel()
+	res.Body.Close()
+	select {
+	case err = <-testStreamCanceled:
+		should.ErrorIs(err, context.Canceled)
+	case <-time.After(3 * time.Second):
+		should.FailNow("the gRPC stream is not canceled")
+	}
+
+	// the client streaming method requires WebSocket
+	res, err = http.Get("http://" + addr + "/v1/echo")
+	should.NoError(err)
+	res.Body.Close()
+	should.Equal(http.StatusBadRequest, res.StatusCode)
+
+	// bidi streaming over WebSocket
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/v1/echo", nil)
+	should.NoError(err)
+	for _, name := range []string{"a", "b"} {
+		should.NoError(conn.WriteMessage(websocket.TextMessage, []byte(`{"name":"`+name+`"}`)))
+		_, msg, err := conn.ReadMessage()
+		should.NoError(err)
+		should.JSONEq(`{"name":"`+name+`"}`, string(msg))
+	}
+
+	should.NoError(conn.WriteMessage(websocket.TextMessage, nil))
+	_, _, err = conn.ReadMessage()
+	should.True(websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	conn.Close()
+
+	// server streaming over WebSocket
+	conn, _, err = websocket.DefaultDialer.Dial("ws://"+addr+"/v1/repeat", nil)
+	should.NoError(err)
+	should.NoError(conn.WriteMessage(websocket.TextMessage, []byte(`{"name":"daheige"}`)))
+	for i := 0; i < 3; i++ {
+		_, msg, err := conn.ReadMessage()
+		should.NoError(err)
+		should.JSONEq(`{"name":"daheige"}`, string(msg))
+	}
+
+	_, _, err = conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	should.True(ok, err)
+	should.Equal(closeCodeGRPCBase+int(codes.Aborted), closeErr.Code)
+	should.Equal("stream aborted", closeErr.Text)
+	conn.Close()
+}
+
+func TestStreamRouteWriteTimeout(t *testing.T) {
+	var should = require.New(t)
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithStreamHeartbeat(50*time.Millisecond),
+		WithStreamRoute(StreamRoute{Path: "/v1/wait", FullMethod: "/gmicro.test.TestService/Wait"}),
+	)
+	s.HTTPServer.WriteTimeout = 200 * time.Millisecond
+	s.GRPCServer.RegisterService(&testServiceDesc, struct{}{})
+
+	addr := startTestServer(t, s)
+
+	// the SSE stream outlives the WriteTimeout while the heartbeat is running
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/v1/wait", nil)
+	should.NoError(err)
+	res, err := http.DefaultClient.Do(req)
+	should.NoError(err)
+	defer res.Body.Close()
+
+	reader := bufio.NewReader(res.Body)
+	deadline := time.Now().Add(4 * s.HTTPServer.WriteTimeout)
+	for time.Now().Before(deadline) {
+		_, err = reader.ReadString('\n')
+		should.NoError(err)
+	}
+
+	cancel()
+	select {
+	case <-testStreamCanceled:
+	case <-time.After(3 * time.Second):
+		should.FailNow("the gRPC stream is not canceled")
+	}
+}
+
+func TestStreamRouteError(t *testing.T) {
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithStreamRoute(StreamRoute{Path: "/v1/say", FullMethod: pb.GreeterService_SayHello_FullMethodName}),
+	)
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &greeterService{})
+
+	assert.Error(t, s.StartGRPCAndHTTPServer(0))
+}
+
+func TestSSEEvent(t *testing.T) {
+	assert.Equal(t, "data: a\n\n", string(sseEvent("", []byte("a"))))
+	assert.Equal(t, "event: end\ndata: a\ndata: b\n\n", string(sseEvent("end", []byte("a\nb"))))
+}
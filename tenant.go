@@ -0,0 +1,567 @@
+package gmicro
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	gRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ReasonTenantRequired the tenant of the request can not be resolved when it is required.
+	ReasonTenantRequired = "TENANT_REQUIRED"
+
+	// ReasonUnknownTenant the tenant is not configured in Tenancy.Tenants.
+	ReasonUnknownTenant = "UNKNOWN_TENANT"
+
+	// ReasonTenantForbidden the method is not allowed by the policy of the tenant.
+	ReasonTenantForbidden = "TENANT_FORBIDDEN"
+
+	// ReasonTenantRateLimited the request exceeds the rate limit of the tenant.
+	ReasonTenantRateLimited = "TENANT_RATE_LIMITED"
+
+	// ReasonInvalidToken the bearer token can not be parsed by the JWTParser.
+	ReasonInvalidToken = "INVALID_TOKEN"
+)
+
+// the metadata key of the tenant forwarded by the gateway, it is trusted only if the request
+// carries the gateway token of the Service.
+const tenantMDKey = "gmicro-tenant"
+
+// TenantID is the context key of the tenant resolved by Tenancy, the tenant is also
+// set to the x-tenant-id incoming metadata, so it is propagated by the Propagator.
+var TenantID = NewKey[string]("x-tenant-id")
+
+// TenantResolver resolves the tenant of the http requests and the gRPC requests,
+// the empty tenant is returned if the request carries no tenant.
+type TenantResolver interface {
+	ResolveHTTP(r *http.Request) (string, error)
+	ResolveGRPC(ctx context.Context) (string, error)
+}
+
+// tenantPathStripper is implemented by the resolver which removes the tenant from the request path.
+type tenantPathStripper interface {
+	stripPath(r *http.Request, tenant string) *http.Request
+}
+
+// TenantFromHeader returns a TenantResolver which reads the tenant from the http header
+// or the gRPC metadata of name, eg: X-Tenant-Id.
+func TenantFromHeader(name string) TenantResolver {
+	return headerTenantResolver(name)
+}
+
+type headerTenantResolver string
+
+func (h headerTenantResolver) ResolveHTTP(r *http.Request) (string, error) {
+	return strings.TrimSpace(r.Header.Get(string(h))), nil
+}
+
+func (h headerTenantResolver) ResolveGRPC(ctx context.Context) (string, error) {
+	values := GetIncomingMD(ctx).Get(string(h))
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	return strings.TrimSpace(values[0]), nil
+}
+
+// JWTParser verifies the jwt token and returns its claims.
+type JWTParser func(token string) (map[string]interface{}, error)
+
+// TenantFromJWTClaim returns a TenantResolver which reads the tenant from the string claim
+// of the bearer token in the Authorization header or the authorization metadata,
+// the token is verified by parse, eg: a function based on github.com/golang-jwt/jwt.
+func TenantFromJWTClaim(claim string, parse JWTParser) TenantResolver {
+	return &jwtTenantResolver{claim: claim, parse: parse}
+}
+
+type jwtTenantResolver struct {
+	claim string
+	parse JWTParser
+}
+
+func (j *jwtTenantResolver) ResolveHTTP(r *http.Request) (string, error) {
+	return j.resolve(r.Header.Get("Authorization"))
+}
+
+func (j *jwtTenantResolver) ResolveGRPC(ctx context.Context) (string, error) {
+	values := GetIncomingMD(ctx).Get("authorization")
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	return j.resolve(values[0])
+}
+
+func (j *jwtTenantResolver) resolve(authorization string) (string, error) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", nil
+	}
+
+	claims, err := j.parse(strings.TrimSpace(authorization[len(prefix):]))
+	if err != nil {
+		return "", Unauthenticated(ReasonInvalidToken, "invalid bearer token")
+	}
+
+	tenant, _ := claims[j.claim].(string)
+	return tenant, nil
+}
+
+// TenantFromSubdomain returns a TenantResolver which reads the tenant from the subdomain of domain,
+// eg: the tenant of acme.example.com is acme when domain is example.com. The :authority metadata
+// is used for the gRPC requests.
+func TenantFromSubdomain(domain string) TenantResolver {
+	return subdomainTenantResolver("." + strings.Trim(strings.ToLower(domain), "."))
+}
+
+type subdomainTenantResolver string
+
+func (d subdomainTenantResolver) ResolveHTTP(r *http.Request) (string, error) {
+	return d.resolve(r.Host), nil
+}
+
+func (d subdomainTenantResolver) ResolveGRPC(ctx context.Context) (string, error) {
+	values := GetIncomingMD(ctx).Get(":authority")
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	return d.resolve(values[0]), nil
+}
+
+func (d subdomainTenantResolver) resolve(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, string(d)) {
+		return ""
+	}
+
+	tenant := strings.TrimSuffix(host, string(d))
+	if strings.Contains(tenant, ".") {
+		return ""
+	}
+
+	return tenant
+}
+
+// TenantFromPathPrefix returns a TenantResolver which reads the tenant from the first segment
+// of the http request path after prefix, the prefix and the tenant are removed from the path
+// before routing, eg: /tenants/acme/v1/say/daheige is routed as /v1/say/daheige when prefix
+// is /tenants/. The gRPC requests have no path prefix, so their tenant is not resolved by it.
+func TenantFromPathPrefix(prefix string) TenantResolver {
+	return pathTenantResolver("/" + strings.Trim(prefix, "/") + "/")
+}
+
+type pathTenantResolver string
+
+func (p pathTenantResolver) ResolveHTTP(r *http.Request) (string, error) {
+	if !strings.HasPrefix(r.URL.Path, string(p)) {
+		return "", nil
+	}
+
+	tenant := strings.TrimPrefix(r.URL.Path, string(p))
+	if i := strings.Index(tenant, "/"); i >= 0 {
+		tenant = tenant[:i]
+	}
+
+	return tenant, nil
+}
+
+func (p pathTenantResolver) ResolveGRPC(context.Context) (string, error) {
+	return "", nil
+}
+
+func (p pathTenantResolver) stripPath(r *http.Request, tenant string) *http.Request {
+	prefix := string(p) + tenant
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		return r
+	}
+
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	r2.URL.RawPath = ""
+	return r2
+}
+
+// TenantPolicy is the rate limit and authorization policy of the tenant.
+type TenantPolicy struct {
+	// RateLimit the rate limit of the tenant, it is disabled when Rate is 0.
+	RateLimit RateLimitConf
+
+	// AllowMethods the methods the tenant can call, all the methods are allowed if it is empty.
+	// The method is the gRPC full method, eg: /App.Grpc.Hello.GreeterService/SayHello, or the
+	// http method and path of the routes using TenantMiddleware, eg: GET /api/users,
+	// the method ending with * matches the methods having its prefix.
+	AllowMethods []string
+
+	// Authorize authorizes the tenant to call the method, the request is rejected if it returns error.
+	Authorize func(ctx context.Context, tenant string, method string) error
+}
+
+// allowed reports whether the method is allowed by AllowMethods.
+func (p *TenantPolicy) allowed(method string) bool {
+	if len(p.AllowMethods) == 0 {
+		return true
+	}
+
+	for _, m := range p.AllowMethods {
+		if m == method || (strings.HasSuffix(m, "*") && strings.HasPrefix(method, strings.TrimSuffix(m, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Tenancy is the config of resolving the tenant of the requests, the tenant is resolved for
+// the gateway requests, the custom routes and the direct gRPC requests, and it is stored
+// in the context by TenantID, written by LoggerFromContext and counted by the tenant metrics.
+type Tenancy struct {
+	// Resolvers the resolvers are tried in order, the first non-empty tenant is used.
+	Resolvers []TenantResolver
+
+	// Required rejects the requests without tenant.
+	Required bool
+
+	// Tenants the policies of the tenants, the tenants not in it are rejected if it is not empty.
+	Tenants map[string]TenantPolicy
+
+	// Default the policy of the tenants not in Tenants, they share the rate limiter of Default.
+	Default TenantPolicy
+}
+
+// tenancy resolves the tenant and applies the policies.
+type tenancy struct {
+	Tenancy
+
+	// mu guards Required, Tenants, Default and limiters, the policies can be changed by reloading config
+	mu       sync.RWMutex
+	limiters map[string]*TokenBucket // the limiters of Tenants, the default limiter is keyed by ""
+}
+
+func newTenancy(c Tenancy) *tenancy {
+	return &tenancy{
+		Tenancy:  c,
+		limiters: make(map[string]*TokenBucket, len(c.Tenants)+1),
+	}
+}
+
+// setPolicies replaces the required flag and the policies of the tenants, it is called when
+// the service reloads. The Authorize functions of the tenants are kept if the new policies
+// have none, and the limiters are updated to the new rate limits.
+func (t *tenancy) setPolicies(c Tenancy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, p := range c.Tenants {
+		if old, ok := t.Tenants[name]; ok && p.Authorize == nil {
+			p.Authorize = old.Authorize
+			c.Tenants[name] = p
+		}
+	}
+
+	if c.Default.Authorize == nil {
+		c.Default.Authorize = t.Default.Authorize
+	}
+
+	t.Required, t.Tenants, t.Default = c.Required, c.Tenants, c.Default
+	for key, limiter := range t.limiters {
+		p := t.Default
+		if key != "" {
+			var ok bool
+			if p, ok = t.Tenants[key]; !ok {
+				delete(t.limiters, key)
+				continue
+			}
+		}
+
+		if p.RateLimit.Rate <= 0 {
+			delete(t.limiters, key)
+			continue
+		}
+
+		limiter.SetRate(p.RateLimit.Rate, p.RateLimit.Burst)
+	}
+}
+
+// check rejects the missing and unknown tenant.
+func (t *tenancy) check(tenant string) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if tenant == "" {
+		if t.Required {
+			return InvalidArgument(ReasonTenantRequired, "tenant is required")
+		}
+
+		return nil
+	}
+
+	if _, ok := t.Tenants[tenant]; !ok && len(t.Tenants) > 0 {
+		return PermissionDenied(ReasonUnknownTenant, "unknown tenant")
+	}
+
+	return nil
+}
+
+// resolveHTTP returns the tenant of the http request and the request routed.
+func (t *tenancy) resolveHTTP(r *http.Request) (string, *http.Request, error) {
+	for _, resolver := range t.Resolvers {
+		tenant, err := resolver.ResolveHTTP(r)
+		if err != nil {
+			return "", r, err
+		}
+
+		if tenant == "" {
+			continue
+		}
+
+		if stripper, ok := resolver.(tenantPathStripper); ok {
+			r = stripper.stripPath(r, tenant)
+		}
+
+		return tenant, r, t.check(tenant)
+	}
+
+	return "", r, t.check("")
+}
+
+// resolveGRPC returns the tenant of the gRPC request, the tenant forwarded by the gateway
+// is trusted, and the forwarded metadata are removed from the returned context.
+func (t *tenancy) resolveGRPC(ctx context.Context) (context.Context, string, error) {
+	md := GetIncomingMD(ctx).Copy()
+	fromGateway, _ := fromGatewayKey.From(ctx)
+
+	var (
+		tenant string
+		err    error
+	)
+	if fromGateway {
+		if values := md.Get(tenantMDKey); len(values) == 1 {
+			tenant = values[0]
+		}
+	} else {
+		for _, resolver := range t.Resolvers {
+			if tenant, err = resolver.ResolveGRPC(ctx); err != nil || tenant != "" {
+				break
+			}
+		}
+	}
+
+	// the x-tenant-id sent by the client is replaced by the resolved tenant,
+	// so only the resolved tenant is propagated to the called services.
+	md.Delete(tenantMDKey)
+	md.Delete(TenantID.String())
+	if tenant != "" {
+		md.Set(TenantID.String(), tenant)
+	}
+
+	ctx = metadata.NewIncomingContext(ctx, md)
+	if err != nil {
+		return ctx, "", err
+	}
+
+	return ctx, tenant, t.check(tenant)
+}
+
+// policy returns the policy of the tenant and its limiter.
+func (t *tenancy) policy(tenant string) (*TenantPolicy, *TokenBucket) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := tenant
+	p, ok := t.Tenants[tenant]
+	if !ok {
+		key, p = "", t.Default
+	}
+
+	if p.RateLimit.Rate <= 0 {
+		return &p, nil
+	}
+
+	limiter, ok := t.limiters[key]
+	if !ok {
+		limiter = NewTokenBucket(p.RateLimit.Rate, p.RateLimit.Burst)
+		t.limiters[key] = limiter
+	}
+
+	return &p, limiter
+}
+
+// authorize applies the rate limit and authorization policy of the tenant to the method.
+func (t *tenancy) authorize(ctx context.Context, tenant string, method string) error {
+	if tenant == "" {
+		return nil
+	}
+
+	p, limiter := t.policy(tenant)
+	if limiter != nil && limiter.Limit() {
+		return ResourceExhausted(ReasonTenantRateLimited, "too many requests of the tenant, please retry later")
+	}
+
+	if !p.allowed(method) {
+		return PermissionDenied(ReasonTenantForbidden, "the tenant is not allowed to call "+method)
+	}
+
+	if p.Authorize != nil {
+		return p.Authorize(ctx, tenant, method)
+	}
+
+	return nil
+}
+
+// metricLabel returns the tenant metric label, the tenants not in Tenants are counted as other
+// even if Tenants is empty, so the clients can not inflate the label values.
+func (t *tenancy) metricLabel(tenant string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if tenant == "" {
+		return "none"
+	}
+
+	if _, ok := t.Tenants[tenant]; !ok {
+		return "other"
+	}
+
+	return tenant
+}
+
+// annotator forwards the tenant resolved by tenantHandler to the gRPC request of the gateway.
+func (t *tenancy) annotator(ctx context.Context, _ *http.Request) metadata.MD {
+	tenant, ok := TenantID.From(ctx)
+	if !ok || tenant == "" {
+		return nil
+	}
+
+	return metadata.Pairs(tenantMDKey, tenant)
+}
+
+// withTenant returns ctx with the tenant, the tenant is also set to the RequestInfo of ctx.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		info.Tenant = tenant
+	}
+
+	return TenantID.With(ctx, tenant)
+}
+
+// tenantHandler resolves the tenant of the http requests, the requests without the required tenant
+// or with the unknown tenant are rejected, the other policies are applied by the gRPC interceptors
+// and TenantMiddleware.
+func (s *Service) tenantHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the clients can not send the forwarded metadata through the gateway
+		r.Header.Del(gRuntime.MetadataHeaderPrefix + tenantMDKey)
+
+		tenant, r, err := s.tenancy.resolveHTTP(r)
+		if err != nil {
+			s.rejectTenant(w, r, tenant, err)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+	})
+}
+
+// rejectTenant renders the tenant error and counts it.
+func (s *Service) rejectTenant(w http.ResponseWriter, r *http.Request, tenant string, err error) {
+	e := FromError(err)
+	tenantRequests.WithLabelValues(s.tenancy.metricLabel(tenant), e.Code.String()).Inc()
+	s.rejectRequest(w, r, gRuntime.HTTPStatusFromCode(e.Code), strings.ToLower(e.Reason), e)
+}
+
+// TenantMiddleware returns a Middleware which applies the rate limit and authorization policy
+// of the tenant to the custom routes, the method of the policy is the http method and path,
+// eg: GET /api/users. It does nothing if the Tenancy is not enabled by WithTenancy.
+func (s *Service) TenantMiddleware() Middleware {
+	return func(next gRuntime.HandlerFunc) gRuntime.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			if s.tenancy == nil {
+				next(w, r, pathParams)
+				return
+			}
+
+			tenant, _ := TenantID.From(r.Context())
+			if err := s.tenancy.authorize(r.Context(), tenant, r.Method+" "+r.URL.Path); err != nil {
+				s.rejectTenant(w, r, tenant, err)
+				return
+			}
+
+			sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			next(sw, r, pathParams)
+			tenantRequests.WithLabelValues(s.tenancy.metricLabel(tenant), httpStatusCode(sw.status).String()).Inc()
+		}
+	}
+}
+
+func (s *Service) tenantUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (reply interface{}, err error) {
+	ctx, tenant, err := s.tenancy.resolveGRPC(ctx)
+	defer func() {
+		tenantRequests.WithLabelValues(s.tenancy.metricLabel(tenant), status.Code(err).String()).Inc()
+	}()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = withTenant(ctx, tenant)
+	if err = s.tenancy.authorize(ctx, tenant, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *Service) tenantStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) (err error) {
+	ctx, tenant, err := s.tenancy.resolveGRPC(ss.Context())
+	defer func() {
+		tenantRequests.WithLabelValues(s.tenancy.metricLabel(tenant), status.Code(err).String()).Inc()
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	ctx = withTenant(ctx, tenant)
+	if err = s.tenancy.authorize(ctx, tenant, info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// httpStatusCode returns the gRPC code of the http status.
+func httpStatusCode(httpStatus int) codes.Code {
+	switch {
+	case httpStatus < 400:
+		return codes.OK
+	case httpStatus == http.StatusBadRequest:
+		return codes.InvalidArgument
+	case httpStatus == http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case httpStatus == http.StatusForbidden:
+		return codes.PermissionDenied
+	case httpStatus == http.StatusNotFound:
+		return codes.NotFound
+	case httpStatus == http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case httpStatus < 500:
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
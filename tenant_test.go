@@ -0,0 +1,183 @@
+package gmicro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daheige/gmicro/v2/example/pb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tenantGreeterService replies the tenant of the request.
+type tenantGreeterService struct {
+	pb.UnimplementedGreeterServiceServer
+}
+
+func (s *tenantGreeterService) SayHello(ctx context.Context, in *pb.HelloReq) (*pb.HelloReply, error) {
+	LoggerFromContext(ctx).Printf("say hello to %s", in.Name)
+	return &pb.HelloReply{Name: in.Name, Message: TenantID.MustFrom(ctx) + "|" +
+		GetStringFromMD(GetIncomingMD(ctx), TenantID)}, nil
+}
+
+func TestTenantResolvers(t *testing.T) {
+	var should = require.New(t)
+	r := httptest.NewRequest(http.MethodGet, "http://acme.example.com:8080/tenants/beta/v1/say/a%2Fb", nil)
+	r.Header.Set("X-Tenant-Id", " gamma ")
+	r.Header.Set("Authorization", "Bearer good")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-tenant-id", "gamma", ":authority", "acme.example.com", "authorization", "bearer bad",
+	))
+
+	header := TenantFromHeader("X-Tenant-Id")
+	tenant, err := header.ResolveHTTP(r)
+	should.NoError(err)
+	should.Equal("gamma", tenant)
+	tenant, err = header.ResolveGRPC(ctx)
+	should.NoError(err)
+	should.Equal("gamma", tenant)
+
+	jwt := TenantFromJWTClaim("tenant", func(token string) (map[string]interface{}, error) {
+		if token != "good" {
+			return nil, errors.New("invalid signature")
+		}
+
+		return map[string]interface{}{"tenant": "delta"}, nil
+	})
+	tenant, err = jwt.ResolveHTTP(r)
+	should.NoError(err)
+	should.Equal("delta", tenant)
+	_, err = jwt.ResolveGRPC(ctx)
+	should.ErrorIs(err, Unauthenticated(ReasonInvalidToken, ""))
+
+	subdomain := TenantFromSubdomain("Example.com")
+	tenant, err = subdomain.ResolveHTTP(r)
+	should.NoError(err)
+	should.Equal("acme", tenant)
+	tenant, err = subdomain.ResolveGRPC(ctx)
+	should.NoError(err)
+	should.Equal("acme", tenant)
+	should.Empty(subdomain.(subdomainTenantResolver).resolve("a.b.example.com"))
+	should.Empty(subdomain.(subdomainTenantResolver).resolve("example.com"))
+
+	prefix := TenantFromPathPrefix("tenants")
+	tenant, err = prefix.ResolveHTTP(r)
+	should.NoError(err)
+	should.Equal("beta", tenant)
+	r2 := prefix.(tenantPathStripper).stripPath(r, tenant)
+	should.Equal("/v1/say/a/b", r2.URL.Path)
+	should.Equal("/tenants/beta/v1/say/a/b", r.URL.Path)
+	tenant, err = prefix.ResolveGRPC(ctx)
+	should.NoError(err)
+	should.Empty(tenant)
+
+	// the tenants sent by the clients are not used as the metric labels if they are not configured
+	should.Equal("other", newTenancy(Tenancy{}).metricLabel("random"))
+	should.Equal("none", newTenancy(Tenancy{}).metricLabel(""))
+	should.Equal("acme", newTenancy(Tenancy{Tenants: map[string]TenantPolicy{"acme": {}}}).metricLabel("acme"))
+}
+
+func TestTenancy(t *testing.T) {
+	var should = require.New(t)
+	logger := &bufferLogger{}
+	s := NewService(
+		WithPreShutdownDelay(0),
+		WithLogger(logger),
+		WithErrorHandler(HTTPErrorHandler),
+		WithHandlerFromEndpoint(pb.RegisterGreeterServiceHandlerFromEndpoint),
+		WithTenancy(Tenancy{
+			Resolvers: []TenantResolver{TenantFromHeader("X-Tenant-Id"), TenantFromPathPrefix("/tenants/")},
+			Required:  true,
+			Tenants: map[string]TenantPolicy{
+				"acme":  {AllowMethods: []string{"/App.Grpc.Hello.GreeterService/*", "GET /tenant"}},
+				"beta":  {RateLimit: RateLimitConf{Rate: 0.001, Burst: 1}},
+				"gamma": {AllowMethods: []string{"/Other/*"}},
+			},
+		}),
+	)
+	s.AddRoute(Route{Method: http.MethodGet, Path: "/tenant", Middlewares: []Middleware{s.TenantMiddleware()},
+		Handler: func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			_, _ = io.WriteString(w, TenantID.MustFrom(r.Context()))
+		}})
+	pb.RegisterGreeterServiceServer(s.GRPCServer, &tenantGreeterService{})
+
+	addr := startTestServer(t, s)
+
+	for _, c := range []struct {
+		path    string
+		headers map[string]string
+		status  int
+		reason  string
+		message string
+	}{
+		{"/v1/say/daheige", nil, http.StatusBadRequest, ReasonTenantRequired, ""},
+		{"/v1/say/daheige", map[string]string{"X-Tenant-Id": "evil"}, http.StatusForbidden, ReasonUnknownTenant, ""},
+		{"/v1/say/daheige", map[string]string{"X-Tenant-Id": "acme"}, http.StatusOK, "", "acme|acme"},
+		{"/tenants/acme/v1/say/daheige", nil, http.StatusOK, "", "acme|acme"},
+		{"/v1/say/daheige", map[string]string{"X-Tenant-Id": "acme", "Grpc-Metadata-Gmicro-Tenant": "beta"},
+			http.StatusOK, "", "acme|acme"},
+		{"/v1/say/daheige", map[string]string{"X-Tenant-Id": "beta"}, http.StatusOK, "", "beta|beta"},
+		{"/v1/say/daheige", map[string]string{"X-Tenant-Id": "beta"}, http.StatusTooManyRequests,
+			ReasonTenantRateLimited, ""},
+		{"/v1/say/daheige", map[string]string{"X-Tenant-Id": "gamma"}, http.StatusForbidden, ReasonTenantForbidden, ""},
+		{"/tenant", map[string]string{"X-Tenant-Id": "acme"}, http.StatusOK, "", ""},
+		{"/tenants/gamma/tenant", nil, http.StatusForbidden, ReasonTenantForbidden, ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+c.path, nil)
+		should.NoError(err)
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+		b, err := io.ReadAll(res.Body)
+		should.NoError(err)
+		res.Body.Close()
+		should.Equal(c.status, res.StatusCode, c.path, c.headers)
+
+		switch {
+		case c.reason != "":
+			var body ErrorBody
+			should.NoError(json.Unmarshal(b, &body))
+			should.Equal(c.reason, body.Error.Reason, c.path, c.headers)
+		case c.message != "":
+			var reply map[string]interface{}
+			should.NoError(json.Unmarshal(b, &reply))
+			should.Equal(c.message, reply["message"], c.path, c.headers)
+		default:
+			should.Equal("acme", string(b))
+		}
+	}
+
+	should.True(logger.contains("tenant:acme say hello to daheige"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tenantRequests.WithLabelValues("beta", codes.OK.String())))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tenantRequests.WithLabelValues("beta", codes.ResourceExhausted.String())))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tenantRequests.WithLabelValues("other", codes.PermissionDenied.String())))
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	should.NoError(err)
+	defer conn.Close()
+
+	client := pb.NewGreeterServiceClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "acme")
+	reply, err := client.SayHello(ctx, &pb.HelloReq{Name: "daheige"})
+	should.NoError(err)
+	should.Equal("acme|acme", reply.Message)
+
+	// the tenant forwarded without the gateway token is not trusted
+	ctx = metadata.AppendToOutgoingContext(context.Background(), tenantMDKey, "acme", gatewayTokenMDKey, "guess")
+	_, err = client.SayHello(ctx, &pb.HelloReq{Name: "daheige"})
+	should.Equal(codes.InvalidArgument, status.Code(err))
+}